@@ -0,0 +1,4 @@
+// Package e2e tests the connector end to end, from a user connecting their
+// Google account to their answers showing up in a sheet. Google is stood in
+// for by googletest, so the tests need no network.
+package e2e
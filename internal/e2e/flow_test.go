@@ -0,0 +1,387 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/handler/httphandler"
+	"github.com/adetunjii/google-sheets-connector/internal/handler/messagehandler"
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google/googletest"
+	"github.com/adetunjii/google-sheets-connector/internal/sink"
+	"github.com/adetunjii/google-sheets-connector/internal/source"
+	"github.com/adetunjii/google-sheets-connector/internal/store/boltdb"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const callbackURL = "https://connector.example.com/api/google-sheets/integrate/callback"
+
+func strPtr(s string) *string { return &s }
+
+// connector is the connector's HTTP and message handlers, talking to a
+// stand-in for Google.
+type connector struct {
+	google   *googletest.Server
+	tokens   *google.TokenStore
+	http     *httphandler.Handler
+	messages *messagehandler.Handler
+	// where file sinks write
+	exports string
+}
+
+func newConnector(t *testing.T) *connector {
+	server := googletest.NewServer("client", "secret")
+	t.Cleanup(server.Close)
+
+	db, err := boltdb.Open(filepath.Join(t.TempDir(), "integrations.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	tokens, err := google.NewTokenStore(db, []google.TokenKey{{ID: "k1", Key: make([]byte, 32)}})
+	require.NoError(t, err)
+
+	appLogger := logger.NewLogger(zap.NewNop().Sugar())
+	// the stand-in has no quota to protect
+	limits := google.DefaultRateLimits
+	limits.ProjectRPS, limits.UserRPS = 1000, 1000
+
+	opts := append(server.ClientOptions(), google.WithTokenStore(tokens), google.WithRateLimits(limits))
+	googleClient := google.NewGoogleClient("client", "secret", []string{"https://www.googleapis.com/auth/spreadsheets"}, callbackURL, appLogger, opts...)
+
+	exports := t.TempDir()
+
+	return &connector{
+		google:   server,
+		tokens:   tokens,
+		http:     httphandler.New(googleClient, db, db, appLogger),
+		messages: messagehandler.New(googleClient, db, db, appLogger, messagehandler.WithSink(model.SinkCSV, sink.NewCSV(exports, appLogger))),
+		exports:  exports,
+	}
+}
+
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// connect goes through the OAuth login of a user as their browser would and
+// returns the ID of the stored token.
+func (c *connector) connect(t *testing.T) string {
+	rec := serve(c.http.OauthGoogle, httptest.NewRequest(http.MethodGet, "/api/google-sheets/integrate?org_id=org&user_id=user", nil))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code, rec.Body.String())
+	cookies := rec.Result().Cookies()
+
+	// the consent screen sends the browser back to the callback
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := browser.Get(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(callback.String(), callbackURL))
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	rec = serve(c.http.OauthGoogleCallback, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	login := map[string]string{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &login))
+	require.Equal(t, "org", login["org_id"])
+	require.NotEmpty(t, login["token_id"])
+	return login["token_id"]
+}
+
+// integrate creates a spreadsheet with the user's token and an integration
+// of the form "form" writing to it, and returns both.
+func (c *connector) integrate(t *testing.T, tokenID string) (*google.SpreadSheet, *model.Integration) {
+	create := func(orgID string) *httptest.ResponseRecorder {
+		return serve(c.http.CreateGoogleSheet, httptest.NewRequest(http.MethodPost, "/api/google-sheets/create",
+			strings.NewReader(`{"title": "answers", "org_id": "`+orgID+`", "token_id": "`+tokenID+`", "columns": [{"field": "respondent_email", "header": "Email"}, {"field": "answer", "header": "Answer"}]}`)))
+	}
+
+	// the token is only good for the organization that logged in
+	rec := create("other")
+	require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+
+	rec = create("org")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	spreadSheet := &google.SpreadSheet{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), spreadSheet))
+
+	body, err := json.Marshal(model.Integration{
+		OrgID:         "org",
+		FormID:        "form",
+		SpreadSheetID: spreadSheet.ID,
+		TokenID:       tokenID,
+		Owner:         "owner@example.com",
+		Columns:       spreadSheet.Columns,
+	})
+	require.NoError(t, err)
+
+	rec = serve(c.http.CreateIntegration, httptest.NewRequest(http.MethodPost, "/api/google-sheets/integrations", strings.NewReader(string(body))))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	integration := &model.Integration{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), integration))
+	return spreadSheet, integration
+}
+
+// answer is a message carrying an answer to the form "form", naming nothing
+// but the form.
+func answer(t *testing.T) []byte {
+	at := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	message, err := json.Marshal(model.GoogleSheetKafkaMessage{
+		Questionnaire: model.QuestionnarieData{
+			FormID:          strPtr("form"),
+			QuestionID:      strPtr("q-1"),
+			QuestionTitle:   strPtr("How was it?"),
+			AnswerID:        strPtr("a-1"),
+			Answer:          strPtr("great"),
+			RespondentID:    strPtr("r-1"),
+			RespondentEmail: strPtr("r@example.com"),
+			OrgID:           strPtr("org"),
+			FormStartDate:   at,
+			CreatedAt:       at,
+		},
+	})
+	require.NoError(t, err)
+	return message
+}
+
+func TestAnswerReachesSheet(t *testing.T) {
+	c := newConnector(t)
+	spreadSheet, _ := c.integrate(t, c.connect(t))
+	require.Equal(t, []string{spreadSheet.ID}, c.google.Sheets.SpreadsheetIDs())
+
+	// answers are POSTed to the webhook, the source of deployments without a
+	// broker
+	secret := []byte("secret")
+	webhook := source.NewWebhook(secret, 30, logger.NewLogger(zap.NewNop().Sugar()))
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- webhook.Run(ctx, c.messages.HandleBatch) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-stopped)
+	})
+
+	message := answer(t)
+
+	// the webhook turns requests away until it runs
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/google-sheets/messages", bytes.NewReader(message))
+		req.Header.Set(source.HeaderSignature, "sha256="+hex.EncodeToString(source.Sign(secret, message)))
+		webhook.ServeHTTP(rec, req)
+		return rec.Code == http.StatusOK
+	}, time.Second, time.Millisecond)
+
+	require.Equal(t, [][]interface{}{
+		{"Email", "Answer"},
+		{"r@example.com", "great"},
+	}, c.google.Sheets.Values(spreadSheet.ID, "form"))
+}
+
+func TestAnswerReachesFile(t *testing.T) {
+	c := newConnector(t)
+
+	// offline organizations export their answers to CSV instead of Google
+	body, err := json.Marshal(model.Integration{
+		OrgID:   "org",
+		FormID:  "form",
+		Sink:    model.SinkCSV,
+		File:    "org/answers",
+		Owner:   "owner@example.com",
+		Columns: []model.ColumnMapping{{Field: "respondent_email", Header: "Email"}, {Field: "answer", Header: "Answer"}},
+	})
+	require.NoError(t, err)
+
+	rec := serve(c.http.CreateIntegration, httptest.NewRequest(http.MethodPost, "/api/google-sheets/integrations", bytes.NewReader(body)))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	// the file is the integration's, whatever the message says
+	message := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(answer(t), &message))
+	message["sink"], message["file"] = model.SinkCSV, "other-org/answers"
+	body, err = json.Marshal(message)
+	require.NoError(t, err)
+
+	acked := false
+	envelope := source.NewEnvelope("1", body, 1,
+		func() error { acked = true; return nil },
+		func(reason error, requeue bool) error { return reason },
+	)
+	require.NoError(t, c.messages.HandleMessage(context.Background(), envelope))
+	require.True(t, acked)
+
+	file, err := os.Open(filepath.Join(c.exports, "org", "answers", "form.csv"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"Email", "Answer"},
+		{"r@example.com", "great"},
+	}, rows)
+	require.NoDirExists(t, filepath.Join(c.exports, "other-org"))
+	require.Empty(t, c.google.Sheets.SpreadsheetIDs())
+}
+
+func TestDisconnectRevokesGrant(t *testing.T) {
+	c := newConnector(t)
+	tokenID := c.connect(t)
+	_, integration := c.integrate(t, tokenID)
+
+	token, err := c.tokens.Load(context.Background(), tokenID)
+	require.NoError(t, err)
+	require.False(t, c.google.Revoked(token.RefreshToken))
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/api/google-sheets/integrations/"+integration.ID+"/connection", nil), map[string]string{"id": integration.ID})
+	rec := serve(c.http.DisconnectIntegration, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	require.True(t, c.google.Revoked(token.RefreshToken))
+	_, err = c.tokens.Load(context.Background(), tokenID)
+	require.ErrorIs(t, err, google.ErrTokenNotFound)
+}
+
+func TestAnswersAreHeldWhilePaused(t *testing.T) {
+	c := newConnector(t)
+	tokenID := c.connect(t)
+	spreadSheet, integration := c.integrate(t, tokenID)
+
+	setStatus := func(status model.IntegrationStatus) {
+		integration.Status = status
+		body, err := json.Marshal(integration)
+		require.NoError(t, err)
+
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/api/google-sheets/integrations/"+integration.ID, bytes.NewReader(body)), map[string]string{"id": integration.ID})
+		rec := serve(c.http.UpdateIntegration, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	setStatus(model.IntegrationPaused)
+
+	// a message naming the spreadsheet and token itself is held all the same
+	km := model.GoogleSheetKafkaMessage{}
+	require.NoError(t, json.Unmarshal(answer(t), &km))
+	km.SpreadSheetID, km.TokenID, km.Columns = spreadSheet.ID, tokenID, spreadSheet.Columns
+	named, err := json.Marshal(km)
+	require.NoError(t, err)
+
+	for i, message := range [][]byte{answer(t), named} {
+		acked := false
+		envelope := source.NewEnvelope(fmt.Sprint(i), message, 1,
+			func() error { acked = true; return nil },
+			func(reason error, requeue bool) error { return reason },
+		)
+		require.NoError(t, c.messages.HandleMessage(context.Background(), envelope))
+		// held rather than retried, so no attempts are used up
+		require.True(t, acked)
+	}
+
+	require.NoError(t, c.messages.ReleaseHeld(context.Background()))
+	// the tab of the form is only added with its first answer
+	require.Empty(t, c.google.Sheets.Values(spreadSheet.ID, "form"))
+
+	setStatus(model.IntegrationActive)
+	require.NoError(t, c.messages.ReleaseHeld(context.Background()))
+	require.Equal(t, [][]interface{}{
+		{"Email", "Answer"},
+		{"r@example.com", "great"},
+		{"r@example.com", "great"},
+	}, c.google.Sheets.Values(spreadSheet.ID, "form"))
+}
+
+func TestIntegrationCredentialsWin(t *testing.T) {
+	c := newConnector(t)
+	spreadSheet, _ := c.integrate(t, c.connect(t))
+
+	// a message looked up by its form can't choose what it is written as
+	km := model.GoogleSheetKafkaMessage{}
+	require.NoError(t, json.Unmarshal(answer(t), &km))
+	km.Credentials, km.ServiceAccountKeyID, km.Subject = model.CredentialServiceAccount, "someone-elses", "admin@example.com"
+	message, err := json.Marshal(km)
+	require.NoError(t, err)
+
+	acked := false
+	envelope := source.NewEnvelope("1", message, 1,
+		func() error { acked = true; return nil },
+		func(reason error, requeue bool) error { return reason },
+	)
+	require.NoError(t, c.messages.HandleMessage(context.Background(), envelope))
+	require.True(t, acked)
+
+	require.Equal(t, [][]interface{}{
+		{"Email", "Answer"},
+		{"r@example.com", "great"},
+	}, c.google.Sheets.Values(spreadSheet.ID, "form"))
+}
+
+func TestServiceAccountKeyStaysWithItsOrganization(t *testing.T) {
+	c := newConnector(t)
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "connector@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})),
+	})
+	require.NoError(t, err)
+
+	rec := serve(c.http.CreateServiceAccountKey, httptest.NewRequest(http.MethodPost, "/api/google-sheets/service-accounts", bytes.NewReader(key)))
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+
+	rec = serve(c.http.CreateServiceAccountKey, httptest.NewRequest(http.MethodPost, "/api/google-sheets/service-accounts?org_id=org", bytes.NewReader(key)))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	stored := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stored))
+
+	// another organization naming its own spreadsheet can't write with the key
+	km := model.GoogleSheetKafkaMessage{}
+	require.NoError(t, json.Unmarshal(answer(t), &km))
+	km.Questionnaire.OrgID = strPtr("other")
+	km.SpreadSheetID = "sheet"
+	km.Credentials, km.ServiceAccountKeyID = model.CredentialServiceAccount, stored["service_account_key_id"].(string)
+	message, err := json.Marshal(km)
+	require.NoError(t, err)
+
+	var nacked error
+	requeued := true
+	envelope := source.NewEnvelope("1", message, 1,
+		func() error { return nil },
+		func(reason error, requeue bool) error { nacked, requeued = reason, requeue; return nil },
+	)
+	require.NoError(t, c.messages.HandleMessage(context.Background(), envelope))
+	require.ErrorIs(t, nacked, google.ErrTokenNotOwned)
+	require.False(t, requeued)
+}
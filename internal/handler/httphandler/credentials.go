@@ -0,0 +1,63 @@
+package httphandler
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/pkg/utils/httputils"
+)
+
+// service account keys are a couple of kilobytes
+const maxServiceAccountKeySize = 64 << 10
+
+// CreateServiceAccountKey stores the JSON key of a service account sent as the
+// request body, for integrations to refer to by the ID in the response. The
+// key is only used for the organization in the org_id query parameter. The
+// comma separated subjects query parameter lists the users the key may act
+// as, without it the key only acts as the service account.
+func (h *Handler) CreateServiceAccountKey(w http.ResponseWriter, r *http.Request) {
+	rw := httputils.NewResponseWriter(w)
+
+	owner := google.TokenOwner{OrgID: r.URL.Query().Get("org_id"), UserID: r.Header.Get("X-User-ID")}
+	if owner.OrgID == "" {
+		rw.Error(google.ErrMissingKeyOrg, http.StatusBadRequest)
+		return
+	}
+
+	key, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxServiceAccountKeySize))
+	if err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	email, err := google.ServiceAccountEmail(key)
+	if err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	subjects := []string{}
+	for _, subject := range strings.Split(r.URL.Query().Get("subjects"), ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			subjects = append(subjects, subject)
+		}
+	}
+
+	id, err := h.googleClient.Tokens().CreateServiceAccountKey(r.Context(), owner, key, subjects)
+	if err != nil {
+		h.logger.Error("failed to store service account key :: stacktrace ::", err)
+		rw.Error(err, http.StatusInternalServerError)
+		return
+	}
+
+	// the spreadsheet has to be shared with this address, unless the account
+	// acts as the users of a domain
+	writeJSON(w, map[string]interface{}{
+		"service_account_key_id": id,
+		"client_email":           email,
+		"org_id":                 owner.OrgID,
+		"subjects":               subjects,
+	}, http.StatusCreated)
+}
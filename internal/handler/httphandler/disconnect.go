@@ -0,0 +1,94 @@
+package httphandler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/internal/store"
+	"github.com/adetunjii/google-sheets-connector/pkg/utils/httputils"
+	"github.com/gorilla/mux"
+)
+
+// DisconnectIntegration revokes the credentials of an integration with Google
+// and marks it disconnected, which holds its answers back until it gets new
+// credentials. Revoking a user's token revokes their whole grant, so every
+// integration using the token is disconnected with it.
+func (h *Handler) DisconnectIntegration(w http.ResponseWriter, r *http.Request) {
+	rw := httputils.NewResponseWriter(w)
+	ctx := r.Context()
+
+	integration, err := h.integrations.Get(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	if integration.Status == model.IntegrationDisconnected {
+		writeJSON(w, integration, http.StatusOK)
+		return
+	}
+
+	filter := store.Filter{ID: integration.ID}
+	detail := "disconnected"
+
+	// service accounts are shared, there is no grant of the integration's own
+	if integration.Credentials != model.CredentialServiceAccount && integration.TokenID != "" {
+		err := h.googleClient.Disconnect(ctx, integration.OrgID, integration.TokenID)
+		if err != nil && !errors.Is(err, google.ErrTokenNotFound) {
+			h.logger.Error("failed to revoke token of integration :: stacktrace ::", err)
+			rw.Error(err, http.StatusBadGateway)
+			return
+		}
+
+		filter = store.Filter{TokenID: integration.TokenID}
+		detail = fmt.Sprintf("token revoked by disconnecting integration %s", integration.ID)
+	}
+
+	affected, err := h.integrations.Modify(ctx, filter, func(a *model.Integration) bool {
+		if a.Status == model.IntegrationDisconnected {
+			return false
+		}
+
+		a.Status = model.IntegrationDisconnected
+		a.TokenID = ""
+		return true
+	})
+	if err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	actor := r.Header.Get("X-User-ID")
+	for _, a := range affected {
+		entry := &model.AuditEntry{
+			IntegrationID: a.ID,
+			OrgID:         a.OrgID,
+			Action:        model.AuditDisconnected,
+			Actor:         actor,
+			Detail:        detail,
+		}
+		if err := h.audit.Record(ctx, entry); err != nil {
+			h.logger.Error("failed to record disconnection :: stacktrace ::", err)
+		}
+
+		if a.ID == integration.ID {
+			integration = a
+		}
+	}
+
+	writeJSON(w, integration, http.StatusOK)
+}
+
+// ListAuditEntries returns what was done to an integration, oldest first.
+func (h *Handler) ListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.audit.AuditEntries(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, entries, http.StatusOK)
+}
@@ -2,11 +2,13 @@ package httphandler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"reflect"
+	"net/url"
 
 	"github.com/adetunjii/google-sheets-connector/internal/model"
 	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/internal/store"
 	"github.com/adetunjii/google-sheets-connector/pkg/logger"
 	"github.com/adetunjii/google-sheets-connector/pkg/utils/httputils"
 )
@@ -14,40 +16,98 @@ import (
 type Handler struct {
 	googleClient      *google.GoogleClient
 	googleSheetClient *google.GoogleSheetClient
+	integrations      store.IntegrationStore
+	audit             store.AuditLog
 	logger            logger.AppLogger
 }
 
-func New(googleClient *google.GoogleClient, logger logger.AppLogger) *Handler {
+func New(googleClient *google.GoogleClient, integrations store.IntegrationStore, audit store.AuditLog, logger logger.AppLogger) *Handler {
 	return &Handler{
 		googleClient: googleClient,
+		integrations: integrations,
+		audit:        audit,
 		logger:       logger,
 	}
 }
 
+// loginCookie keeps the session of a login in the browser until Google sends
+// the user back to the callback.
+const loginCookie = "google_sheets_login"
+
 func (h *Handler) OauthGoogle(w http.ResponseWriter, r *http.Request) {
 	rw := httputils.NewResponseWriter(w)
 
-	url, err := h.googleClient.HandleGoogleLogin()
+	login, err := h.googleClient.HandleGoogleLogin(google.LoginState{
+		OrgID:     r.FormValue("org_id"),
+		UserID:    r.FormValue("user_id"),
+		ReturnURL: r.FormValue("return_url"),
+	})
 	if err != nil {
 		rw.Error(err, http.StatusBadRequest)
+		return
 	}
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    login.Session,
+		Path:     "/api/google-sheets/integrate",
+		Expires:  login.Expires,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		// sent along when Google redirects back to us
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, login.URL, http.StatusTemporaryRedirect)
 }
 
 func (h *Handler) OauthGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	rw := httputils.NewResponseWriter(w)
 
 	code := r.FormValue("code")
-	errorReason := r.FormValue("error_reason")
+	errorReason := r.FormValue("error")
+	if errorReason == "" {
+		errorReason = r.FormValue("error_reason")
+	}
+
+	session := ""
+	if cookie, err := r.Cookie(loginCookie); err == nil {
+		session = cookie.Value
+	}
 
-	resp, err := h.googleClient.HandleGoogleCallback(code, errorReason)
+	// a login is only finished once
+	http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: "/api/google-sheets/integrate", MaxAge: -1})
+
+	token, login, err := h.googleClient.HandleGoogleCallback(r.FormValue("state"), session, code, errorReason)
+	if errors.Is(err, google.ErrInvalidLoginState) {
+		rw.Error(err, http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	// the token stays with us, the client only gets to refer to it
+	tokenID, err := h.googleClient.Tokens().Create(r.Context(), google.TokenOwner{OrgID: login.OrgID, UserID: login.UserID}, token)
+	if err != nil {
+		h.logger.Error("failed to store token :: stacktrace ::", err)
+		rw.Error(err, http.StatusInternalServerError)
+		return
 	}
 
-	bytes, err := json.Marshal(resp)
+	if login.ReturnURL != "" {
+		http.Redirect(w, r, withFragment(login.ReturnURL, "token_id", tokenID), http.StatusFound)
+		return
+	}
+
+	bytes, err := json.Marshal(map[string]string{
+		"token_id": tokenID,
+		"org_id":   login.OrgID,
+		"user_id":  login.UserID,
+	})
 	if err != nil {
 		rw.Error(err, http.StatusInternalServerError)
+		return
 	}
 
 	rw.WriteJSON(bytes)
@@ -60,10 +120,52 @@ func (h *Handler) CreateGoogleSheet(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(spreadSheet); err != nil {
 		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	if err := spreadSheet.Mode.Validate(); err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	// in response mode the question columns are added as questions get answered,
+	// and rows are only found again if their key columns are written
+	switch spreadSheet.Mode {
+	case model.WriteModeResponse:
+		spreadSheet.Columns = google.ResponseColumnMapping(spreadSheet.Columns)
+	case model.WriteModeUpsert:
+		spreadSheet.Columns = google.UpsertColumnMapping(spreadSheet.Columns)
+	default:
+		if len(spreadSheet.Columns) == 0 {
+			spreadSheet.Columns = google.DefaultColumnMapping()
+		}
+	}
+
+	if err := google.ValidateColumnMapping(spreadSheet.Columns); err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
 	}
 
 	// create new client based on the token sent and the sheet title
-	googleSheetClient := google.NewGoogleSheetClient(h.googleClient, spreadSheet.Token, h.logger)
+	credentials, err := h.googleClient.Credentials(google.CredentialRef{
+		Type:                spreadSheet.Credentials,
+		OrgID:               spreadSheet.OrgID,
+		TokenID:             spreadSheet.TokenID,
+		Token:               spreadSheet.Token,
+		ServiceAccountKeyID: spreadSheet.ServiceAccountKeyID,
+		Subject:             spreadSheet.Subject,
+	}, h.logger)
+	if err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	googleSheetClient, err := h.googleClient.SheetClient(r.Context(), credentials, h.logger)
+	if err != nil {
+		rw.Error(err, http.StatusUnauthorized)
+		return
+	}
+	spreadSheet.Token = nil
 	h.googleSheetClient = googleSheetClient
 
 	// create a spreadsheet
@@ -75,16 +177,20 @@ func (h *Handler) CreateGoogleSheet(w http.ResponseWriter, r *http.Request) {
 
 	spreadSheet.ID = s.SpreadsheetId
 	spreadSheet.Url = s.SpreadsheetUrl
-	sheetID := s.Sheets[0].Properties.SheetId
-
-	sheetTitle := s.Sheets[0].Properties.Title
-
-	headers := getSheetHeaders()
-
-	// create column headers
-	if err := googleSheetClient.AppendColumnHeaders(spreadSheet.ID, sheetID, sheetTitle, headers); err != nil {
-		rw.Error(err, http.StatusInternalServerError)
-		return
+	spreadSheet.Parts = []string{s.SpreadsheetId}
+
+	// answers go to a tab per form, so the first one is left empty
+	if spreadSheet.FormID != "" {
+		target := google.Target{
+			SpreadSheetID: spreadSheet.ID,
+			Sheet:         google.FormSheetTitle(spreadSheet.FormID),
+			Columns:       spreadSheet.Columns,
+			Mode:          spreadSheet.Mode,
+		}
+		if err := googleSheetClient.EnsureSheet(target); err != nil {
+			rw.Error(err, http.StatusInternalServerError)
+			return
+		}
 	}
 
 	bytes, err := json.Marshal(spreadSheet)
@@ -105,7 +211,7 @@ func (h *Handler) writeToSheet(w http.ResponseWriter, r *http.Request) {
 		rw.Error(err, http.StatusBadRequest)
 	}
 
-	if err := h.googleSheetClient.WriteToSheet(spreadsheetID, qd); err != nil {
+	if err := h.googleSheetClient.WriteToSheet(spreadsheetID, nil, qd); err != nil {
 		rw.Error(err, http.StatusBadRequest)
 		return
 	}
@@ -119,12 +225,15 @@ func (h *Handler) writeToSheet(w http.ResponseWriter, r *http.Request) {
 	rw.WriteJSON(bytes)
 }
 
-func getSheetHeaders() []string {
-	t := reflect.TypeOf(model.QuestionnarieData{})
-	headers := make([]string, t.NumField())
-	for i := range headers {
-		headers[i] = t.Field(i).Name
+// withFragment sets the fragment of rawURL, which has been checked to parse,
+// to a parameter. Unlike the query, the fragment is neither sent to the server
+// nor passed on in the Referer header.
+func withFragment(rawURL string, key string, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
 	}
 
-	return headers
+	u.Fragment = url.Values{key: {value}}.Encode()
+	return u.String()
 }
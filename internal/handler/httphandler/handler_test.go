@@ -0,0 +1,193 @@
+package httphandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google/sheetstest"
+	"github.com/adetunjii/google-sheets-connector/internal/store/boltdb"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newHandler(t *testing.T) (*Handler, *sheetstest.Fake) {
+	fake := sheetstest.New()
+
+	db, err := boltdb.Open(filepath.Join(t.TempDir(), "integrations.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	appLogger := logger.NewLogger(zap.NewNop().Sugar())
+	googleClient := google.NewGoogleClient("client", "secret", []string{"scope"}, "https://connector.example.com/api/google-sheets/integrate/callback", appLogger,
+		google.WithSheetsAPI(func(*http.Client) (google.SheetsAPI, error) { return fake, nil }),
+	)
+
+	return New(googleClient, db, db, appLogger), fake
+}
+
+func serve(handler http.HandlerFunc, method string, target string, body string, vars map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if vars != nil {
+		req = mux.SetURLVars(req, vars)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestCreateGoogleSheet(t *testing.T) {
+	h, fake := newHandler(t)
+
+	rec := serve(h.CreateGoogleSheet, http.MethodPost, "/api/google-sheets/create",
+		`{"title": "answers", "form_id": "form", "token": {"access_token": "access"}, "columns": [{"field": "answer", "header": "Answer"}], "mode": "upsert"}`, nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	created := &google.SpreadSheet{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), created))
+	require.NotEmpty(t, created.ID)
+	require.Nil(t, created.Token)
+	require.Equal(t, []string{created.ID}, created.Parts)
+
+	// the form's tab is ready for its answers, upsert sheets being keyed by
+	// answer ID and versioned by update time
+	require.Equal(t, [][]interface{}{{"ANSWERID", "UPDATEDAT", "Answer"}}, fake.Values(created.ID, "form"))
+	require.True(t, fake.Format(created.ID, "form", 1, 1).TextFormat.Bold)
+	require.Empty(t, fake.Values(created.ID, "Sheet1"))
+
+	rec = serve(h.CreateGoogleSheet, http.MethodPost, "/api/google-sheets/create", `{"title": "answers", "mode": "sideways"}`, nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(h.CreateGoogleSheet, http.MethodPost, "/api/google-sheets/create", `{"title": "answers"}`, nil)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestIntegrationEndpoints(t *testing.T) {
+	h, _ := newHandler(t)
+
+	rec := serve(h.CreateIntegration, http.MethodPost, "/api/google-sheets/integrations",
+		`{"org_id": "org", "form_id": "form", "spreadsheet_id": "sheet", "owner": "owner@example.com"}`, nil)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	created := &model.Integration{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), created))
+	require.Equal(t, model.IntegrationActive, created.Status)
+	require.Equal(t, []string{"sheet"}, created.Parts)
+
+	rec = serve(h.CreateIntegration, http.MethodPost, "/api/google-sheets/integrations",
+		`{"org_id": "org", "form_id": "form", "spreadsheet_id": "other", "owner": "owner@example.com"}`, nil)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serve(h.CreateIntegration, http.MethodPost, "/api/google-sheets/integrations", `{"org_id": "org"}`, nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	vars := map[string]string{"id": created.ID}
+	created.Status = model.IntegrationPaused
+	// the parts are recorded by the connector, an update leaves them be
+	created.Parts = nil
+	body, err := json.Marshal(created)
+	require.NoError(t, err)
+
+	rec = serve(h.UpdateIntegration, http.MethodPut, "/api/google-sheets/integrations/"+created.ID, string(body), vars)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = serve(h.ListIntegrations, http.MethodGet, "/api/google-sheets/integrations?org_id=org", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	listed := []*model.Integration{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	require.Equal(t, model.IntegrationPaused, listed[0].Status)
+	require.Equal(t, []string{"sheet"}, listed[0].Parts)
+
+	rec = serve(h.DeleteIntegration, http.MethodDelete, "/api/google-sheets/integrations/"+created.ID, "", vars)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = serve(h.GetIntegration, http.MethodGet, "/api/google-sheets/integrations/"+created.ID, "", vars)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestDisconnectIntegration(t *testing.T) {
+	h, _ := newHandler(t)
+
+	rec := serve(h.CreateIntegration, http.MethodPost, "/api/google-sheets/integrations",
+		`{"org_id": "org", "form_id": "form", "spreadsheet_id": "sheet", "owner": "owner@example.com", "credentials": "service_account", "service_account_key_id": "key"}`, nil)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	created := &model.Integration{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), created))
+	vars := map[string]string{"id": created.ID}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/google-sheets/integrations/"+created.ID+"/connection", nil)
+	req.Header.Set("X-User-ID", "admin")
+	rec = httptest.NewRecorder()
+	h.DisconnectIntegration(rec, mux.SetURLVars(req, vars))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	disconnected := &model.Integration{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), disconnected))
+	require.Equal(t, model.IntegrationDisconnected, disconnected.Status)
+
+	rec = serve(h.ListAuditEntries, http.MethodGet, "/api/google-sheets/integrations/"+created.ID+"/audit", "", vars)
+	entries := []*model.AuditEntry{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	require.Len(t, entries, 1)
+	require.Equal(t, model.AuditDisconnected, entries[0].Action)
+	require.Equal(t, "admin", entries[0].Actor)
+}
+
+func TestOauthGoogleChecksState(t *testing.T) {
+	h, _ := newHandler(t)
+
+	rec := serve(h.OauthGoogle, http.MethodGet, "/api/google-sheets/integrate?org_id=org&return_url=/done", "", nil)
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	state := location.Query().Get("state")
+	require.NotEmpty(t, state)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.True(t, cookies[0].HttpOnly)
+
+	// without the cookie of the browser that started the login
+	rec = serve(h.OauthGoogleCallback, http.MethodGet, "/api/google-sheets/integrate/callback?code=code&state="+url.QueryEscape(state), "", nil)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// with it, but a forged state
+	req := httptest.NewRequest(http.MethodGet, "/api/google-sheets/integrate/callback?code=code&state=forged", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.OauthGoogleCallback(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serve(h.OauthGoogle, http.MethodGet, "/api/google-sheets/integrate?return_url="+url.QueryEscape("https://evil.example.com/"), "", nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestCreateServiceAccountKeyRejectsOtherKeys(t *testing.T) {
+	h, _ := newHandler(t)
+
+	rec := serve(h.CreateServiceAccountKey, http.MethodPost, "/api/google-sheets/service-accounts", `{"type": "authorized_user"}`, nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(h.CreateServiceAccountKey, http.MethodPost, "/api/google-sheets/service-accounts", string(bytes.Repeat([]byte("x"), maxServiceAccountKeySize+1)), nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestWithFragment(t *testing.T) {
+	// the token ID stays out of the query, which ends up in logs and Referer headers
+	require.Equal(t, "https://app.example.com/done?tab=1#token_id=abc", withFragment("https://app.example.com/done?tab=1", "token_id", "abc"))
+	require.Equal(t, "/done#token_id=abc", withFragment("/done#old", "token_id", "abc"))
+}
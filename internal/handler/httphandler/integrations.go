@@ -0,0 +1,143 @@
+package httphandler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/internal/store"
+	"github.com/adetunjii/google-sheets-connector/pkg/utils/httputils"
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) ListIntegrations(w http.ResponseWriter, r *http.Request) {
+	rw := httputils.NewResponseWriter(w)
+
+	query := r.URL.Query()
+	filter := store.Filter{
+		OrgID:         query.Get("org_id"),
+		FormID:        query.Get("form_id"),
+		SpreadSheetID: query.Get("spreadsheet_id"),
+	}
+
+	integrations, err := h.integrations.List(r.Context(), filter)
+	if err != nil {
+		h.logger.Error("failed to list integrations :: stacktrace ::", err)
+		rw.Error(err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, integrations, http.StatusOK)
+}
+
+func (h *Handler) CreateIntegration(w http.ResponseWriter, r *http.Request) {
+	rw := httputils.NewResponseWriter(w)
+
+	integration := &model.Integration{}
+	if err := json.NewDecoder(r.Body).Decode(integration); err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	if integration.Status == "" {
+		integration.Status = model.IntegrationActive
+	}
+	// the parts are recorded as the spreadsheet rolls over
+	integration.Parts = nil
+	if integration.SpreadSheetID != "" {
+		integration.Parts = []string{integration.SpreadSheetID}
+	}
+
+	if err := validateIntegration(integration); err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.integrations.Create(r.Context(), integration); err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, integration, http.StatusCreated)
+}
+
+func (h *Handler) GetIntegration(w http.ResponseWriter, r *http.Request) {
+	integration, err := h.integrations.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, integration, http.StatusOK)
+}
+
+func (h *Handler) UpdateIntegration(w http.ResponseWriter, r *http.Request) {
+	rw := httputils.NewResponseWriter(w)
+
+	integration := &model.Integration{}
+	if err := json.NewDecoder(r.Body).Decode(integration); err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+	integration.ID = mux.Vars(r)["id"]
+
+	if err := validateIntegration(integration); err != nil {
+		rw.Error(err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.integrations.Update(r.Context(), integration); err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, integration, http.StatusOK)
+}
+
+func (h *Handler) DeleteIntegration(w http.ResponseWriter, r *http.Request) {
+	rw := httputils.NewResponseWriter(w)
+
+	if err := h.integrations.Delete(r.Context(), mux.Vars(r)["id"]); err != nil {
+		h.writeStoreError(w, err)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func validateIntegration(integration *model.Integration) error {
+	if err := integration.Validate(); err != nil {
+		return err
+	}
+	if err := google.ValidateRedactFields(integration.RedactFields); err != nil {
+		return err
+	}
+	return google.ValidateColumnMapping(integration.Columns)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, statusCode int) {
+	rw := httputils.NewResponseWriter(w)
+
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		rw.Error(err, http.StatusInternalServerError)
+		return
+	}
+	rw.WriteJSONWithStatus(bytes, statusCode)
+}
+
+func (h *Handler) writeStoreError(w http.ResponseWriter, err error) {
+	rw := httputils.NewResponseWriter(w)
+
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		rw.Error(err, http.StatusNotFound)
+	case errors.Is(err, store.ErrConflict):
+		rw.Error(err, http.StatusConflict)
+	default:
+		h.logger.Error("failed to access integration store :: stacktrace ::", err)
+		rw.Error(err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,421 @@
+package messagehandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/internal/sink"
+	"github.com/adetunjii/google-sheets-connector/internal/source"
+	"github.com/adetunjii/google-sheets-connector/internal/store"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+)
+
+type Handler struct {
+	googleClient *google.GoogleClient
+	integrations store.IntegrationStore
+	holds        store.HoldStore
+	// sinks other than Google Sheets, by type
+	sinks  map[model.SinkType]sink.Sink
+	logger logger.AppLogger
+}
+
+type Option func(*Handler)
+
+// WithSink writes the answers of integrations with the given sink type to s.
+// Google Sheets needs none, it is written with the credentials of each
+// message.
+func WithSink(sinkType model.SinkType, s sink.Sink) Option {
+	return func(h *Handler) {
+		h.sinks[sinkType] = s
+	}
+}
+
+func New(googleClient *google.GoogleClient, integrations store.IntegrationStore, holds store.HoldStore, logger logger.AppLogger, opts ...Option) *Handler {
+	h := &Handler{
+		googleClient: googleClient,
+		integrations: integrations,
+		holds:        holds,
+		sinks:        make(map[model.SinkType]sink.Sink),
+		logger:       logger,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// HandleMessage decodes a questionnaire message from its source and writes it
+// to the spreadsheet it references. See HandleBatch.
+func (h *Handler) HandleMessage(ctx context.Context, envelope *source.Envelope) error {
+	return h.HandleBatch(ctx, []*source.Envelope{envelope})
+}
+
+// HandleBatch decodes questionnaire messages from their source and writes
+// them to the sheets they are routed to, with one call to its sink per sheet. Written
+// messages are acked, as are those of inactive integrations once they are
+// held, see ReleaseHeld. The others are nacked, to be delivered again when
+// the failure is transient and to be parked when they can never be written.
+// An error is only returned when settling one of the messages failed.
+func (h *Handler) HandleBatch(ctx context.Context, messages []*source.Envelope) error {
+	var failed error
+	settled := func(err error) {
+		if err != nil && failed == nil {
+			failed = err
+		}
+	}
+	fail := func(message *source.Envelope, err error) {
+		settled(h.handleFailure(message, err))
+	}
+
+	writer := sink.NewBatchWriter(h.logger)
+
+	for _, message := range messages {
+		message := message
+
+		km := model.GoogleSheetKafkaMessage{}
+		if err := json.Unmarshal(message.Body, &km); err != nil {
+			h.logger.Error("failed to parse message from broker :: stacktrace ::", err)
+			fail(message, &permanentError{fmt.Errorf("malformed message: %w", err)})
+			continue
+		}
+
+		if err := km.Operation.Validate(); err != nil {
+			h.logger.Error("received invalid operation :: stacktrace ::", err)
+			fail(message, &permanentError{err})
+			continue
+		}
+
+		// deletes and redactions only have to say whose data goes
+		validate := km.Questionnaire.Validate
+		if km.Operation.Erases() {
+			validate = km.Questionnaire.ValidateReference
+		}
+
+		if err := validate(); err != nil {
+			h.logger.Error("received invalid questionnaire data :: stacktrace ::", err)
+			fail(message, &permanentError{fmt.Errorf("invalid questionnaire: %w", err)})
+			continue
+		}
+
+		integration, err := h.findIntegration(ctx, &km)
+		if err != nil {
+			h.logger.Error("failed to find the integration of message :: stacktrace ::", err)
+			fail(message, err)
+			continue
+		}
+
+		// messages naming their own spreadsheet are held too, their
+		// credentials may be the ones that were revoked
+		if integration != nil && integration.Status != model.IntegrationActive {
+			settled(h.hold(ctx, message, integration))
+			continue
+		}
+
+		if km.SpreadSheetID == "" {
+			resolveIntegration(&km, integration)
+		}
+
+		if err := google.ValidateColumnMapping(km.Columns); err != nil {
+			h.logger.Error("received invalid column mapping :: stacktrace ::", err)
+			fail(message, &permanentError{fmt.Errorf("invalid column mapping: %w", err)})
+			continue
+		}
+
+		if err := google.ValidateRedactFields(km.RedactFields); err != nil {
+			h.logger.Error("received invalid redact fields :: stacktrace ::", err)
+			fail(message, &permanentError{err})
+			continue
+		}
+
+		if err := km.Mode.Validate(); err != nil {
+			h.logger.Error("received invalid write mode :: stacktrace ::", err)
+			fail(message, &permanentError{err})
+			continue
+		}
+
+		if km.Mode == model.WriteModeUpsert && !km.Operation.Erases() && (km.Questionnaire.AnswerID == nil || *km.Questionnaire.AnswerID == "") {
+			fail(message, &permanentError{google.ErrMissingAnswerID})
+			continue
+		}
+
+		// the integration of a revoked token waits to be authorized again
+		failOrHold := func(err error) {
+			if integration != nil && errors.Is(err, google.ErrReauthorizationRequired) {
+				settled(h.hold(ctx, message, integration))
+				return
+			}
+			fail(message, err)
+		}
+
+		destination, target, err := h.route(ctx, &km)
+		if err != nil {
+			failOrHold(err)
+			continue
+		}
+
+		writer.Add(destination, target, km.Operation, &km.Questionnaire, func(err error) {
+			if err != nil {
+				failOrHold(err)
+				return
+			}
+			settled(message.Ack())
+		})
+	}
+
+	writer.Flush(ctx)
+	return failed
+}
+
+// route returns the sink of a message and where in it the message goes.
+func (h *Handler) route(ctx context.Context, km *model.GoogleSheetKafkaMessage) (sink.Sink, sink.Target, error) {
+	if err := km.Sink.Validate(); err != nil {
+		h.logger.Error("received invalid sink :: stacktrace ::", err)
+		return nil, sink.Target{}, &permanentError{err}
+	}
+
+	if km.Sink.WritesFiles() {
+		destination, ok := h.sinks[km.Sink]
+		if !ok {
+			return nil, sink.Target{}, &permanentError{fmt.Errorf("sink %s is not enabled", km.Sink)}
+		}
+
+		if err := model.ValidateFile(km.File); err != nil {
+			h.logger.Error("received invalid file :: stacktrace ::", err)
+			return nil, sink.Target{}, &permanentError{err}
+		}
+
+		target, err := sink.Route(km)
+		if err != nil {
+			h.logger.Error("failed to find the sheet for message :: stacktrace ::", err)
+			return nil, sink.Target{}, err
+		}
+		return destination, target, nil
+	}
+
+	orgID := ""
+	if km.Questionnaire.OrgID != nil {
+		orgID = *km.Questionnaire.OrgID
+	}
+
+	ref := google.CredentialRef{
+		Type:                km.Credentials,
+		OrgID:               orgID,
+		TokenID:             km.TokenID,
+		Token:               km.Token,
+		ServiceAccountKeyID: km.ServiceAccountKeyID,
+		Subject:             km.Subject,
+	}
+	credentials, err := h.googleClient.Credentials(ref, h.logger)
+	if err != nil {
+		h.logger.Error("received invalid credentials :: stacktrace ::", err)
+		return nil, sink.Target{}, &permanentError{err}
+	}
+
+	googleSheetClient, err := h.googleClient.SheetClient(ctx, credentials, h.logger)
+	if err != nil {
+		h.logger.Error("failed to create sheets client for message :: stacktrace ::", err)
+		return nil, sink.Target{}, err
+	}
+
+	// the sheet of the message's form, created on its first answer
+	target, err := googleSheetClient.Route(km)
+	if err != nil {
+		h.logger.Error("failed to find the sheet for message :: stacktrace ::", err)
+		return nil, sink.Target{}, err
+	}
+	// messages are only written together with those written as the same
+	// credentials, which are the ones just checked
+	sinkTarget := sink.GoogleTarget(target)
+	sinkTarget.Credentials = ref.Key()
+	return sink.NewGoogleSheets(googleSheetClient), sinkTarget, nil
+}
+
+// findIntegration returns the integration of the organisation and form of a
+// message, nil when there is none and the message names its destination
+// itself.
+func (h *Handler) findIntegration(ctx context.Context, km *model.GoogleSheetKafkaMessage) (*model.Integration, error) {
+	named := km.SpreadSheetID != ""
+
+	q := km.Questionnaire
+	if q.OrgID == nil || q.FormID == nil {
+		if named {
+			return nil, nil
+		}
+		return nil, &permanentError{errors.New("message names no spreadsheet, nor the organization and form to look it up by")}
+	}
+
+	integration, err := h.integrations.FindByForm(ctx, *q.OrgID, *q.FormID)
+	if errors.Is(err, store.ErrNotFound) {
+		if named {
+			return nil, nil
+		}
+		return nil, &permanentError{fmt.Errorf("no integration for form %s of organization %s", *q.FormID, *q.OrgID)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return integration, nil
+}
+
+// resolveIntegration fills in the destination of a message that names none
+// from the integration of its organisation and form. The message is written
+// with the integration's credentials only, whatever credentials it carried
+// are dropped.
+func resolveIntegration(km *model.GoogleSheetKafkaMessage, integration *model.Integration) {
+	km.Sink = integration.Sink
+	km.SpreadSheetID = integration.SpreadSheetID
+	km.File = integration.File
+	km.SheetID = integration.SheetID
+	km.Columns = integration.Columns
+	km.Mode = integration.Mode
+	km.RedactFields = integration.RedactFields
+	km.Token = nil
+	km.TokenID = integration.TokenID
+	km.Credentials = integration.Credentials
+	km.ServiceAccountKeyID = integration.ServiceAccountKeyID
+	km.Subject = integration.Subject
+}
+
+// hold puts the message of an inactive integration aside until it is active
+// again and acks it, so that it uses up none of the attempts its source
+// gives it. It is nacked to be delivered again when it can't be held.
+func (h *Handler) hold(ctx context.Context, message *source.Envelope, integration *model.Integration) error {
+	if err := h.holds.Hold(ctx, integration.ID, message.Body); err != nil {
+		h.logger.Error("failed to hold message :: stacktrace ::", err)
+		return message.Nack(err, true)
+	}
+
+	h.logger.Info(fmt.Sprintf("holding message %s until integration %s is active again", message.ID, integration.ID))
+	return message.Ack()
+}
+
+// releaseBatchSize is the number of held messages written at once.
+const releaseBatchSize = 50
+
+// ReleaseHeld writes the messages held for integrations that are active
+// again, oldest first, and those of deleted integrations, which are written
+// like new messages. Messages failing for a transient reason stay held until
+// the next call, those that can never be written are dropped.
+func (h *Handler) ReleaseHeld(ctx context.Context) error {
+	ids, err := h.holds.HeldIntegrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := h.release(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release writes the messages held for an integration while it is active.
+func (h *Handler) release(ctx context.Context, integrationID string) error {
+	for ctx.Err() == nil {
+		integration, err := h.integrations.Get(ctx, integrationID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		if err == nil && integration.Status != model.IntegrationActive {
+			return nil
+		}
+
+		held, err := h.holds.Held(ctx, integrationID, releaseBatchSize)
+		if err != nil || len(held) == 0 {
+			return err
+		}
+
+		kept := false
+		envelopes := make([]*source.Envelope, len(held))
+		for i, m := range held {
+			m := m
+			release := func() error { return h.holds.Release(ctx, integrationID, m.ID) }
+
+			envelopes[i] = source.NewEnvelope(m.ID, m.Body, 1, release, func(reason error, requeue bool) error {
+				if requeue {
+					kept = true
+					return nil
+				}
+
+				h.logger.Error(fmt.Sprintf("dropping held message %s of integration %s :: stacktrace ::", m.ID, integrationID), reason)
+				return release()
+			})
+		}
+
+		if err := h.HandleBatch(ctx, envelopes); err != nil {
+			return err
+		}
+
+		// the rest waits for the next call rather than overtaking the messages
+		// that failed
+		if kept {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// ReleaseHeldEvery calls ReleaseHeld every interval until ctx is cancelled.
+func (h *Handler) ReleaseHeldEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.ReleaseHeld(ctx); err != nil && ctx.Err() == nil {
+			h.logger.Error("failed to release held messages :: stacktrace ::", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// permanentError marks failures that no amount of retrying will fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// handleFailure nacks message, for good when err is permanent.
+func (h *Handler) handleFailure(message *source.Envelope, err error) error {
+	var permanent *permanentError
+	requeue := !errors.As(err, &permanent) && !google.IsPermanentError(err)
+	return message.Nack(err, requeue)
+}
+
+// SpreadSheetKey orders messages by the spreadsheet they are written to, so
+// rows of one spreadsheet are appended in the order they were received.
+// Messages naming none are written to the integration of their form, and are
+// ordered by it.
+func SpreadSheetKey(body []byte) string {
+	km := struct {
+		SpreadSheetID string `json:"spreadsheet_id"`
+		Questionnaire struct {
+			OrgID  string `json:"org_id"`
+			FormID string `json:"form_id"`
+		} `json:"questionnaire"`
+	}{}
+
+	// malformed messages all share the empty key, they are parked anyway
+	_ = json.Unmarshal(body, &km)
+	switch {
+	case km.SpreadSheetID != "":
+		return km.SpreadSheetID
+	case km.Questionnaire.OrgID != "" || km.Questionnaire.FormID != "":
+		return "form:" + km.Questionnaire.OrgID + "\x00" + km.Questionnaire.FormID
+	default:
+		return ""
+	}
+}
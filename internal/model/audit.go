@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// AuditAction is something done to an integration that is kept on record.
+type AuditAction string
+
+const (
+	// AuditDisconnected is an integration losing access to its spreadsheet
+	// because its credentials were revoked.
+	AuditDisconnected AuditAction = "disconnected"
+)
+
+// AuditEntry records an action on an integration, and who took it.
+type AuditEntry struct {
+	ID            string      `json:"id"`
+	IntegrationID string      `json:"integration_id"`
+	OrgID         string      `json:"org_id"`
+	Action        AuditAction `json:"action"`
+	Actor         string      `json:"actor,omitempty"`
+	Detail        string      `json:"detail,omitempty"`
+	At            time.Time   `json:"at"`
+}
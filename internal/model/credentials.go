@@ -0,0 +1,39 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CredentialType is what the connector acts as on a spreadsheet.
+type CredentialType string
+
+const (
+	// CredentialUser acts as the user who authorized the connector, with the
+	// OAuth token stored under a token ID. It is the default.
+	CredentialUser CredentialType = "user"
+	// CredentialServiceAccount acts as a service account, or as the user in
+	// its subject through domain-wide delegation, with a stored JSON key.
+	CredentialServiceAccount CredentialType = "service_account"
+)
+
+func (t CredentialType) Validate() error {
+	switch t {
+	case "", CredentialUser, CredentialServiceAccount:
+		return nil
+	default:
+		return fmt.Errorf("unknown credential type %q", t)
+	}
+}
+
+// ValidateCredentials checks that credentials of type t name what they need.
+func ValidateCredentials(t CredentialType, serviceAccountKeyID string) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	if t == CredentialServiceAccount && serviceAccountKeyID == "" {
+		return errors.New("service account credentials need a service account key id")
+	}
+	return nil
+}
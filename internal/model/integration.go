@@ -0,0 +1,102 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// IntegrationStatus is the state of an integration.
+type IntegrationStatus string
+
+const (
+	// IntegrationActive integrations have their answers written.
+	IntegrationActive IntegrationStatus = "active"
+	// IntegrationPaused integrations have their answers held until they are
+	// resumed.
+	IntegrationPaused IntegrationStatus = "paused"
+	// IntegrationReauthorizationRequired integrations lost access to their
+	// spreadsheet, e.g. because the user revoked it. Their answers are held
+	// like those of paused integrations until they get a new token.
+	IntegrationReauthorizationRequired IntegrationStatus = "reauthorization_required"
+	// IntegrationDisconnected integrations had their credentials revoked on
+	// request. Their answers are held until they are connected again.
+	IntegrationDisconnected IntegrationStatus = "disconnected"
+)
+
+func (s IntegrationStatus) Validate() error {
+	switch s {
+	case IntegrationActive, IntegrationPaused, IntegrationReauthorizationRequired, IntegrationDisconnected:
+		return nil
+	default:
+		return fmt.Errorf("unknown integration status %q", s)
+	}
+}
+
+// Integration connects the answers to a form of an organisation to the
+// spreadsheet, or the file, they are written to.
+type Integration struct {
+	ID     string `json:"id"`
+	OrgID  string `json:"org_id"`
+	FormID string `json:"form_id"`
+	// Sink selects between SpreadSheetID and File.
+	Sink          SinkType `json:"sink,omitempty"`
+	SpreadSheetID string   `json:"spreadsheet_id"`
+	// File is the file written to by file sinks, relative to their directory.
+	File    string          `json:"file,omitempty"`
+	SheetID string          `json:"sheet_id,omitempty"`
+	Columns []ColumnMapping `json:"columns,omitempty"`
+	Mode    WriteMode       `json:"mode,omitempty"`
+	// RedactFields are the fields blanked when a respondent is redacted,
+	// google.PIIFields without.
+	RedactFields []string `json:"redact_fields,omitempty"`
+	TokenID      string   `json:"token_id,omitempty"`
+	// Credentials selects between TokenID and a service account key.
+	Credentials         CredentialType `json:"credentials,omitempty"`
+	ServiceAccountKeyID string         `json:"service_account_key_id,omitempty"`
+	// Subject is the user a service account acts as, if any.
+	Subject string            `json:"subject,omitempty"`
+	Owner   string            `json:"owner"`
+	Status  IntegrationStatus `json:"status"`
+	// Parts lists the spreadsheet followed by the spreadsheets continuing it
+	// once it filled up, in order.
+	Parts     []string  `json:"parts,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (i *Integration) Validate() error {
+	if i.OrgID == "" {
+		return errors.New("organization id cannot be empty")
+	}
+
+	if i.FormID == "" {
+		return errors.New("form id cannot be empty")
+	}
+
+	if err := i.Sink.Validate(); err != nil {
+		return err
+	}
+
+	if i.Sink.WritesFiles() {
+		if err := ValidateFile(i.File); err != nil {
+			return err
+		}
+	} else if i.SpreadSheetID == "" {
+		return errors.New("spreadsheet id cannot be empty")
+	}
+
+	if i.Owner == "" {
+		return errors.New("owner cannot be empty")
+	}
+
+	if err := i.Status.Validate(); err != nil {
+		return err
+	}
+
+	if err := ValidateCredentials(i.Credentials, i.ServiceAccountKeyID); err != nil {
+		return err
+	}
+
+	return i.Mode.Validate()
+}
@@ -0,0 +1,38 @@
+package model
+
+import "fmt"
+
+// ColumnMapping places one QuestionnarieData field in a spreadsheet column.
+type ColumnMapping struct {
+	// Field is the JSON name of the field, e.g. respondent_email.
+	Field string `json:"field"`
+	// Header is the text of the column's header cell. It defaults to the
+	// upper-cased Go name of the field.
+	Header string `json:"header,omitempty"`
+	// Formatter is the name of the formatter applied to the value before it
+	// is written, see google.Formatters.
+	Formatter string `json:"formatter,omitempty"`
+}
+
+// WriteMode decides how questionnaire answers are laid out in a sheet.
+type WriteMode string
+
+const (
+	// WriteModeAnswer appends one row per answer. It is the default.
+	WriteModeAnswer WriteMode = "answer"
+	// WriteModeResponse keeps one row per respondent and form, with a column
+	// per question, and fills the row in as answers arrive.
+	WriteModeResponse WriteMode = "response"
+	// WriteModeUpsert keeps one row per answer, identified by its AnswerID.
+	// Redelivered answers rewrite their row instead of adding another one.
+	WriteModeUpsert WriteMode = "upsert"
+)
+
+func (m WriteMode) Validate() error {
+	switch m {
+	case "", WriteModeAnswer, WriteModeResponse, WriteModeUpsert:
+		return nil
+	default:
+		return fmt.Errorf("unknown write mode %q", m)
+	}
+}
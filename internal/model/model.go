@@ -32,11 +32,58 @@ type QuestionnarieData struct {
 	UpdatedAt             time.Time `json:"updated_at"`
 }
 
+// Operation is what a message asks to be done with its answer.
+type Operation string
+
+const (
+	// OperationInsert writes a new answer. It is the default.
+	OperationInsert Operation = "insert"
+	// OperationUpdate writes an edited answer. How the old values are
+	// replaced depends on the sheet's WriteMode.
+	OperationUpdate Operation = "update"
+	// OperationDelete removes the rows of an answer, or of a respondent when
+	// no answer ID is given.
+	OperationDelete Operation = "delete"
+	// OperationRedact blanks the personal data of a respondent in every row.
+	OperationRedact Operation = "redact"
+)
+
+func (o Operation) Validate() error {
+	switch o {
+	case "", OperationInsert, OperationUpdate, OperationDelete, OperationRedact:
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", o)
+	}
+}
+
+// Erases reports whether o removes data from the sheet rather than writing it.
+func (o Operation) Erases() bool {
+	return o == OperationDelete || o == OperationRedact
+}
+
 type GoogleSheetKafkaMessage struct {
-	SpreadSheetID string            `json:"spreadsheet_id"`
-	SheetID       string            `json:"sheet_id"`
-	Token         *oauth2.Token     `json:"token"`
-	Questionnaire QuestionnarieData `json:"questionnaire"`
+	// Sink, SpreadSheetID and File are as in Integration. Sink and File are
+	// only ever taken from the integration, so that no message can have a
+	// file written to on behalf of another organization.
+	Sink          SinkType `json:"-"`
+	SpreadSheetID string   `json:"spreadsheet_id"`
+	File          string   `json:"-"`
+	SheetID       string   `json:"sheet_id"`
+	// Token is the user's OAuth token. Deprecated: send TokenID instead, so
+	// credentials don't end up in the topic.
+	Token   *oauth2.Token `json:"token,omitempty"`
+	TokenID string        `json:"token_id,omitempty"`
+	// Credentials, ServiceAccountKeyID, Subject and RedactFields are as in
+	// Integration.
+	Credentials         CredentialType    `json:"credentials,omitempty"`
+	ServiceAccountKeyID string            `json:"service_account_key_id,omitempty"`
+	Subject             string            `json:"subject,omitempty"`
+	Columns             []ColumnMapping   `json:"columns,omitempty"`
+	Mode                WriteMode         `json:"mode,omitempty"`
+	RedactFields        []string          `json:"redact_fields,omitempty"`
+	Operation           Operation         `json:"operation,omitempty"`
+	Questionnaire       QuestionnarieData `json:"questionnaire"`
 }
 
 func (q *QuestionnarieData) Validate() error {
@@ -83,6 +130,16 @@ func (q *QuestionnarieData) Validate() error {
 	return nil
 }
 
+// ValidateReference checks that q identifies an answer or a respondent, which
+// is all a delete or redact event has to carry.
+func (q *QuestionnarieData) ValidateReference() error {
+	if (q.AnswerID == nil || *q.AnswerID == "") && (q.RespondentID == nil || *q.RespondentID == "") {
+		return errors.New("answer id or respondent id must be specified")
+	}
+
+	return nil
+}
+
 func (q *QuestionnarieData) ToInterface() interface{} {
 	fmt.Println("conversion failure")
 	var i interface{} = q
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SinkType is where the answers of an integration are written.
+type SinkType string
+
+const (
+	// SinkGoogleSheets writes to a Google spreadsheet. It is the default.
+	SinkGoogleSheets SinkType = "google_sheets"
+	// SinkXLSX writes to an Excel workbook on disk, with a worksheet per tab.
+	SinkXLSX SinkType = "xlsx"
+	// SinkCSV writes to a directory on disk, with a CSV file per tab.
+	SinkCSV SinkType = "csv"
+)
+
+func (t SinkType) Validate() error {
+	switch t {
+	case "", SinkGoogleSheets, SinkXLSX, SinkCSV:
+		return nil
+	default:
+		return fmt.Errorf("unknown sink %q", t)
+	}
+}
+
+// WritesFiles reports whether t writes to a file on disk rather than to a
+// spreadsheet.
+func (t SinkType) WritesFiles() bool {
+	return t == SinkXLSX || t == SinkCSV
+}
+
+// ValidateFile checks that the file of a file sink stays within the sink's
+// directory.
+func ValidateFile(file string) error {
+	if file == "" {
+		return errors.New("file cannot be empty")
+	}
+
+	cleaned := path.Clean(filepath.ToSlash(file))
+	if filepath.IsAbs(file) || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("file %q must be relative to the sink's directory", file)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package google
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// SheetsAPI is the part of the Sheets API v4 the connector uses. Values are
+// written as entered, without parsing, and appends insert rows.
+type SheetsAPI interface {
+	CreateSpreadsheet(ctx context.Context, spreadsheet *sheets.Spreadsheet) (*sheets.Spreadsheet, error)
+	// GetSpreadsheet returns the title, sheet properties and developer
+	// metadata of a spreadsheet, or with includeGridData the values and
+	// formats of the cells in ranges.
+	GetSpreadsheet(ctx context.Context, spreadsheetID string, ranges []string, includeGridData bool) (*sheets.Spreadsheet, error)
+	BatchUpdate(ctx context.Context, spreadsheetID string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error)
+
+	GetValues(ctx context.Context, spreadsheetID string, cellRange string) (*sheets.ValueRange, error)
+	BatchGetValues(ctx context.Context, spreadsheetID string, ranges []string, majorDimension string) (*sheets.BatchGetValuesResponse, error)
+	AppendValues(ctx context.Context, spreadsheetID string, cellRange string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error)
+	UpdateValues(ctx context.Context, spreadsheetID string, cellRange string, values *sheets.ValueRange) (*sheets.UpdateValuesResponse, error)
+	BatchUpdateValues(ctx context.Context, spreadsheetID string, req *sheets.BatchUpdateValuesRequest) (*sheets.BatchUpdateValuesResponse, error)
+}
+
+// SheetsAPIFactory returns the API a sheets client calls through client, which
+// carries its credentials and rate limits.
+type SheetsAPIFactory func(client *http.Client) (SheetsAPI, error)
+
+// WithSheetsAPI makes sheets clients call the API factory returns, e.g. a fake
+// in tests.
+func WithSheetsAPI(factory SheetsAPIFactory) ClientOption {
+	return func(g *GoogleClient) {
+		g.sheetsAPI = factory
+	}
+}
+
+// WithSheetsEndpoint makes sheets clients call the Sheets API at endpoint, the
+// base URL the API's /v4 paths are resolved against, instead of Google's.
+// Empty leaves it at Google's.
+func WithSheetsEndpoint(endpoint string) ClientOption {
+	return func(g *GoogleClient) {
+		if endpoint == "" {
+			return
+		}
+		g.sheetsAPI = func(client *http.Client) (SheetsAPI, error) {
+			return newSheetsService(client, option.WithEndpoint(endpoint))
+		}
+	}
+}
+
+// NewSheetsService returns the Sheets API at Google, called through client.
+func NewSheetsService(client *http.Client) (SheetsAPI, error) {
+	return newSheetsService(client)
+}
+
+func newSheetsService(client *http.Client, opts ...option.ClientOption) (SheetsAPI, error) {
+	opts = append(opts, option.WithHTTPClient(client))
+	svc, err := sheets.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &sheetsService{svc: svc}, nil
+}
+
+// sheetsService is SheetsAPI over the generated Sheets client.
+type sheetsService struct {
+	svc *sheets.Service
+}
+
+func (s *sheetsService) CreateSpreadsheet(ctx context.Context, spreadsheet *sheets.Spreadsheet) (*sheets.Spreadsheet, error) {
+	return s.svc.Spreadsheets.Create(spreadsheet).Context(ctx).Do()
+}
+
+func (s *sheetsService) GetSpreadsheet(ctx context.Context, spreadsheetID string, ranges []string, includeGridData bool) (*sheets.Spreadsheet, error) {
+	call := s.svc.Spreadsheets.Get(spreadsheetID).Context(ctx)
+	if includeGridData {
+		call = call.Ranges(ranges...).IncludeGridData(true).
+			Fields("sheets(properties(title),data(rowData(values(userEnteredValue,userEnteredFormat))))")
+	} else {
+		call = call.Ranges(ranges...).Fields("properties.title,sheets.properties,developerMetadata")
+	}
+	return call.Do()
+}
+
+func (s *sheetsService) BatchUpdate(ctx context.Context, spreadsheetID string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	return s.svc.Spreadsheets.BatchUpdate(spreadsheetID, req).Context(ctx).Do()
+}
+
+func (s *sheetsService) GetValues(ctx context.Context, spreadsheetID string, cellRange string) (*sheets.ValueRange, error) {
+	return s.svc.Spreadsheets.Values.Get(spreadsheetID, cellRange).Context(ctx).Do()
+}
+
+func (s *sheetsService) BatchGetValues(ctx context.Context, spreadsheetID string, ranges []string, majorDimension string) (*sheets.BatchGetValuesResponse, error) {
+	return s.svc.Spreadsheets.Values.BatchGet(spreadsheetID).Ranges(ranges...).MajorDimension(majorDimension).Context(ctx).Do()
+}
+
+func (s *sheetsService) AppendValues(ctx context.Context, spreadsheetID string, cellRange string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error) {
+	return s.svc.Spreadsheets.Values.Append(spreadsheetID, cellRange, values).
+		ValueInputOption(VALUE_INPUT_OPTION).InsertDataOption(INSERT_DATA_OPTION).Context(ctx).Do()
+}
+
+func (s *sheetsService) UpdateValues(ctx context.Context, spreadsheetID string, cellRange string, values *sheets.ValueRange) (*sheets.UpdateValuesResponse, error) {
+	return s.svc.Spreadsheets.Values.Update(spreadsheetID, cellRange, values).ValueInputOption(VALUE_INPUT_OPTION).Context(ctx).Do()
+}
+
+func (s *sheetsService) BatchUpdateValues(ctx context.Context, spreadsheetID string, req *sheets.BatchUpdateValuesRequest) (*sheets.BatchUpdateValuesResponse, error) {
+	return s.svc.Spreadsheets.Values.BatchUpdate(spreadsheetID, req).Context(ctx).Do()
+}
@@ -2,10 +2,12 @@ package google
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
-	"strings"
+	"time"
 
 	"github.com/adetunjii/google-sheets-connector/pkg/logger"
 	"golang.org/x/oauth2"
@@ -20,11 +22,38 @@ var (
 )
 
 type GoogleClient struct {
-	logger logger.AppLogger
-	config *oauth2.Config
+	logger      logger.AppLogger
+	config      *oauth2.Config
+	rateLimits  RateLimits
+	metrics     RateLimitMetrics
+	rateLimiter *rateLimiter
+	layouts     *layoutCache
+	indexes     *rowIndexCache
+	locks       *sheetLocks
+	catalog     *sheetCatalog
+	// spreadsheets holding this many cells get a continuation, see Parts
+	rolloverCells  int64
+	onRollover     RolloverHook
+	tokens         *TokenStore
+	onTokenRevoked TokenRevokedHook
+	// signs login states, see HandleGoogleLogin
+	stateKey   []byte
+	loginTTL   time.Duration
+	prompt     string
+	returnURLs []*url.URL
+	// for calls to Google outside the Sheets API
+	httpClient *http.Client
+	revokeURL  string
+	sheetsAPI  SheetsAPIFactory
 }
 
-func NewGoogleClient(client_id string, client_secret string, scopes []string, redirect_url string, logger logger.AppLogger) *GoogleClient {
+// RolloverHook is told the parts of a spreadsheet, in order, whenever it gets
+// a new one.
+type RolloverHook func(spreadSheetID string, parts []string)
+
+type ClientOption func(*GoogleClient)
+
+func NewGoogleClient(client_id string, client_secret string, scopes []string, redirect_url string, logger logger.AppLogger, opts ...ClientOption) *GoogleClient {
 
 	oauth2Conf := &oauth2.Config{
 		ClientID:     client_id,
@@ -34,10 +63,121 @@ func NewGoogleClient(client_id string, client_secret string, scopes []string, re
 		Endpoint:     google.Endpoint,
 	}
 
-	return &GoogleClient{config: oauth2Conf, logger: logger}
+	g := &GoogleClient{
+		config:     oauth2Conf,
+		logger:     logger,
+		rateLimits: DefaultRateLimits,
+		metrics:    noopRateLimitMetrics{},
+		layouts:    newLayoutCache(),
+		indexes:    newRowIndexCache(),
+		locks:      newSheetLocks(),
+		catalog:    newSheetCatalog(),
+
+		rolloverCells: DefaultRolloverCells,
+		loginTTL:      DefaultLoginTTL,
+		prompt:        "consent",
+		httpClient:    http.DefaultClient,
+		revokeURL:     RevocationURL,
+		sheetsAPI:     NewSheetsService,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	// logins only survive restarts and reach other instances with a configured key
+	if len(g.stateKey) == 0 {
+		g.stateKey = make([]byte, 32)
+		if _, err := rand.Read(g.stateKey); err != nil {
+			panic(err)
+		}
+	}
+
+	// the project bucket is shared by every sheets client built from g
+	g.rateLimiter = newRateLimiter(g.rateLimits, g.metrics)
+	return g
+}
+
+// WithOAuthEndpoint makes users log in and tokens be exchanged and refreshed
+// at endpoint instead of Google's. Empty URLs are left at Google's.
+func WithOAuthEndpoint(endpoint oauth2.Endpoint) ClientOption {
+	return func(g *GoogleClient) {
+		if endpoint.AuthURL != "" {
+			g.config.Endpoint.AuthURL = endpoint.AuthURL
+		}
+		if endpoint.TokenURL != "" {
+			g.config.Endpoint.TokenURL = endpoint.TokenURL
+		}
+		if endpoint.AuthStyle != oauth2.AuthStyleAutoDetect {
+			g.config.Endpoint.AuthStyle = endpoint.AuthStyle
+		}
+	}
+}
+
+// WithRevocationURL revokes tokens at url instead of RevocationURL, unless it
+// is empty.
+func WithRevocationURL(url string) ClientOption {
+	return func(g *GoogleClient) {
+		if url != "" {
+			g.revokeURL = url
+		}
+	}
+}
+
+// WithRateLimits overrides the limits applied to Sheets API calls.
+func WithRateLimits(limits RateLimits) ClientOption {
+	return func(g *GoogleClient) {
+		g.rateLimits = limits
+	}
+}
+
+// WithRolloverThreshold sets the number of cells at which writes move on to a
+// continuation spreadsheet. Zero or less turns rollover off.
+func WithRolloverThreshold(cells int64) ClientOption {
+	return func(g *GoogleClient) {
+		g.rolloverCells = cells
+	}
+}
+
+// WithRolloverHook calls hook after every rollover, e.g. to record the new
+// part with the spreadsheet's integration.
+func WithRolloverHook(hook RolloverHook) ClientOption {
+	return func(g *GoogleClient) {
+		g.onRollover = hook
+	}
+}
+
+// WithTokenStore keeps the OAuth tokens of users in tokens.
+func WithTokenStore(tokens *TokenStore) ClientOption {
+	return func(g *GoogleClient) {
+		g.tokens = tokens
+	}
+}
+
+// WithTokenRevokedHook calls hook when a stored token turns out to be revoked.
+func WithTokenRevokedHook(hook TokenRevokedHook) ClientOption {
+	return func(g *GoogleClient) {
+		g.onTokenRevoked = hook
+	}
+}
+
+// Tokens returns the store OAuth tokens are kept in, see WithTokenStore.
+func (g *GoogleClient) Tokens() *TokenStore {
+	return g.tokens
 }
 
-func (g *GoogleClient) ExchangeCode(code string) (*oauth2.Token, error) {
+// WithRateLimitMetrics reports throttled and retried Sheets API calls to metrics.
+func WithRateLimitMetrics(metrics RateLimitMetrics) ClientOption {
+	return func(g *GoogleClient) {
+		if metrics != nil {
+			g.metrics = metrics
+		}
+	}
+}
+
+// ExchangeCode trades an authorization code for a token. verifier is the PKCE
+// code verifier of the login the code was issued to.
+func (g *GoogleClient) ExchangeCode(code string, verifier string) (*oauth2.Token, error) {
 
 	// validate auth code
 	if code == "" {
@@ -45,7 +185,7 @@ func (g *GoogleClient) ExchangeCode(code string) (*oauth2.Token, error) {
 	}
 
 	// exchange auth code for token details
-	token, err := g.config.Exchange(context.Background(), code)
+	token, err := g.config.Exchange(context.Background(), code, oauth2.SetAuthURLParam("code_verifier", verifier))
 	if err != nil {
 		g.logger.Error("google code exchange failed with: ", err)
 		return nil, errors.New("google code exchange failed")
@@ -54,41 +194,30 @@ func (g *GoogleClient) ExchangeCode(code string) (*oauth2.Token, error) {
 	return token, nil
 }
 
-func (g *GoogleClient) HandleGoogleLogin() (string, error) {
+// HandleGoogleCallback finishes the login that state and session belong to,
+// see HandleGoogleLogin, and returns the user's token with the login's state.
+func (g *GoogleClient) HandleGoogleCallback(state string, session string, code string, errorReason string) (*oauth2.Token, *LoginState, error) {
 
-	URL, err := url.Parse(g.config.Endpoint.AuthURL)
+	login, verifier, err := g.verifyLogin(state, session)
 	if err != nil {
-		return "", err
-	}
-
-	parameters := url.Values{}
-	parameters.Add("client_id", g.config.ClientID)
-	parameters.Add("scope", strings.Join(g.config.Scopes, " "))
-	parameters.Add("redirect_uri", g.config.RedirectURL)
-	parameters.Add("response_type", "code")
-	// parameters.Add("state", oauthStateString)
-	URL.RawQuery = parameters.Encode()
-	url := URL.String()
-	return url, nil
-}
-
-func (g *GoogleClient) HandleGoogleCallback(code string, errorReason string) (*oauth2.Token, error) {
-
-	if code == "" {
-		return nil, ErrInvalidAuthGrantCode
+		return nil, nil, err
 	}
 
 	if errorReason != "" {
-		if errorReason == "user_denied" {
-			return nil, ErrUserDeniedPermission
+		if errorReason == "user_denied" || errorReason == "access_denied" {
+			return nil, login, ErrUserDeniedPermission
 		}
-		return nil, fmt.Errorf("failed to authenticate user due to %s", errorReason)
+		return nil, login, fmt.Errorf("failed to authenticate user due to %s", errorReason)
+	}
+
+	if code == "" {
+		return nil, login, ErrInvalidAuthGrantCode
 	}
 
-	token, err := g.ExchangeCode(code)
+	token, err := g.ExchangeCode(code, verifier)
 	if err != nil {
-		return nil, err
+		return nil, login, err
 	}
 
-	return token, nil
+	return token, login, nil
 }
@@ -0,0 +1,569 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"google.golang.org/api/sheets/v4"
+)
+
+// how long a sheet's header row is trusted before it is read again, so
+// columns someone added or moved by hand are eventually picked up
+const headerCacheTTL = 5 * time.Minute
+
+// questionnaireField is a QuestionnarieData field that can be written to a column.
+type questionnaireField struct {
+	index    int
+	name     string
+	jsonName string
+}
+
+var questionnaireFields = func() []questionnaireField {
+	t := reflect.TypeOf(model.QuestionnarieData{})
+	fields := make([]questionnaireField, t.NumField())
+	for i := range fields {
+		f := t.Field(i)
+		fields[i] = questionnaireField{
+			index:    i,
+			name:     f.Name,
+			jsonName: strings.Split(f.Tag.Get("json"), ",")[0],
+		}
+	}
+	return fields
+}()
+
+// header is the text written to the header row for the field when the
+// mapping doesn't name one.
+func (f questionnaireField) header() string {
+	return strings.ToUpper(f.name)
+}
+
+// matches reports whether a header cell names the field, by its Go or JSON
+// name, ignoring case, spaces and punctuation.
+func (f questionnaireField) matches(header string) bool {
+	h := normalizeHeader(header)
+	return h != "" && (h == normalizeHeader(f.name) || h == normalizeHeader(f.jsonName))
+}
+
+func normalizeHeader(header string) string {
+	b := strings.Builder{}
+	for _, r := range header {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// Formatter turns the value of a field into the value written to its cell.
+// Pointers have already been dereferenced, a nil pointer arrives as nil.
+type Formatter func(value interface{}) interface{}
+
+// Formatters are the formatters a column mapping can refer to by name.
+var Formatters = map[string]Formatter{
+	"":         defaultFormat,
+	"default":  defaultFormat,
+	"date":     timeFormat("2006-01-02"),
+	"time":     timeFormat("15:04:05"),
+	"datetime": timeFormat("2006-01-02 15:04:05"),
+	"upper":    stringFormat(strings.ToUpper),
+	"lower":    stringFormat(strings.ToLower),
+	"yes_no": func(value interface{}) interface{} {
+		if b, ok := value.(bool); ok {
+			if b {
+				return "Yes"
+			}
+			return "No"
+		}
+		return defaultFormat(value)
+	},
+}
+
+// defaultFormat converts a value to something the Sheets API accepts in a cell.
+func defaultFormat(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case []string:
+		return strings.Join(v, ", ")
+	default:
+		return v
+	}
+}
+
+func timeFormat(layout string) Formatter {
+	return func(value interface{}) interface{} {
+		if t, ok := value.(time.Time); ok && !t.IsZero() {
+			return t.UTC().Format(layout)
+		}
+		return defaultFormat(value)
+	}
+}
+
+func stringFormat(fn func(string) string) Formatter {
+	return func(value interface{}) interface{} {
+		switch v := defaultFormat(value).(type) {
+		case string:
+			return fn(v)
+		default:
+			return v
+		}
+	}
+}
+
+// fieldValue dereferences a field so formatters see plain values.
+func fieldValue(v reflect.Value) interface{} {
+	switch value := v.Interface().(type) {
+	case []*string:
+		options := []string{}
+		for _, option := range value {
+			if option != nil {
+				options = append(options, *option)
+			}
+		}
+		return options
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		return v.Elem().Interface()
+	}
+
+	return v.Interface()
+}
+
+// DefaultColumnMapping writes every questionnaire field under its upper-cased
+// Go name, in alphabetical order.
+func DefaultColumnMapping() []model.ColumnMapping {
+	fields := make([]questionnaireField, len(questionnaireFields))
+	copy(fields, questionnaireFields)
+	sortFields(fields)
+
+	columns := make([]model.ColumnMapping, len(fields))
+	for i, field := range fields {
+		columns[i] = model.ColumnMapping{Field: field.jsonName, Header: field.header()}
+	}
+	return columns
+}
+
+// ValidateColumnMapping checks that every column refers to a known field and
+// formatter and that no two columns share a header.
+func ValidateColumnMapping(columns []model.ColumnMapping) error {
+	_, err := resolveColumns(columns)
+	return err
+}
+
+// column is a ColumnMapping resolved against QuestionnarieData.
+type column struct {
+	field  questionnaireField
+	header string
+	format Formatter
+}
+
+func resolveColumns(columns []model.ColumnMapping) ([]column, error) {
+	if len(columns) == 0 {
+		columns = DefaultColumnMapping()
+	}
+
+	resolved := make([]column, 0, len(columns))
+	headers := map[string]bool{}
+
+	for _, mapping := range columns {
+		field, ok := lookupField(mapping.Field)
+		if !ok {
+			return nil, fmt.Errorf("unknown questionnaire field %q", mapping.Field)
+		}
+
+		format, ok := Formatters[mapping.Formatter]
+		if !ok {
+			return nil, fmt.Errorf("unknown formatter %q for field %q", mapping.Formatter, mapping.Field)
+		}
+
+		header := mapping.Header
+		if header == "" {
+			header = field.header()
+		}
+
+		if headers[normalizeHeader(header)] {
+			return nil, fmt.Errorf("duplicate column header %q", header)
+		}
+		headers[normalizeHeader(header)] = true
+
+		resolved = append(resolved, column{field: field, header: header, format: format})
+	}
+
+	return resolved, nil
+}
+
+// Cell is the value of a mapped column for one answer.
+type Cell struct {
+	// Field is the JSON name of the field the column writes.
+	Field  string
+	Header string
+	Value  interface{}
+}
+
+// MapCells formats data into the columns of the mapping, in mapping order,
+// the way they are written to a sheet. Sinks other than Google Sheets lay
+// their rows out with it.
+func MapCells(columns []model.ColumnMapping, data *model.QuestionnarieData) ([]Cell, error) {
+	mapped, err := resolveColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(data).Elem()
+	cells := make([]Cell, len(mapped))
+	for i, c := range mapped {
+		cells[i] = Cell{
+			Field:  c.field.jsonName,
+			Header: c.header,
+			Value:  c.format(fieldValue(v.Field(c.field.index))),
+		}
+	}
+	return cells, nil
+}
+
+// SameHeader reports whether two header cells read alike, ignoring case,
+// spaces and punctuation.
+func SameHeader(a string, b string) bool {
+	return normalizeHeader(a) == normalizeHeader(b)
+}
+
+func lookupField(name string) (questionnaireField, bool) {
+	for _, field := range questionnaireFields {
+		if field.matches(name) {
+			return field, true
+		}
+	}
+	return questionnaireField{}, false
+}
+
+// mappingSignature identifies a column mapping, so sheets written with
+// different mappings don't share a cached layout.
+func mappingSignature(columns []model.ColumnMapping) string {
+	b := strings.Builder{}
+	for _, c := range columns {
+		fmt.Fprintf(&b, "%s|%s|%s;", c.Field, c.Header, c.Formatter)
+	}
+	return b.String()
+}
+
+// sheetLayout maps the mapped columns to the columns of a sheet.
+type sheetLayout struct {
+	headers   []string
+	mapped    []column
+	positions map[int]int // mapped column -> sheet column
+}
+
+func newSheetLayout(headers []string, mapped []column) *sheetLayout {
+	layout := &sheetLayout{
+		headers:   headers,
+		mapped:    mapped,
+		positions: make(map[int]int),
+	}
+
+	taken := map[int]bool{}
+	place := func(i int, matches func(header string) bool) {
+		for position, header := range headers {
+			if !taken[position] && matches(header) {
+				layout.positions[i] = position
+				taken[position] = true
+				return
+			}
+		}
+	}
+
+	// a header reading exactly like the mapping wins over one that merely
+	// names the same field
+	for i, c := range mapped {
+		label := normalizeHeader(c.header)
+		place(i, func(header string) bool { return normalizeHeader(header) == label })
+	}
+
+	for i, c := range mapped {
+		if _, ok := layout.positions[i]; !ok {
+			place(i, c.field.matches)
+		}
+	}
+
+	return layout
+}
+
+// missing returns the mapped columns the sheet has no header for, in mapping order.
+func (l *sheetLayout) missing() []int {
+	missing := []int{}
+	for i := range l.mapped {
+		if _, ok := l.positions[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// add gives each of the mapped columns a new column after the existing ones.
+func (l *sheetLayout) add(missing []int) {
+	for _, i := range missing {
+		l.positions[i] = len(l.headers)
+		l.headers = append(l.headers, l.mapped[i].header)
+	}
+}
+
+// row lays data out so each value lands under its column's header.
+func (l *sheetLayout) row(data *model.QuestionnarieData) []interface{} {
+	row := make([]interface{}, len(l.headers))
+	for i := range row {
+		row[i] = ""
+	}
+
+	for position, value := range l.cells(data) {
+		row[position] = value
+	}
+
+	return row
+}
+
+// cells returns the values of the mapped columns by sheet column.
+func (l *sheetLayout) cells(data *model.QuestionnarieData) map[int]interface{} {
+	cells := make(map[int]interface{}, len(l.positions))
+
+	v := reflect.ValueOf(data).Elem()
+	for i, position := range l.positions {
+		c := l.mapped[i]
+		cells[position] = c.format(fieldValue(v.Field(c.field.index)))
+	}
+
+	return cells
+}
+
+// key returns the key of a row from the cells in the given sheet columns,
+// which is what rowIndex reads back once the row is written.
+func (l *sheetLayout) key(cells map[int]interface{}, columns []int) string {
+	parts := make([]interface{}, len(columns))
+	for i, position := range columns {
+		parts[i] = cells[position]
+	}
+	return rowKey(parts...)
+}
+
+// field returns the sheet column of the mapped column writing the field with
+// the given JSON name.
+func (l *sheetLayout) field(jsonName string) (int, bool) {
+	for i, c := range l.mapped {
+		if c.field.jsonName == jsonName {
+			position, ok := l.positions[i]
+			return position, ok
+		}
+	}
+	return 0, false
+}
+
+// extra returns the sheet column of a header that doesn't belong to any of
+// the mapped columns, e.g. a question column in response mode.
+func (l *sheetLayout) extra(header string) (int, bool) {
+	taken := map[int]bool{}
+	for _, position := range l.positions {
+		taken[position] = true
+	}
+
+	label := normalizeHeader(header)
+	for position, h := range l.headers {
+		if !taken[position] && normalizeHeader(h) == label {
+			return position, true
+		}
+	}
+	return 0, false
+}
+
+// questions returns the sheet columns that don't belong to any of the mapped
+// columns, which are the question columns in response mode.
+func (l *sheetLayout) questions() []int {
+	taken := map[int]bool{}
+	for _, position := range l.positions {
+		taken[position] = true
+	}
+
+	columns := []int{}
+	for position := range l.headers {
+		if !taken[position] {
+			columns = append(columns, position)
+		}
+	}
+	return columns
+}
+
+// clone copies l so it can be extended without touching a cached layout.
+func (l *sheetLayout) clone() *sheetLayout {
+	c := &sheetLayout{
+		headers:   append([]string{}, l.headers...),
+		mapped:    l.mapped,
+		positions: make(map[int]int, len(l.positions)),
+	}
+	for i, position := range l.positions {
+		c.positions[i] = position
+	}
+	return c
+}
+
+// sortFields orders fields by the header they get by default.
+func sortFields(fields []questionnaireField) {
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].header() < fields[j].header()
+	})
+}
+
+type layoutKey struct {
+	sheetKey
+	mapping string
+}
+
+type cachedLayout struct {
+	layout  *sheetLayout
+	expires time.Time
+}
+
+// layoutCache remembers the header row of the sheets written to, so it isn't
+// read on every append. It is shared by every sheets client of a GoogleClient.
+type layoutCache struct {
+	mu      sync.Mutex
+	layouts map[layoutKey]cachedLayout
+}
+
+func newLayoutCache() *layoutCache {
+	return &layoutCache{layouts: make(map[layoutKey]cachedLayout)}
+}
+
+func (c *layoutCache) get(key layoutKey) (*sheetLayout, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.layouts[key]
+	if !ok || time.Now().After(cached.expires) {
+		return nil, false
+	}
+	return cached.layout, true
+}
+
+func (c *layoutCache) put(key layoutKey, layout *sheetLayout) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.layouts[key] = cachedLayout{layout: layout, expires: time.Now().Add(headerCacheTTL)}
+}
+
+// forget drops every cached layout of a sheet, whatever its mapping.
+func (c *layoutCache) forget(key sheetKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.layouts {
+		if k.sheetKey == key {
+			delete(c.layouts, k)
+		}
+	}
+}
+
+// sheetLayout reads the header row of sheet and maps every column of the
+// mapping to its position, adding a header for each column that has none yet.
+func (gs *GoogleSheetClient) sheetLayout(spreadSheetID string, sheet string, columns []model.ColumnMapping) (*sheetLayout, error) {
+	key := layoutKey{sheetKey: sheetKey{spreadSheetID: spreadSheetID, sheet: sheet}, mapping: mappingSignature(columns)}
+	if layout, ok := gs.layouts.get(key); ok {
+		return layout, nil
+	}
+
+	mapped, err := resolveColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := gs.headerRow(spreadSheetID, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := newSheetLayout(headers, mapped)
+
+	if missing := layout.missing(); len(missing) > 0 {
+		start := len(layout.headers)
+		layout.add(missing)
+
+		if err := gs.writeHeaders(spreadSheetID, sheet, start, layout.headers[start:]); err != nil {
+			return nil, err
+		}
+	}
+
+	gs.layouts.put(key, layout)
+	return layout, nil
+}
+
+// readLayout is sheetLayout for callers that only read the sheet: columns
+// without a header are left unplaced instead of being added.
+func (gs *GoogleSheetClient) readLayout(spreadSheetID string, sheet string, columns []model.ColumnMapping) (*sheetLayout, error) {
+	key := layoutKey{sheetKey: sheetKey{spreadSheetID: spreadSheetID, sheet: sheet}, mapping: mappingSignature(columns)}
+	if layout, ok := gs.layouts.get(key); ok {
+		return layout, nil
+	}
+
+	mapped, err := resolveColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := gs.headerRow(spreadSheetID, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSheetLayout(headers, mapped), nil
+}
+
+func (gs *GoogleSheetClient) headerRow(spreadSheetID string, sheet string) ([]string, error) {
+	valueRange, err := gs.api.GetValues(context.Background(), spreadSheetID, fmt.Sprintf("%s!1:1", quoteSheet(sheet)))
+	if err != nil {
+		return nil, err
+	}
+
+	headers := []string{}
+	if len(valueRange.Values) > 0 {
+		for _, cell := range valueRange.Values[0] {
+			headers = append(headers, fmt.Sprint(cell))
+		}
+	}
+	return headers, nil
+}
+
+// writeHeaders writes headers to the header row, starting at column start.
+func (gs *GoogleSheetClient) writeHeaders(spreadSheetID string, sheet string, start int, headers []string) error {
+	cells := make([]interface{}, len(headers))
+	for i, header := range headers {
+		cells[i] = header
+	}
+
+	cellRange := fmt.Sprintf("%s!R1C%d:R1C%d", quoteSheet(sheet), start+1, start+len(headers))
+	values := &sheets.ValueRange{Values: [][]interface{}{cells}}
+
+	_, err := gs.api.UpdateValues(context.Background(), spreadSheetID, cellRange, values)
+	if err != nil {
+		gs.logger.Error("failed to write column headers :: stacktrace ::", err)
+		return err
+	}
+	return nil
+}
+
+// quoteSheet quotes a sheet title for use in A1 notation.
+func quoteSheet(sheet string) string {
+	return "'" + strings.ReplaceAll(sheet, "'", "''") + "'"
+}
@@ -0,0 +1,97 @@
+package google
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func defaultColumns(t *testing.T) []column {
+	columns, err := resolveColumns(nil)
+	require.NoError(t, err)
+	return columns
+}
+
+func TestSheetLayoutAlignsRowsWithHeaders(t *testing.T) {
+	// headers out of order, one written by AppendColumnHeaders and one by hand
+	layout := newSheetLayout([]string{"ANSWER", "Respondent Email", "SELECTEDANSWEROPTIONS", "Notes"}, defaultColumns(t))
+
+	missing := layout.missing()
+	require.Len(t, missing, len(questionnaireFields)-3)
+	layout.add(missing)
+	require.Len(t, layout.headers, len(questionnaireFields)+1)
+
+	answeredOn := time.Date(2022, 10, 20, 9, 30, 0, 0, time.UTC)
+	row := layout.row(&model.QuestionnarieData{
+		Answer:                strPtr("yes"),
+		RespondentEmail:       strPtr("jane@example.com"),
+		SelectedAnswerOptions: strPtr("option-b"),
+		Options:               []*string{strPtr("option-a"), strPtr("option-b")},
+		AnsweredOn:            answeredOn,
+	})
+
+	require.Equal(t, "yes", row[0])
+	require.Equal(t, "jane@example.com", row[1])
+	require.Equal(t, "option-b", row[2])
+	// columns that don't belong to a field are left alone
+	require.Equal(t, "", row[3])
+
+	for column, header := range layout.headers {
+		switch header {
+		case "OPTIONS":
+			require.Equal(t, "option-a, option-b", row[column])
+		case "ANSWEREDON":
+			require.Equal(t, "2022-10-20T09:30:00Z", row[column])
+		case "FORMENDDATE", "RESPONDENTID":
+			require.Equal(t, "", row[column])
+		}
+	}
+}
+
+func TestSheetLayoutMatchesJSONNames(t *testing.T) {
+	layout := newSheetLayout([]string{"respondent_phone_number", "form_id"}, defaultColumns(t))
+
+	row := layout.row(&model.QuestionnarieData{FormID: strPtr("form-1"), RespondentPhoneNumber: strPtr("+2348000000000")})
+	require.Equal(t, []interface{}{"+2348000000000", "form-1"}, row)
+}
+
+func TestCustomColumnMapping(t *testing.T) {
+	mapping := []model.ColumnMapping{
+		{Field: "respondent_email", Header: "Email"},
+		{Field: "answered_on", Header: "Answered", Formatter: "date"},
+		{Field: "is_required", Header: "Required?", Formatter: "yes_no"},
+		{Field: "answer", Formatter: "upper"},
+	}
+
+	columns, err := resolveColumns(mapping)
+	require.NoError(t, err)
+
+	layout := newSheetLayout([]string{}, columns)
+	layout.add(layout.missing())
+	require.Equal(t, []string{"Email", "Answered", "Required?", "ANSWER"}, layout.headers)
+
+	required := true
+	row := layout.row(&model.QuestionnarieData{
+		RespondentEmail: strPtr("jane@example.com"),
+		AnsweredOn:      time.Date(2022, 10, 20, 9, 30, 0, 0, time.UTC),
+		IsRequired:      &required,
+		Answer:          strPtr("maybe"),
+		OrgID:           strPtr("not mapped"),
+	})
+	require.Equal(t, []interface{}{"jane@example.com", "2022-10-20", "Yes", "MAYBE"}, row)
+}
+
+func TestValidateColumnMapping(t *testing.T) {
+	require.NoError(t, ValidateColumnMapping(nil))
+	require.NoError(t, ValidateColumnMapping(DefaultColumnMapping()))
+
+	require.Error(t, ValidateColumnMapping([]model.ColumnMapping{{Field: "favourite_colour"}}))
+	require.Error(t, ValidateColumnMapping([]model.ColumnMapping{{Field: "answer", Formatter: "shout"}}))
+	require.Error(t, ValidateColumnMapping([]model.ColumnMapping{{Field: "answer"}, {Field: "answer_id", Header: "answer"}}))
+}
@@ -0,0 +1,203 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/sheets/v4"
+)
+
+var ErrInvalidServiceAccountKey = errors.New("invalid service account key")
+
+// CredentialProvider authorises the Sheets API calls of a client.
+type CredentialProvider interface {
+	// TokenSource returns where the client's access tokens come from, and a
+	// key for the principal they act as, which is rate limited on its own.
+	TokenSource(ctx context.Context) (oauth2.TokenSource, string, error)
+}
+
+// userToken acts as a user with a token the caller holds.
+type userToken struct {
+	config *oauth2.Config
+	token  *oauth2.Token
+}
+
+func (u *userToken) TokenSource(ctx context.Context) (oauth2.TokenSource, string, error) {
+	if u.token == nil {
+		return nil, "", ErrTokenNotFound
+	}
+	return u.config.TokenSource(context.Background(), u.token), userKey(u.token), nil
+}
+
+// storedUserToken acts as a user with the token stored under id for orgID,
+// saving it back whenever it gets refreshed.
+type storedUserToken struct {
+	g      *GoogleClient
+	id     string
+	orgID  string
+	logger logger.AppLogger
+}
+
+func (s *storedUserToken) TokenSource(ctx context.Context) (oauth2.TokenSource, string, error) {
+	if s.g.tokens == nil {
+		return nil, "", ErrNoTokenStore
+	}
+
+	stored, owner, err := s.g.tokens.load(ctx, s.id)
+	if err != nil {
+		return nil, "", err
+	}
+	if !owner.Owns(s.orgID) {
+		return nil, "", ErrTokenNotOwned
+	}
+
+	source := oauth2.ReuseTokenSource(stored, &storedTokenSource{
+		ctx:       ctx,
+		config:    s.g.config,
+		tokens:    s.g.tokens,
+		id:        s.id,
+		onRevoked: s.g.onTokenRevoked,
+		logger:    s.logger,
+	})
+	return source, userKey(stored), nil
+}
+
+// serviceAccount acts as the service account of the key stored under keyID
+// for orgID, or as subject when the account is allowed domain-wide
+// delegation.
+type serviceAccount struct {
+	g       *GoogleClient
+	keyID   string
+	orgID   string
+	subject string
+}
+
+func (s *serviceAccount) TokenSource(ctx context.Context) (oauth2.TokenSource, string, error) {
+	if s.g.tokens == nil {
+		return nil, "", ErrNoTokenStore
+	}
+
+	key, st, err := s.g.tokens.get(ctx, s.keyID, secretServiceAccount)
+	if err != nil {
+		return nil, "", err
+	}
+	if !st.owner().Owns(s.orgID) {
+		return nil, "", ErrTokenNotOwned
+	}
+	if s.subject != "" && !containsFold(st.Subjects, s.subject) {
+		return nil, "", fmt.Errorf("%w: %s", ErrSubjectNotAllowed, s.subject)
+	}
+
+	config, err := serviceAccountConfig(key, s.g.config.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	config.Subject = s.subject
+
+	// the subject has its own quota, like any other user
+	principal := config.Email
+	if s.subject != "" {
+		principal = s.subject
+	}
+	return config.TokenSource(context.Background()), principal, nil
+}
+
+// containsFold reports whether one of values equals s, ignoring case as email
+// addresses do.
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceAccountConfig parses a service account's JSON key, asking for scopes
+// or, without any, for access to spreadsheets.
+func serviceAccountConfig(key []byte, scopes []string) (*jwt.Config, error) {
+	if len(scopes) == 0 {
+		scopes = []string{sheets.SpreadsheetsScope}
+	}
+
+	config, err := google.JWTConfigFromJSON(key, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidServiceAccountKey, err)
+	}
+	return config, nil
+}
+
+// ServiceAccountEmail returns the address of the service account key is for,
+// failing when it isn't a service account's JSON key.
+func ServiceAccountEmail(key []byte) (string, error) {
+	config, err := serviceAccountConfig(key, nil)
+	if err != nil {
+		return "", err
+	}
+	return config.Email, nil
+}
+
+// CredentialRef names the credentials of an integration, message or
+// spreadsheet, see model.CredentialType.
+type CredentialRef struct {
+	Type model.CredentialType
+	// OrgID is the organization the credentials act for. Stored tokens and
+	// service account keys of another one are refused.
+	OrgID   string
+	TokenID string
+	// Token is the user's token from callers that still pass it around.
+	Token               *oauth2.Token
+	ServiceAccountKeyID string
+	Subject             string
+}
+
+// Key identifies the credentials ref names and the organization they act
+// for, telling apart writes that must not be made as one another.
+func (ref CredentialRef) Key() string {
+	switch {
+	case ref.Type == model.CredentialServiceAccount:
+		return strings.Join([]string{ref.OrgID, string(ref.Type), ref.ServiceAccountKeyID, strings.ToLower(ref.Subject)}, "\x00")
+	case ref.TokenID != "":
+		return strings.Join([]string{ref.OrgID, "token", ref.TokenID}, "\x00")
+	default:
+		return strings.Join([]string{ref.OrgID, "passed", userKey(ref.Token)}, "\x00")
+	}
+}
+
+// Credentials returns the provider for ref. User credentials use the stored
+// token when there is a token ID, or else the token passed along.
+func (g *GoogleClient) Credentials(ref CredentialRef, logger logger.AppLogger) (CredentialProvider, error) {
+	if err := model.ValidateCredentials(ref.Type, ref.ServiceAccountKeyID); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ref.Type == model.CredentialServiceAccount:
+		return &serviceAccount{g: g, keyID: ref.ServiceAccountKeyID, orgID: ref.OrgID, subject: ref.Subject}, nil
+	case ref.TokenID != "":
+		return &storedUserToken{g: g, id: ref.TokenID, orgID: ref.OrgID, logger: logger}, nil
+	default:
+		return &userToken{config: g.config, token: ref.Token}, nil
+	}
+}
+
+// SheetClient returns a client whose calls are authorised by credentials.
+func (g *GoogleClient) SheetClient(ctx context.Context, credentials CredentialProvider, logger logger.AppLogger) (*GoogleSheetClient, error) {
+	source, principal, err := credentials.TokenSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := newGoogleSheetClient(g, source, principal, logger)
+	if client == nil {
+		return nil, ErrFailedSheetSvcCreation
+	}
+	return client, nil
+}
@@ -0,0 +1,118 @@
+package google
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceAccountCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	subjects := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the claims of the signed assertion
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		require.Len(t, parts, 3)
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+
+		claims := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(payload, &claims))
+		sub, _ := claims["sub"].(string)
+		subjects = append(subjects, sub)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"service","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	key, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "connector@project.iam.gserviceaccount.com",
+		"private_key_id": "1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})),
+		"token_uri":      server.URL,
+	})
+	require.NoError(t, err)
+
+	email, err := ServiceAccountEmail(key)
+	require.NoError(t, err)
+	require.Equal(t, "connector@project.iam.gserviceaccount.com", email)
+
+	_, err = ServiceAccountEmail([]byte(`{"type":"authorized_user"}`))
+	require.ErrorIs(t, err, ErrInvalidServiceAccountKey)
+
+	tokens, err := NewTokenStore(memoryTokens{}, []TokenKey{tokenKey("k1", 1)})
+	require.NoError(t, err)
+	g := NewGoogleClient("client", "secret", nil, "", nil, WithTokenStore(tokens))
+
+	_, err = tokens.CreateServiceAccountKey(ctx, TokenOwner{}, key, nil)
+	require.ErrorIs(t, err, ErrMissingKeyOrg)
+
+	keyID, err := tokens.CreateServiceAccountKey(ctx, TokenOwner{OrgID: "org"}, key, []string{"user@example.com"})
+	require.NoError(t, err)
+
+	// a key is not a user token
+	_, err = tokens.Load(ctx, keyID)
+	require.ErrorIs(t, err, ErrTokenNotFound)
+
+	_, err = g.Credentials(CredentialRef{Type: model.CredentialServiceAccount}, nil)
+	require.Error(t, err)
+
+	for _, subject := range []string{"", "user@example.com"} {
+		credentials, err := g.Credentials(CredentialRef{Type: model.CredentialServiceAccount, OrgID: "org", ServiceAccountKeyID: keyID, Subject: subject}, nil)
+		require.NoError(t, err)
+
+		source, principal, err := credentials.TokenSource(ctx)
+		require.NoError(t, err)
+
+		token, err := source.Token()
+		require.NoError(t, err)
+		require.Equal(t, "service", token.AccessToken)
+
+		if subject == "" {
+			require.Equal(t, email, principal)
+		} else {
+			require.Equal(t, subject, principal)
+		}
+	}
+	require.Equal(t, []string{"", "user@example.com"}, subjects)
+
+	// only the subjects the key was stored with can be acted as
+	credentials, err := g.Credentials(CredentialRef{Type: model.CredentialServiceAccount, OrgID: "org", ServiceAccountKeyID: keyID, Subject: "admin@example.com"}, nil)
+	require.NoError(t, err)
+	_, _, err = credentials.TokenSource(ctx)
+	require.ErrorIs(t, err, ErrSubjectNotAllowed)
+	require.True(t, IsPermanentError(err))
+
+	// nor be used by another organization, or by no organization at all
+	for _, orgID := range []string{"other", ""} {
+		credentials, err := g.Credentials(CredentialRef{Type: model.CredentialServiceAccount, OrgID: orgID, ServiceAccountKeyID: keyID, Subject: "user@example.com"}, nil)
+		require.NoError(t, err)
+		_, _, err = credentials.TokenSource(ctx)
+		require.ErrorIs(t, err, ErrTokenNotOwned)
+		require.True(t, IsPermanentError(err))
+	}
+
+	// nor can they be swapped for others in the store
+	sealed, err := tokens.backend.GetToken(ctx, keyID)
+	require.NoError(t, err)
+	require.NoError(t, tokens.backend.PutToken(ctx, keyID, []byte(strings.Replace(string(sealed), "user@example.com", "admin@example.com", 1))))
+	_, _, err = tokens.LoadServiceAccountKey(ctx, keyID)
+	require.Error(t, err)
+}
@@ -0,0 +1,390 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"google.golang.org/api/sheets/v4"
+)
+
+var ErrNoRowReference = errors.New("sheet has no answer_id or respondent_id column to find rows by")
+
+// PIIFields are the fields blanked when a respondent is redacted, unless the
+// integration lists its own.
+var PIIFields = []string{"respondent_email", "respondent_phone_number"}
+
+// RedactAnswers stands for the answers of a respondent in a list of fields to
+// redact: the answer fields, and the question columns in response mode.
+const RedactAnswers = "answers"
+
+// answerFields hold a respondent's answer in answer and upsert mode.
+var answerFields = []string{"answer", "selected_answer_option"}
+
+// ValidateRedactFields checks that every field to redact is a questionnaire
+// field or RedactAnswers.
+func ValidateRedactFields(fields []string) error {
+	for _, field := range fields {
+		if _, ok := lookupField(field); !ok && field != RedactAnswers {
+			return fmt.Errorf("unknown field to redact %q", field)
+		}
+	}
+	return nil
+}
+
+// RedactedFields returns the JSON names of the fields a redaction with the
+// given list of fields blanks, PIIFields when it is empty, and whether it
+// blanks the question columns of sheets in response mode too.
+func RedactedFields(fields []string) ([]string, bool) {
+	if len(fields) == 0 {
+		return PIIFields, false
+	}
+
+	names := []string{}
+	answers := false
+	for _, field := range fields {
+		if field == RedactAnswers {
+			names = append(names, answerFields...)
+			answers = true
+		} else if f, ok := lookupField(field); ok {
+			names = append(names, f.jsonName)
+		}
+	}
+	return names, answers
+}
+
+// rowMatch is the sheet columns and values a row must hold to be erased.
+type rowMatch struct {
+	columns []int
+	values  []string
+}
+
+// matchFor returns how to find the rows of data. An answer is found by its
+// answer_id, a respondent by respondent_id, narrowed to the form when the
+// sheet has a form_id column. byRespondent, like data without an answer ID,
+// matches every row of the respondent. In a sheet that isn't the target's,
+// see anySheet, a respondent of a form is only found along with the form.
+// When the sheet lacks the column to find the rows by there is no match,
+// rather than one finding more or fewer rows than asked for.
+func (l *sheetLayout) matchFor(data *model.QuestionnarieData, byRespondent bool, anySheet bool) (rowMatch, bool) {
+	cells := l.cells(data)
+
+	value := func(field string) (int, string, bool) {
+		position, ok := l.field(field)
+		if !ok {
+			return 0, "", false
+		}
+		v := fmt.Sprint(cells[position])
+		return position, v, v != ""
+	}
+
+	if !byRespondent && hasAnswerID(data) {
+		position, v, ok := value("answer_id")
+		return rowMatch{columns: []int{position}, values: []string{v}}, ok
+	}
+
+	position, v, ok := value("respondent_id")
+	if !ok {
+		return rowMatch{}, false
+	}
+
+	match := rowMatch{columns: []int{position}, values: []string{v}}
+	if position, v, ok := value("form_id"); ok {
+		match.columns = append(match.columns, position)
+		match.values = append(match.values, v)
+	} else if anySheet && data.FormID != nil && *data.FormID != "" {
+		// the respondent's rows of other forms aren't the ones asked for
+		return rowMatch{}, false
+	}
+	return match, true
+}
+
+func hasAnswerID(data *model.QuestionnarieData) bool {
+	return data.AnswerID != nil && *data.AnswerID != ""
+}
+
+// matchingRows returns the numbers of the rows of target matching data, in
+// ascending order.
+func (gs *GoogleSheetClient) matchingRows(target Target, layout *sheetLayout, data []*model.QuestionnarieData, byRespondent bool) ([]int, error) {
+	matches := []rowMatch{}
+	for _, d := range data {
+		match, ok := layout.matchFor(d, byRespondent, target.anySheet)
+		if !ok {
+			return nil, ErrNoRowReference
+		}
+		matches = append(matches, match)
+	}
+
+	// read every column any of the matches looks at in one go
+	columns := []int{}
+	read := map[int]int{}
+	for _, match := range matches {
+		for _, column := range match.columns {
+			if _, ok := read[column]; !ok {
+				read[column] = len(columns)
+				columns = append(columns, column)
+			}
+		}
+	}
+
+	values, length, err := gs.columnValues(target.SpreadSheetID, target.Sheet, columns...)
+	if err != nil {
+		return nil, err
+	}
+
+	cell := func(column int, i int) string {
+		v := values[read[column]]
+		if i < len(v) {
+			return fmt.Sprint(v[i])
+		}
+		return ""
+	}
+
+	rows := []int{}
+	for i := 0; i < length; i++ {
+		for _, match := range matches {
+			matched := true
+			for c, column := range match.columns {
+				if cell(column, i) != match.values[c] {
+					matched = false
+					break
+				}
+			}
+
+			if matched {
+				rows = append(rows, i+2)
+				break
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// erase carries out a delete or redaction on every part of the spreadsheet.
+// Within a part it is applied to the target sheet, if the part has it, or to
+// every sheet when the target names none. Sheets without the columns to find
+// rows by are then left alone.
+func (gs *GoogleSheetClient) erase(target Target, op model.Operation, data []*model.QuestionnarieData) error {
+	parts, err := gs.Parts(target.SpreadSheetID)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		props, err := gs.sheetProperties(part, false)
+		if err != nil {
+			return err
+		}
+
+		// answers of other forms share the spreadsheet, so a part without
+		// the target's sheet holds nothing to erase
+		titles := []string{}
+		for _, p := range props {
+			if target.Sheet == "" || p.Title == target.Sheet {
+				titles = append(titles, p.Title)
+			}
+		}
+
+		for _, title := range titles {
+			t := target
+			t.SpreadSheetID = part
+			t.Sheet = title
+			t.anySheet = target.Sheet == ""
+
+			err := gs.eraseSheet(t, target.SpreadSheetID, op, data)
+			if err != nil && (!t.anySheet || !errors.Is(err, ErrNoRowReference)) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eraseSheet erases from one sheet of a part, holding the lock writes to the
+// sheet of the spreadsheet take.
+func (gs *GoogleSheetClient) eraseSheet(target Target, spreadSheetID string, op model.Operation, data []*model.QuestionnarieData) error {
+	unlock := gs.locks.lock(sheetKey{spreadSheetID: spreadSheetID, sheet: target.Sheet})
+	defer unlock()
+
+	var err error
+	if op == model.OperationDelete {
+		err = gs.deleteRows(target, data)
+	} else {
+		err = gs.redactRows(target, data)
+	}
+
+	if err != nil {
+		gs.forget(target)
+	}
+	return err
+}
+
+// deleteRows removes the rows of the given answers, or of their respondents
+// when they carry no answer ID, from the target sheet. In response mode a row
+// holds every answer of a respondent, so single answers are cleared from it
+// instead.
+func (gs *GoogleSheetClient) deleteRows(target Target, data []*model.QuestionnarieData) error {
+	layout, err := gs.readLayout(target.SpreadSheetID, target.Sheet, target.columns())
+	if err != nil {
+		return err
+	}
+
+	if target.Mode == model.WriteModeResponse {
+		respondents := []*model.QuestionnarieData{}
+		for _, d := range data {
+			if !hasAnswerID(d) {
+				respondents = append(respondents, d)
+				continue
+			}
+			if err := gs.clearAnswer(target, layout, d); err != nil {
+				return err
+			}
+		}
+
+		if data = respondents; len(data) == 0 {
+			return nil
+		}
+	}
+
+	rows, err := gs.matchingRows(target, layout, data, false)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+
+	sheetID, err := gs.sheetID(target.SpreadSheetID, target.Sheet)
+	if err != nil {
+		return err
+	}
+
+	// bottom up, so the rows still to delete keep their numbers
+	sort.Sort(sort.Reverse(sort.IntSlice(rows)))
+
+	requests := make([]*sheets.Request, len(rows))
+	for i, row := range rows {
+		requests[i] = &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    sheetID,
+					Dimension:  "ROWS",
+					StartIndex: int64(row - 1),
+					EndIndex:   int64(row),
+				},
+			},
+		}
+	}
+
+	req := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	if _, err := gs.api.BatchUpdate(context.Background(), target.SpreadSheetID, req); err != nil {
+		gs.logger.Error("failed to delete rows :: stacktrace ::", err)
+		return err
+	}
+
+	// the rows below moved up
+	gs.indexes.forget(sheetKey{spreadSheetID: target.SpreadSheetID, sheet: target.Sheet})
+	return nil
+}
+
+// clearAnswer blanks the cell of an answer in the row of its respondent in a
+// sheet in response mode. The answer has to name its question and respondent
+// for the cell to be found.
+func (gs *GoogleSheetClient) clearAnswer(target Target, layout *sheetLayout, data *model.QuestionnarieData) error {
+	if data.QuestionTitle == nil || *data.QuestionTitle == "" {
+		return fmt.Errorf("%w: deleting an answer from a response needs its question_title", ErrNoRowReference)
+	}
+
+	column, ok := layout.extra(*data.QuestionTitle)
+	if !ok {
+		// the question was never answered in this sheet
+		return nil
+	}
+
+	rows, err := gs.matchingRows(target, layout, []*model.QuestionnarieData{data}, true)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+
+	updates := make([]*sheets.ValueRange, len(rows))
+	for i, row := range rows {
+		updates[i] = &sheets.ValueRange{
+			Range:  fmt.Sprintf("%s!R%dC%d", quoteSheet(target.Sheet), row, column+1),
+			Values: [][]interface{}{{""}},
+		}
+	}
+
+	req := &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: VALUE_INPUT_OPTION,
+		Data:             updates,
+	}
+
+	if _, err := gs.api.BatchUpdateValues(context.Background(), target.SpreadSheetID, req); err != nil {
+		gs.logger.Error("failed to clear answer :: stacktrace ::", err)
+		return err
+	}
+	return nil
+}
+
+// redactRows blanks the columns of the target's fields to redact in every row
+// of the respondents of the given answers.
+func (gs *GoogleSheetClient) redactRows(target Target, data []*model.QuestionnarieData) error {
+	layout, err := gs.readLayout(target.SpreadSheetID, target.Sheet, target.columns())
+	if err != nil {
+		return err
+	}
+
+	fields, answers := RedactedFields(target.RedactFields)
+
+	columns := []int{}
+	for _, field := range fields {
+		if position, ok := layout.field(field); ok {
+			columns = append(columns, position)
+		}
+	}
+	if answers && target.Mode == model.WriteModeResponse {
+		columns = append(columns, layout.questions()...)
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	rows, err := gs.matchingRows(target, layout, data, true)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+
+	updates := []*sheets.ValueRange{}
+	for _, row := range rows {
+		for _, column := range columns {
+			updates = append(updates, &sheets.ValueRange{
+				Range:  fmt.Sprintf("%s!R%dC%d", quoteSheet(target.Sheet), row, column+1),
+				Values: [][]interface{}{{""}},
+			})
+		}
+	}
+
+	req := &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: VALUE_INPUT_OPTION,
+		Data:             updates,
+	}
+
+	if _, err := gs.api.BatchUpdateValues(context.Background(), target.SpreadSheetID, req); err != nil {
+		gs.logger.Error("failed to redact rows :: stacktrace ::", err)
+		return err
+	}
+	return nil
+}
+
+// sheetID returns the ID of the sheet with the given title.
+func (gs *GoogleSheetClient) sheetID(spreadSheetID string, title string) (int64, error) {
+	props, err := gs.findSheet(spreadSheetID, title, false)
+	if err != nil {
+		return 0, err
+	}
+	if props == nil {
+		return 0, fmt.Errorf("%w: %q in spreadsheet %s", ErrSheetNotFound, title, spreadSheetID)
+	}
+	return props.SheetId, nil
+}
@@ -0,0 +1,70 @@
+package google
+
+import (
+	"testing"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSheetLayoutMatchesRowsToErase(t *testing.T) {
+	layout := newSheetLayout([]string{"ANSWERID", "RESPONDENTID", "FORMID"}, defaultColumns(t))
+	layout.add(layout.missing())
+
+	data := &model.QuestionnarieData{AnswerID: strPtr("a-1"), RespondentID: strPtr("r-1"), FormID: strPtr("f-1")}
+
+	match, ok := layout.matchFor(data, false, false)
+	require.True(t, ok)
+	require.Equal(t, rowMatch{columns: []int{0}, values: []string{"a-1"}}, match)
+
+	// redactions cover every answer of the respondent to the form
+	match, ok = layout.matchFor(data, true, false)
+	require.True(t, ok)
+	require.Equal(t, rowMatch{columns: []int{1, 2}, values: []string{"r-1", "f-1"}}, match)
+
+	// a withdrawal without an answer ID takes all of the respondent's rows
+	match, ok = layout.matchFor(&model.QuestionnarieData{RespondentID: strPtr("r-1")}, false, false)
+	require.True(t, ok)
+	require.Equal(t, rowMatch{columns: []int{1}, values: []string{"r-1"}}, match)
+
+	// a single answer never takes the rest of the respondent's rows with it
+	mapped, err := resolveColumns([]model.ColumnMapping{{Field: "respondent_id"}, {Field: "answer"}})
+	require.NoError(t, err)
+
+	_, ok = newSheetLayout([]string{"RESPONDENTID", "ANSWER"}, mapped).matchFor(data, false, false)
+	require.False(t, ok)
+
+	mapped, err = resolveColumns([]model.ColumnMapping{{Field: "answer"}})
+	require.NoError(t, err)
+
+	_, ok = newSheetLayout([]string{"ANSWER"}, mapped).matchFor(data, false, false)
+	require.False(t, ok)
+
+	// nor does a redaction narrow down to the answer without a respondent column
+	_, ok = newSheetLayout([]string{"ANSWER"}, mapped).matchFor(data, true, false)
+	require.False(t, ok)
+
+	// in sheets of other forms a respondent is only found along with the form
+	mapped, err = resolveColumns([]model.ColumnMapping{{Field: "respondent_id"}, {Field: "answer"}})
+	require.NoError(t, err)
+	other := newSheetLayout([]string{"RESPONDENTID", "ANSWER"}, mapped)
+
+	_, ok = other.matchFor(data, true, true)
+	require.False(t, ok)
+	match, ok = other.matchFor(&model.QuestionnarieData{RespondentID: strPtr("r-1")}, true, true)
+	require.True(t, ok)
+	require.Equal(t, rowMatch{columns: []int{0}, values: []string{"r-1"}}, match)
+}
+
+func TestRedactedFields(t *testing.T) {
+	fields, answers := RedactedFields(nil)
+	require.Equal(t, PIIFields, fields)
+	require.False(t, answers)
+
+	fields, answers = RedactedFields([]string{"RespondentID", "answers"})
+	require.Equal(t, []string{"respondent_id", "answer", "selected_answer_option"}, fields)
+	require.True(t, answers)
+
+	require.NoError(t, ValidateRedactFields([]string{"respondent_id", "answers"}))
+	require.Error(t, ValidateRedactFields([]string{"shoe_size"}))
+}
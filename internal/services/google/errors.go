@@ -0,0 +1,58 @@
+package google
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// IsPermanentError reports whether err from the Sheets API will keep failing
+// no matter how often the request is retried, e.g. the spreadsheet no longer
+// exists, the user revoked the access of a token passed along with the write
+// or the sheet lacks the columns a delete needs to find its rows. A revoked
+// stored token is not, see ErrReauthorizationRequired.
+func IsPermanentError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// the message or the sheet is missing what the write needs
+	if errors.Is(err, ErrMissingAnswerID) || errors.Is(err, ErrNoRowReference) || errors.Is(err, ErrSheetNotFound) ||
+		errors.Is(err, ErrTokenNotFound) || errors.Is(err, ErrTokenNotOwned) || errors.Is(err, ErrSubjectNotAllowed) || errors.Is(err, ErrUnknownTokenKey) ||
+		errors.Is(err, ErrNoTokenStore) {
+		return true
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return isInvalidGrant(err)
+	}
+
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	for _, item := range apiErr.Errors {
+		if strings.Contains(item.Reason, "RateLimitExceeded") || item.Reason == "rateLimitExceeded" {
+			return false
+		}
+	}
+
+	switch apiErr.Code {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// isInvalidGrant reports whether err is Google refusing a refresh token, e.g.
+// because the user revoked the connector's access.
+func isInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && strings.Contains(string(retrieveErr.Body), "invalid_grant")
+}
@@ -0,0 +1,381 @@
+// Package googletest runs a local stand-in for the Google endpoints the
+// connector talks to: the OAuth consent, token and revocation endpoints and
+// the part of the Sheets API v4 REST interface it uses, backed by a
+// sheetstest.Fake. Point a google.GoogleClient at it with ClientOptions.
+package googletest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google/sheetstest"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+// the paths of the endpoints, under the server's URL
+const (
+	AuthPath   = "/o/oauth2/auth"
+	TokenPath  = "/token"
+	RevokePath = "/revoke"
+	sheetsPath = "/v4/spreadsheets"
+)
+
+// Server is the stand-in. Logins are consented to right away, and API calls
+// need an access token issued by it.
+type Server struct {
+	*httptest.Server
+	// Sheets holds the spreadsheets created through the server.
+	Sheets *sheetstest.Fake
+
+	ClientID     string
+	ClientSecret string
+
+	mu sync.Mutex
+	// authorization code -> its login
+	codes map[string]*grant
+	// access and refresh tokens -> their grant
+	access  map[string]*grant
+	refresh map[string]*grant
+}
+
+type grant struct {
+	redirectURI string
+	challenge   string
+	revoked     bool
+}
+
+// NewServer starts a server for the OAuth client with the given credentials.
+// Close it when done.
+func NewServer(clientID string, clientSecret string) *Server {
+	s := &Server{
+		Sheets:       sheetstest.New(),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		codes:        map[string]*grant{},
+		access:       map[string]*grant{},
+		refresh:      map[string]*grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(AuthPath, s.authorize)
+	mux.HandleFunc(TokenPath, s.token)
+	mux.HandleFunc(RevokePath, s.revoke)
+	mux.HandleFunc(sheetsPath, s.sheets)
+	mux.HandleFunc(sheetsPath+"/", s.sheets)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Endpoint is the server's OAuth endpoint.
+func (s *Server) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:   s.URL + AuthPath,
+		TokenURL:  s.URL + TokenPath,
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+}
+
+// ClientOptions point a GoogleClient at the server.
+func (s *Server) ClientOptions() []google.ClientOption {
+	return []google.ClientOption{
+		google.WithOAuthEndpoint(s.Endpoint()),
+		google.WithRevocationURL(s.URL + RevokePath),
+		google.WithSheetsEndpoint(s.URL + "/"),
+	}
+}
+
+// Revoked reports whether the grant a token belongs to was revoked.
+func (s *Server) Revoked(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g, ok := s.refresh[token]; ok {
+		return g.revoked
+	}
+	if g, ok := s.access[token]; ok {
+		return g.revoked
+	}
+	return false
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// oauthError answers like Google's OAuth endpoints do.
+func oauthError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// authorize consents to the login right away and sends the user back to the
+// client with a code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "The OAuth client was not found.")
+		return
+	}
+	if query.Get("response_type") != "code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_response_type", "Only the code flow is supported.")
+		return
+	}
+	if query.Get("code_challenge") != "" && query.Get("code_challenge_method") != "S256" {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "Only S256 code challenges are supported.")
+		return
+	}
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		oauthError(w, http.StatusBadRequest, "redirect_uri_mismatch", "Invalid redirect_uri.")
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = &grant{redirectURI: query.Get("redirect_uri"), challenge: query.Get("code_challenge")}
+	s.mu.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", query.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != s.ClientID || r.FormValue("client_secret") != s.ClientSecret {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "Unauthorized")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		g, ok := s.codes[r.FormValue("code")]
+		if !ok {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "Malformed auth code.")
+			return
+		}
+		// codes are good for one exchange
+		delete(s.codes, r.FormValue("code"))
+
+		if g.redirectURI != r.FormValue("redirect_uri") {
+			oauthError(w, http.StatusBadRequest, "redirect_uri_mismatch", "Bad Request")
+			return
+		}
+		if g.challenge != "" {
+			sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+				oauthError(w, http.StatusBadRequest, "invalid_grant", "Invalid code verifier.")
+				return
+			}
+		}
+
+		refresh := randomString()
+		s.refresh[refresh] = g
+		s.issue(w, g, refresh)
+
+	case "refresh_token":
+		g, ok := s.refresh[r.FormValue("refresh_token")]
+		if !ok || g.revoked {
+			oauthError(w, http.StatusBadRequest, "invalid_grant", "Token has been expired or revoked.")
+			return
+		}
+		s.issue(w, g, "")
+
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "Invalid grant_type.")
+	}
+}
+
+// issue answers with a new access token of g, and refresh when given.
+func (s *Server) issue(w http.ResponseWriter, g *grant, refresh string) {
+	access := randomString()
+	s.access[access] = g
+
+	body := map[string]interface{}{
+		"access_token": access,
+		"token_type":   "Bearer",
+		"expires_in":   3599,
+		"scope":        "https://www.googleapis.com/auth/spreadsheets",
+	}
+	if refresh != "" {
+		body["refresh_token"] = refresh
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.refresh[token]
+	if !ok {
+		g, ok = s.access[token]
+	}
+	if !ok || g.revoked {
+		oauthError(w, http.StatusBadRequest, "invalid_token", "Token expired or revoked")
+		return
+	}
+
+	g.revoked = true
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorized reports whether r carries a live access token.
+func (s *Server) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.access[token]
+	return ok && !g.revoked
+}
+
+// apiError answers like the Sheets API does, so that the client returns a
+// *googleapi.Error.
+func apiError(w http.ResponseWriter, err error) {
+	status, message := http.StatusInternalServerError, err.Error()
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		status, message = apiErr.Code, apiErr.Message
+	}
+
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": message,
+			"status":  strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_")),
+		},
+	})
+}
+
+func decode(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid JSON payload received. %v", err)}
+	}
+	return nil
+}
+
+// sheets routes the REST calls of the Sheets API to the fake. Ranges are
+// escaped in paths, so the methods after colons are told apart from them.
+func (s *Server) sheets(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		apiError(w, &googleapi.Error{Code: http.StatusUnauthorized, Message: "Request had invalid authentication credentials."})
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.EscapedPath(), sheetsPath), "/")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i], _ = url.PathUnescape(segment)
+	}
+
+	id, method := segments[0], ""
+	if i := strings.LastIndex(id, ":"); i >= 0 {
+		id, method = id[:i], id[i+1:]
+	}
+
+	var (
+		resp interface{}
+		err  error
+	)
+
+	switch {
+	case path == "" && r.Method == http.MethodPost:
+		req := &sheets.Spreadsheet{}
+		if err = decode(r, req); err == nil {
+			resp, err = s.Sheets.CreateSpreadsheet(ctx, req)
+		}
+
+	case len(segments) == 1 && method == "" && r.Method == http.MethodGet:
+		resp, err = s.Sheets.GetSpreadsheet(ctx, id, query["ranges"], query.Get("includeGridData") == "true")
+
+	case len(segments) == 1 && method == "batchUpdate" && r.Method == http.MethodPost:
+		req := &sheets.BatchUpdateSpreadsheetRequest{}
+		if err = decode(r, req); err == nil {
+			resp, err = s.Sheets.BatchUpdate(ctx, id, req)
+		}
+
+	case len(segments) == 2 && segments[1] == "values:batchGet" && r.Method == http.MethodGet:
+		resp, err = s.Sheets.BatchGetValues(ctx, id, query["ranges"], query.Get("majorDimension"))
+
+	case len(segments) == 2 && segments[1] == "values:batchUpdate" && r.Method == http.MethodPost:
+		req := &sheets.BatchUpdateValuesRequest{}
+		if err = decode(r, req); err == nil {
+			resp, err = s.Sheets.BatchUpdateValues(ctx, id, req)
+		}
+
+	case len(segments) == 3 && segments[1] == "values":
+		resp, err = s.values(r, id, segments[2])
+
+	default:
+		err = &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("googletest: no route for %s %s", r.Method, r.URL.Path)}
+	}
+
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// values serves the calls on a single range of values.
+func (s *Server) values(r *http.Request, id string, cellRange string) (interface{}, error) {
+	ctx := r.Context()
+
+	switch {
+	case strings.HasSuffix(cellRange, ":append") && r.Method == http.MethodPost:
+		req := &sheets.ValueRange{}
+		if err := decode(r, req); err != nil {
+			return nil, err
+		}
+		return s.Sheets.AppendValues(ctx, id, strings.TrimSuffix(cellRange, ":append"), req)
+
+	case r.Method == http.MethodPut:
+		req := &sheets.ValueRange{}
+		if err := decode(r, req); err != nil {
+			return nil, err
+		}
+		return s.Sheets.UpdateValues(ctx, id, cellRange, req)
+
+	case r.Method == http.MethodGet:
+		return s.Sheets.GetValues(ctx, id, cellRange)
+
+	default:
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("googletest: no route for %s %s", r.Method, r.URL.Path)}
+	}
+}
@@ -0,0 +1,266 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// how long a row index is trusted before the key columns are read again
+const rowIndexTTL = 5 * time.Minute
+
+type indexKey struct {
+	sheetKey
+	// the sheet columns the index is built from
+	columns string
+}
+
+type cachedIndex struct {
+	rows    map[string]int
+	expires time.Time
+}
+
+// rowIndexCache maps the key of a row, e.g. an answer ID, to its row number
+// so rows can be updated in place without scanning the sheet every time. It
+// is shared by every sheets client of a GoogleClient.
+type rowIndexCache struct {
+	mu      sync.Mutex
+	indexes map[indexKey]*cachedIndex
+}
+
+func newRowIndexCache() *rowIndexCache {
+	return &rowIndexCache{indexes: make(map[indexKey]*cachedIndex)}
+}
+
+func (c *rowIndexCache) get(key indexKey) (map[string]int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.indexes[key]
+	if !ok || time.Now().After(cached.expires) {
+		return nil, false
+	}
+	return cached.rows, true
+}
+
+func (c *rowIndexCache) put(key indexKey, rows map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexes[key] = &cachedIndex{rows: rows, expires: time.Now().Add(rowIndexTTL)}
+}
+
+// forget drops every index of a sheet, e.g. after rows were removed from it.
+func (c *rowIndexCache) forget(key sheetKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.indexes {
+		if k.sheetKey == key {
+			delete(c.indexes, k)
+		}
+	}
+}
+
+// sheetKey identifies a sheet of a spreadsheet by its title.
+type sheetKey struct {
+	spreadSheetID string
+	sheet         string
+}
+
+// sheetLocks serialises writes to the same sheet within this process, so the
+// cached layout and row index of a sheet are never updated concurrently.
+type sheetLocks struct {
+	mu    sync.Mutex
+	locks map[sheetKey]*sync.Mutex
+}
+
+func newSheetLocks() *sheetLocks {
+	return &sheetLocks{locks: make(map[sheetKey]*sync.Mutex)}
+}
+
+func (l *sheetLocks) lock(key sheetKey) func() {
+	l.mu.Lock()
+	m, ok := l.locks[key]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[key] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// rowIndex returns the row number of every data row of sheet by the key made
+// up of the values in the given columns (0-based). Rows are numbered from 1,
+// so the first data row is 2.
+func (gs *GoogleSheetClient) rowIndex(spreadSheetID string, sheet string, columns ...int) (map[string]int, error) {
+	key := indexKey{sheetKey: sheetKey{spreadSheetID: spreadSheetID, sheet: sheet}, columns: fmt.Sprint(columns)}
+	if rows, ok := gs.indexes.get(key); ok {
+		return rows, nil
+	}
+
+	values, length, err := gs.columnValues(spreadSheetID, sheet, columns...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make(map[string]int, length)
+	for i := 0; i < length; i++ {
+		parts := make([]interface{}, len(columns))
+		for c := range columns {
+			if i < len(values[c]) {
+				parts[c] = values[c][i]
+			} else {
+				parts[c] = ""
+			}
+		}
+
+		if k := rowKey(parts...); k != "" {
+			rows[k] = i + 2
+		}
+	}
+
+	gs.indexes.put(key, rows)
+	return rows, nil
+}
+
+// columnValues reads the data rows of the given sheet columns (0-based). It
+// returns the values by column and the number of rows read, the columns of
+// which are shorter when their trailing cells are empty.
+func (gs *GoogleSheetClient) columnValues(spreadSheetID string, sheet string, columns ...int) ([][]interface{}, int, error) {
+	ranges := make([]string, len(columns))
+	for i, column := range columns {
+		ranges[i] = fmt.Sprintf("%s!R2C%d:C%d", quoteSheet(sheet), column+1, column+1)
+	}
+
+	resp, err := gs.api.BatchGetValues(context.Background(), spreadSheetID, ranges, "COLUMNS")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	values := make([][]interface{}, len(columns))
+	length := 0
+	for i, valueRange := range resp.ValueRanges {
+		if len(valueRange.Values) > 0 {
+			values[i] = valueRange.Values[0]
+		}
+		if len(values[i]) > length {
+			length = len(values[i])
+		}
+	}
+
+	return values, length, nil
+}
+
+// rowKey joins the values identifying a row. Rows missing any part of their
+// key can't be looked up and get the empty key.
+func rowKey(parts ...interface{}) string {
+	keys := make([]string, len(parts))
+	for i, part := range parts {
+		keys[i] = fmt.Sprint(part)
+		if part == nil || keys[i] == "" {
+			return ""
+		}
+	}
+	return strings.Join(keys, "\x00")
+}
+
+// firstRow returns the first row number of a range in A1 notation such as
+// 'Sheet1'!A5:K7.
+func firstRow(a1 string) (int, error) {
+	cells := a1[strings.LastIndex(a1, "!")+1:]
+	start := strings.Split(cells, ":")[0]
+	digits := strings.TrimLeftFunc(start, unicode.IsLetter)
+
+	row, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, fmt.Errorf("no row number in range %q", a1)
+	}
+	return row, nil
+}
+
+// keyedRow holds the cells of the row identified by key, by sheet column.
+type keyedRow struct {
+	key   string
+	cells map[int]interface{}
+}
+
+// putRows writes the cells of the rows found in index in place and appends
+// the others, recording the row numbers they were appended at. Rows without
+// a key are always appended. width is the number of columns of the sheet.
+func (gs *GoogleSheetClient) putRows(target Target, width int, index map[string]int, rows []*keyedRow) error {
+	updates := []*sheets.ValueRange{}
+	appends := []*keyedRow{}
+
+	for _, row := range rows {
+		number, ok := index[row.key]
+		if !ok || row.key == "" {
+			appends = append(appends, row)
+			continue
+		}
+
+		for position, value := range row.cells {
+			updates = append(updates, &sheets.ValueRange{
+				Range:  fmt.Sprintf("%s!R%dC%d", quoteSheet(target.Sheet), number, position+1),
+				Values: [][]interface{}{{value}},
+			})
+		}
+	}
+
+	if len(updates) > 0 {
+		req := &sheets.BatchUpdateValuesRequest{
+			ValueInputOption: VALUE_INPUT_OPTION,
+			Data:             updates,
+		}
+
+		if _, err := gs.api.BatchUpdateValues(context.Background(), target.SpreadSheetID, req); err != nil {
+			gs.logger.Error("failed to update rows :: stacktrace ::", err)
+			return err
+		}
+	}
+
+	if len(appends) == 0 {
+		return nil
+	}
+
+	values := [][]interface{}{}
+	for _, row := range appends {
+		cells := make([]interface{}, width)
+		for i := range cells {
+			cells[i] = ""
+		}
+		for position, value := range row.cells {
+			cells[position] = value
+		}
+		values = append(values, cells)
+	}
+
+	resp, err := gs.appendValues(target.SpreadSheetID, quoteSheet(target.Sheet), &sheets.ValueRange{Values: values})
+	if err != nil {
+		return err
+	}
+
+	gs.catalog.grow(target.SpreadSheetID, target.Sheet, len(appends))
+
+	start, err := firstRow(resp.Updates.UpdatedRange)
+	if err != nil {
+		// the rows were written, they are found on the next index rebuild
+		gs.indexes.forget(sheetKey{spreadSheetID: target.SpreadSheetID, sheet: target.Sheet})
+		return nil
+	}
+
+	for i, row := range appends {
+		if row.key != "" {
+			index[row.key] = start + i
+		}
+	}
+
+	return nil
+}
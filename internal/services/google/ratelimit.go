@@ -0,0 +1,339 @@
+package google
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+)
+
+// RateLimits configures the client-side limits applied to Sheets API calls.
+// Google enforces its quotas per project and per user of a project, so both
+// get their own token bucket.
+type RateLimits struct {
+	ProjectRPS   float64
+	ProjectBurst int
+	UserRPS      float64
+	UserBurst    int
+	// MaxRetries is how many times a call that was throttled or failed with a
+	// 5xx is retried before its error is returned.
+	MaxRetries int
+	MinBackoff time.Duration
+	// MaxBackoff also caps how long a Retry-After header makes a call wait.
+	MaxBackoff time.Duration
+}
+
+// Validate checks that every limited rate has a burst to spend, a bucket
+// without one never hands out a token and blocks its calls forever.
+func (l RateLimits) Validate() error {
+	if l.ProjectRPS > 0 && l.ProjectBurst <= 0 {
+		return errors.New("a project rate limit needs a burst of at least 1")
+	}
+	if l.UserRPS > 0 && l.UserBurst <= 0 {
+		return errors.New("a user rate limit needs a burst of at least 1")
+	}
+	return nil
+}
+
+// DefaultRateLimits stays inside the default Sheets quotas of 300 requests
+// per minute per project and 60 per minute per user.
+var DefaultRateLimits = RateLimits{
+	ProjectRPS:   5,
+	ProjectBurst: 10,
+	UserRPS:      1,
+	UserBurst:    5,
+	MaxRetries:   5,
+	MinBackoff:   time.Second,
+	MaxBackoff:   32 * time.Second,
+}
+
+// how long a user's bucket is kept after its last call, by which time it
+// has refilled and a new one is the same
+const userLimiterIdle = 10 * time.Minute
+
+// RateLimitMetrics receives the calls that had to wait or be retried.
+type RateLimitMetrics interface {
+	SheetsThrottled(scope string)
+	SheetsRetried(statusCode int)
+}
+
+type noopRateLimitMetrics struct{}
+
+func (noopRateLimitMetrics) SheetsThrottled(string) {}
+func (noopRateLimitMetrics) SheetsRetried(int)      {}
+
+// rateLimiter hands out the token buckets shared by every sheets client of a
+// GoogleClient.
+type rateLimiter struct {
+	mu       sync.Mutex
+	limits   RateLimits
+	project  *rate.Limiter
+	users    map[string]*userLimiter
+	swept    time.Time
+	metrics  RateLimitMetrics
+	randMu   sync.Mutex
+	random   *rand.Rand
+	sleepFor func(ctx context.Context, d time.Duration) error
+	now      func() time.Time
+}
+
+// userLimiter is the bucket of a user and when it was last used.
+type userLimiter struct {
+	*rate.Limiter
+	used time.Time
+}
+
+func newRateLimiter(limits RateLimits, metrics RateLimitMetrics) *rateLimiter {
+	return &rateLimiter{
+		limits:   limits,
+		project:  rate.NewLimiter(perSecond(limits.ProjectRPS), limits.ProjectBurst),
+		users:    make(map[string]*userLimiter),
+		swept:    time.Now(),
+		metrics:  metrics,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		sleepFor: sleep,
+		now:      time.Now,
+	}
+}
+
+// user returns the bucket of the user, dropping those of users idle for
+// userLimiterIdle every so often.
+func (r *rateLimiter) user(key string) *userLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.swept) >= userLimiterIdle {
+		for k, l := range r.users {
+			if now.Sub(l.used) >= userLimiterIdle {
+				delete(r.users, k)
+			}
+		}
+		r.swept = now
+	}
+
+	l, ok := r.users[key]
+	if !ok {
+		l = &userLimiter{Limiter: rate.NewLimiter(perSecond(r.limits.UserRPS), r.limits.UserBurst)}
+		r.users[key] = l
+	}
+	l.used = now
+	return l
+}
+
+// touch keeps the bucket of a user from being dropped while it is in use.
+func (r *rateLimiter) touch(l *userLimiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l.used = r.now()
+}
+
+// perSecond treats a missing rate as no limit rather than blocking every call.
+func perSecond(rps float64) rate.Limit {
+	if rps <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(rps)
+}
+
+// transport wraps base so every request waits for the project and user
+// buckets and is retried with backoff when Google throttles it.
+func (r *rateLimiter) transport(base http.RoundTripper, principal string) http.RoundTripper {
+	return &throttledTransport{
+		base:    base,
+		limiter: r,
+		user:    r.user(principal),
+	}
+}
+
+// userKey identifies the user a token belongs to. Refresh tokens outlive the
+// access tokens they mint, so they are preferred.
+func userKey(token *oauth2.Token) string {
+	if token == nil {
+		return ""
+	}
+
+	secret := token.RefreshToken
+	if secret == "" {
+		secret = token.AccessToken
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// backoff returns the exponential backoff for attempt with jitter, so clients
+// throttled together don't all retry at the same moment.
+func (r *rateLimiter) backoff(attempt int) time.Duration {
+	d := r.limits.MinBackoff << attempt
+	if d <= 0 || d > r.limits.MaxBackoff {
+		d = r.limits.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	r.randMu.Lock()
+	defer r.randMu.Unlock()
+	return d/2 + time.Duration(r.random.Int63n(int64(d/2)+1))
+}
+
+type throttledTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+	user    *userLimiter
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(ctx, t.limiter.project, "project"); err != nil {
+			return nil, err
+		}
+		t.limiter.touch(t.user)
+		if err := t.wait(ctx, t.user.Limiter, "user"); err != nil {
+			return nil, err
+		}
+
+		r := req
+		if attempt > 0 {
+			var err error
+			if r, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		if err != nil || !retryable(req, resp.StatusCode) || attempt >= t.limiter.limits.MaxRetries {
+			return resp, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.limiter.metrics.SheetsThrottled("remote")
+		}
+		t.limiter.metrics.SheetsRetried(resp.StatusCode)
+
+		delay := t.limiter.backoff(attempt)
+		if after, ok := retryAfter(resp); ok && after > delay {
+			delay = after
+		}
+		// a server asking for an hour would hold up every answer behind it
+		if max := t.limiter.limits.MaxBackoff; max > 0 && delay > max {
+			delay = max
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if err := t.limiter.sleepFor(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *throttledTransport) wait(ctx context.Context, l *rate.Limiter, scope string) error {
+	reservation := l.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	t.limiter.metrics.SheetsThrottled(scope)
+	if err := t.limiter.sleepFor(ctx, delay); err != nil {
+		reservation.Cancel()
+		return err
+	}
+	return nil
+}
+
+// rewind returns a copy of req with a fresh body so it can be sent again.
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.Body == nil || req.GetBody == nil {
+		return r, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
+	return r, nil
+}
+
+// retryable reports whether req can be sent again after failing with
+// statusCode. Calls that aren't idempotent, like appends, may have been
+// carried out despite a 500, 502 or 504 and are only retried when Google
+// turned them away before doing anything.
+func retryable(req *http.Request, statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(req)
+	default:
+		return false
+	}
+}
+
+// idempotent reports whether sending req twice has the same effect as sending
+// it once. Sheets API calls are POSTs unless they only read or replace values,
+// or clear them.
+func idempotent(req *http.Request) bool {
+	if req.Method != http.MethodPost {
+		return true
+	}
+
+	for _, call := range []string{"/values:batchGet", "/values:batchUpdate", "/values:batchClear", ":clear"} {
+		if strings.HasSuffix(req.URL.Path, call) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter reads the Retry-After header, given either in seconds or as a date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at), true
+	}
+
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,193 @@
+package google
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+type countingMetrics struct {
+	throttled map[string]int
+	retried   map[int]int
+}
+
+func (m *countingMetrics) SheetsThrottled(scope string) { m.throttled[scope]++ }
+func (m *countingMetrics) SheetsRetried(statusCode int) { m.retried[statusCode]++ }
+
+func TestThrottledTransportRetries(t *testing.T) {
+	calls := 0
+	bodies := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	metrics := &countingMetrics{throttled: map[string]int{}, retried: map[int]int{}}
+	limiter := newRateLimiter(DefaultRateLimits, metrics)
+
+	slept := []time.Duration{}
+	limiter.sleepFor = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	client := &http.Client{Transport: limiter.transport(http.DefaultTransport, userKey(&oauth2.Token{RefreshToken: "refresh"}))}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"values":[["a"]]}`))
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 3, calls)
+	require.Equal(t, []string{`{"values":[["a"]]}`, `{"values":[["a"]]}`, `{"values":[["a"]]}`}, bodies)
+
+	// Retry-After wins over a shorter backoff
+	require.Equal(t, 7*time.Second, slept[0])
+	require.Equal(t, 1, metrics.throttled["remote"])
+	require.Equal(t, 1, metrics.retried[http.StatusTooManyRequests])
+	require.Equal(t, 1, metrics.retried[http.StatusServiceUnavailable])
+}
+
+func TestThrottledTransportGivesUp(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	limits := DefaultRateLimits
+	limits.MaxRetries = 2
+
+	limiter := newRateLimiter(limits, noopRateLimitMetrics{})
+	limiter.sleepFor = func(ctx context.Context, d time.Duration) error { return nil }
+
+	client := &http.Client{Transport: limiter.transport(http.DefaultTransport, "")}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, 3, calls)
+}
+
+func TestThrottledTransportDoesNotRepeatAppends(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	limiter := newRateLimiter(DefaultRateLimits, noopRateLimitMetrics{})
+	limiter.sleepFor = func(ctx context.Context, d time.Duration) error { return nil }
+
+	client := &http.Client{Transport: limiter.transport(http.DefaultTransport, "")}
+
+	// the append may have landed, sending it again could add its rows twice
+	resp, err := client.Post(server.URL+"/v4/spreadsheets/sheet/values/A1:append", "application/json", strings.NewReader(`{"values":[["a"]]}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	require.Equal(t, 1, calls)
+
+	// writing the same values to the same cells twice is harmless
+	resp, err = client.Post(server.URL+"/v4/spreadsheets/sheet/values:batchUpdate", "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 1+1+DefaultRateLimits.MaxRetries, calls)
+}
+
+func TestThrottledTransportCapsRetryAfter(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	limiter := newRateLimiter(DefaultRateLimits, noopRateLimitMetrics{})
+	slept := []time.Duration{}
+	limiter.sleepFor = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	client := &http.Client{Transport: limiter.transport(http.DefaultTransport, "")}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []time.Duration{DefaultRateLimits.MaxBackoff}, slept)
+}
+
+func TestRateLimitsNeedABurst(t *testing.T) {
+	require.NoError(t, DefaultRateLimits.Validate())
+
+	limits := DefaultRateLimits
+	limits.UserBurst = 0
+	require.Error(t, limits.Validate())
+
+	// without a rate there is no bucket to fill
+	limits.UserRPS = 0
+	require.NoError(t, limits.Validate())
+}
+
+func TestBackoffStaysWithinBounds(t *testing.T) {
+	limiter := newRateLimiter(DefaultRateLimits, noopRateLimitMetrics{})
+
+	for attempt := 0; attempt < 10; attempt++ {
+		d := limiter.backoff(attempt)
+		require.Greater(t, d, time.Duration(0))
+		require.LessOrEqual(t, d, DefaultRateLimits.MaxBackoff)
+	}
+}
+
+func TestIdleUserLimitersAreDropped(t *testing.T) {
+	limiter := newRateLimiter(DefaultRateLimits, noopRateLimitMetrics{})
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	idle := limiter.user("idle")
+	busy := limiter.user("busy")
+
+	now = now.Add(userLimiterIdle / 2)
+	limiter.touch(busy)
+
+	now = now.Add(userLimiterIdle * 3 / 4)
+	require.Same(t, busy, limiter.user("busy"))
+	require.NotSame(t, idle, limiter.user("idle"))
+	require.Len(t, limiter.users, 2)
+
+	now = now.Add(2 * userLimiterIdle)
+	limiter.user("other")
+	require.Len(t, limiter.users, 1)
+}
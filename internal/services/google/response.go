@@ -0,0 +1,156 @@
+package google
+
+import (
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+)
+
+// ResponseKeyFields identify a row in response mode, in the order their
+// values make up its key.
+var ResponseKeyFields = []string{"respondent_id", "form_id"}
+
+// ResponseColumnMapping returns the respondent columns of a sheet in response
+// mode, which come before the question columns. Columns describing a single
+// answer make little sense there, so without a mapping only the respondent
+// and form details are written. The respondent and form IDs identify a row
+// and are added when the mapping leaves them out.
+func ResponseColumnMapping(columns []model.ColumnMapping) []model.ColumnMapping {
+	if len(columns) == 0 {
+		columns = []model.ColumnMapping{
+			{Field: "respondent_id"},
+			{Field: "form_id"},
+			{Field: "respondent_email"},
+			{Field: "respondent_phone_number"},
+			{Field: "org_id"},
+			{Field: "form_start_date"},
+			{Field: "form_end_date"},
+			{Field: "answered_on"},
+		}
+	}
+
+	return withKeyColumns(columns, ResponseKeyFields...)
+}
+
+// withKeyColumns puts a column for each of the key fields the mapping leaves
+// out in front of it.
+func withKeyColumns(columns []model.ColumnMapping, fields ...string) []model.ColumnMapping {
+	keys := []model.ColumnMapping{}
+	for _, name := range fields {
+		key, _ := lookupField(name)
+
+		mapped := false
+		for _, c := range columns {
+			if field, ok := lookupField(c.Field); ok && field.index == key.index {
+				mapped = true
+				break
+			}
+		}
+
+		if !mapped {
+			keys = append(keys, model.ColumnMapping{Field: name})
+		}
+	}
+
+	return append(keys, columns...)
+}
+
+// AnswerValue is what goes into a question's column.
+func AnswerValue(data *model.QuestionnarieData) interface{} {
+	if data.Answer != nil {
+		return *data.Answer
+	}
+	if data.SelectedAnswerOptions != nil {
+		return *data.SelectedAnswerOptions
+	}
+	return ""
+}
+
+// writeResponses merges answers into one row per respondent and form. Every
+// question gets a column headed by its title, added the first time it is
+// answered. Rows that already exist only have the cells of the new answers
+// and the respondent columns rewritten.
+func (gs *GoogleSheetClient) writeResponses(target Target, data []*model.QuestionnarieData) error {
+
+	for _, d := range data {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+	}
+
+	layout, err := gs.sheetLayout(target.SpreadSheetID, target.Sheet, target.columns())
+	if err != nil {
+		return err
+	}
+
+	if layout, err = gs.addQuestionColumns(target, layout, data); err != nil {
+		return err
+	}
+
+	keyColumns := make([]int, len(ResponseKeyFields))
+	for i, name := range ResponseKeyFields {
+		keyColumns[i], _ = layout.field(name)
+	}
+
+	index, err := gs.rowIndex(target.SpreadSheetID, target.Sheet, keyColumns...)
+	if err != nil {
+		return err
+	}
+
+	rows := []*keyedRow{}
+	byKey := map[string]*keyedRow{}
+
+	for _, d := range data {
+		cells := layout.cells(d)
+		key := layout.key(cells, keyColumns)
+
+		row, ok := byKey[key]
+		if !ok || key == "" {
+			row = &keyedRow{key: key, cells: map[int]interface{}{}}
+			byKey[key] = row
+			rows = append(rows, row)
+		}
+
+		// later answers carry the latest respondent details, but an answer
+		// missing one must not blank what an earlier answer wrote
+		for position, value := range cells {
+			if value != "" {
+				row.cells[position] = value
+			}
+		}
+
+		position, _ := layout.extra(*d.QuestionTitle)
+		row.cells[position] = AnswerValue(d)
+	}
+
+	return gs.putRows(target, len(layout.headers), index, rows)
+}
+
+// addQuestionColumns adds a header for every question title that has no
+// column yet and returns the extended layout.
+func (gs *GoogleSheetClient) addQuestionColumns(target Target, layout *sheetLayout, data []*model.QuestionnarieData) (*sheetLayout, error) {
+	titles := []string{}
+	seen := map[string]bool{}
+
+	for _, d := range data {
+		title := *d.QuestionTitle
+		if _, ok := layout.extra(title); ok || seen[normalizeHeader(title)] {
+			continue
+		}
+		seen[normalizeHeader(title)] = true
+		titles = append(titles, title)
+	}
+
+	if len(titles) == 0 {
+		return layout, nil
+	}
+
+	extended := layout.clone()
+	start := len(extended.headers)
+	extended.headers = append(extended.headers, titles...)
+
+	if err := gs.writeHeaders(target.SpreadSheetID, target.Sheet, start, titles); err != nil {
+		return nil, err
+	}
+
+	gs.layouts.put(layoutKey{sheetKey: sheetKey{spreadSheetID: target.SpreadSheetID, sheet: target.Sheet}, mapping: mappingSignature(target.columns())}, extended)
+	return extended, nil
+}
@@ -0,0 +1,54 @@
+package google
+
+import (
+	"testing"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseColumnMappingKeepsKeyColumns(t *testing.T) {
+	columns := ResponseColumnMapping([]model.ColumnMapping{{Field: "respondent_email"}, {Field: "FormID", Header: "Form"}})
+
+	require.Equal(t, []model.ColumnMapping{
+		{Field: "respondent_id"},
+		{Field: "respondent_email"},
+		{Field: "FormID", Header: "Form"},
+	}, columns)
+}
+
+func TestSheetLayoutFindsQuestionColumns(t *testing.T) {
+	mapped, err := resolveColumns(ResponseColumnMapping([]model.ColumnMapping{{Field: "respondent_id"}, {Field: "form_id"}}))
+	require.NoError(t, err)
+
+	layout := newSheetLayout([]string{"RESPONDENTID", "How old are you?", "FORMID", "Form ID"}, mapped)
+
+	position, ok := layout.field("form_id")
+	require.True(t, ok)
+	require.Equal(t, 2, position)
+
+	// a question titled like a mapped field still gets its own column
+	position, ok = layout.extra("form id")
+	require.True(t, ok)
+	require.Equal(t, 3, position)
+
+	position, ok = layout.extra("how old are you?")
+	require.True(t, ok)
+	require.Equal(t, 1, position)
+
+	_, ok = layout.extra("RespondentID")
+	require.False(t, ok)
+}
+
+func TestRowKeyAndFirstRow(t *testing.T) {
+	require.Equal(t, "r1\x00f1", rowKey("r1", "f1"))
+	require.Empty(t, rowKey("r1", ""))
+	require.Empty(t, rowKey(nil, "f1"))
+
+	row, err := firstRow("'Sheet 1'!A12:K14")
+	require.NoError(t, err)
+	require.Equal(t, 12, row)
+
+	_, err = firstRow("'Sheet1'!A:K")
+	require.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// RevocationURL is Google's OAuth token revocation endpoint.
+const RevocationURL = "https://oauth2.googleapis.com/revoke"
+
+var ErrRevocationFailed = errors.New("failed to revoke token")
+
+// RevokeToken revokes token with Google, and with it every token of the grant
+// it belongs to. Tokens Google no longer knows count as revoked.
+func (g *GoogleClient) RevokeToken(ctx context.Context, token *oauth2.Token) error {
+	// revoking the refresh token also revokes the access tokens it minted
+	secret := token.RefreshToken
+	if secret == "" {
+		secret = token.AccessToken
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.revokeURL, strings.NewReader(url.Values{"token": {secret}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRevocationFailed, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "invalid_token") {
+		return nil
+	}
+	return fmt.Errorf("%w: %s: %s", ErrRevocationFailed, resp.Status, body)
+}
+
+// Disconnect revokes the token stored under tokenID for orgID and deletes
+// it.
+func (g *GoogleClient) Disconnect(ctx context.Context, orgID string, tokenID string) error {
+	if g.tokens == nil {
+		return ErrNoTokenStore
+	}
+
+	token, owner, err := g.tokens.load(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if !owner.Owns(orgID) {
+		return ErrTokenNotOwned
+	}
+
+	if err := g.RevokeToken(ctx, token); err != nil {
+		return err
+	}
+	return g.tokens.Delete(ctx, tokenID)
+}
@@ -0,0 +1,58 @@
+package google
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestDisconnectRevokesStoredToken(t *testing.T) {
+	ctx := context.Background()
+
+	revoked := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.FormValue("token")
+		revoked = append(revoked, token)
+
+		if token == "gone" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_token","error_description":"Token expired or revoked"}`))
+			return
+		}
+		if token == "broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}))
+	defer server.Close()
+
+	tokens, err := NewTokenStore(memoryTokens{}, []TokenKey{tokenKey("k1", 1)})
+	require.NoError(t, err)
+
+	g := NewGoogleClient("client", "secret", nil, "", nil, WithTokenStore(tokens))
+	g.revokeURL = server.URL
+
+	id, err := tokens.Create(ctx, TokenOwner{OrgID: "org"}, &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"})
+	require.NoError(t, err)
+
+	require.NoError(t, g.Disconnect(ctx, "org", id))
+	require.Equal(t, []string{"refresh"}, revoked)
+
+	_, err = tokens.Load(ctx, id)
+	require.ErrorIs(t, err, ErrTokenNotFound)
+
+	// already revoked by the user
+	require.NoError(t, g.RevokeToken(ctx, &oauth2.Token{AccessToken: "gone"}))
+
+	// kept to be revoked again
+	id, err = tokens.Create(ctx, TokenOwner{OrgID: "org"}, &oauth2.Token{RefreshToken: "broken"})
+	require.NoError(t, err)
+	require.ErrorIs(t, g.Disconnect(ctx, "org", id), ErrRevocationFailed)
+
+	_, err = tokens.Load(ctx, id)
+	require.NoError(t, err)
+}
@@ -0,0 +1,276 @@
+package google
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// DefaultRolloverCells leaves a tenth of Google's 10 million cell limit for
+// the writes in flight when a spreadsheet is found to be nearly full.
+const DefaultRolloverCells = 9_000_000
+
+// spreadsheet-level metadata linking a part to the one continuing it, and
+// marking a continuation that got the sheets of the part before it
+const (
+	nextPartMetadataKey = "google-sheets-connector.next-part"
+	copiedMetadataKey   = "google-sheets-connector.sheets-copied"
+)
+
+// Parts returns the ID of the spreadsheet followed by the IDs of the
+// spreadsheets continuing it once it filled up, in the order they were
+// created. The last part is the one written to.
+func (gs *GoogleSheetClient) Parts(spreadSheetID string) ([]string, error) {
+	parts := []string{}
+	seen := map[string]bool{}
+
+	for id := spreadSheetID; id != "" && !seen[id]; {
+		seen[id] = true
+		parts = append(parts, id)
+
+		info, err := gs.spreadsheetInfo(id, false)
+		if err != nil {
+			return nil, err
+		}
+		id = info.next
+	}
+
+	return parts, nil
+}
+
+// currentPart returns the part of the spreadsheet writes go to, creating a
+// continuation first when the last part has reached the rollover threshold.
+// A continuation whose sheets failed to be copied gets them first.
+func (gs *GoogleSheetClient) currentPart(spreadSheetID string) (string, error) {
+	// one rollover at a time, whichever sheet of the spreadsheet is written to
+	unlock := gs.locks.lock(sheetKey{spreadSheetID: spreadSheetID})
+	defer unlock()
+
+	parts, err := gs.Parts(spreadSheetID)
+	if err != nil {
+		return "", err
+	}
+
+	last := parts[len(parts)-1]
+	if len(parts) > 1 {
+		if err := gs.finishPart(parts[len(parts)-2], last); err != nil {
+			return "", err
+		}
+	}
+
+	if gs.rolloverCells <= 0 {
+		return last, nil
+	}
+
+	info, err := gs.spreadsheetInfo(last, false)
+	if err != nil || info.cells() < gs.rolloverCells {
+		return last, err
+	}
+
+	// make sure of the size, and that no other instance rolled over already
+	info, err = gs.spreadsheetInfo(last, true)
+	if err != nil {
+		return "", err
+	}
+	if info.next != "" {
+		if parts, err = gs.Parts(spreadSheetID); err != nil {
+			return "", err
+		}
+		return parts[len(parts)-1], nil
+	}
+	if info.cells() < gs.rolloverCells {
+		return last, nil
+	}
+
+	return gs.rollover(spreadSheetID, parts, info)
+}
+
+// rollover creates the next part of the spreadsheet as a continuation of the
+// last of parts and links it from there, before giving it the same sheets and
+// header rows. Should that fail, the linked part is finished by the next
+// write rather than created again, see finishPart.
+func (gs *GoogleSheetClient) rollover(spreadSheetID string, parts []string, info spreadsheetInfo) (string, error) {
+	last := parts[len(parts)-1]
+
+	first, err := gs.spreadsheetInfo(spreadSheetID, false)
+	if err != nil {
+		return "", err
+	}
+
+	created, err := gs.CreateSpreadSheet(fmt.Sprintf("%s (part %d)", first.title, len(parts)+1))
+	if err != nil {
+		gs.logger.Error("failed to create continuation spreadsheet :: stacktrace ::", err)
+		return "", err
+	}
+
+	req := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{partMetadata(nextPartMetadataKey, created.SpreadsheetId)},
+	}
+
+	if _, err := gs.api.BatchUpdate(context.Background(), last, req); err != nil {
+		gs.logger.Error(fmt.Sprintf("failed to link continuation spreadsheet %s, which is left unused :: stacktrace ::", created.SpreadsheetId), err)
+		return "", err
+	}
+
+	gs.catalog.forget(last)
+	gs.logger.Info(fmt.Sprintf("spreadsheet %s is nearly full with %d cells in part %s, continuing in %s", spreadSheetID, info.cells(), last, created.SpreadsheetId))
+
+	if gs.onRollover != nil {
+		gs.onRollover(spreadSheetID, append(append([]string{}, parts...), created.SpreadsheetId))
+	}
+
+	if err := gs.copySheets(last, info, created); err != nil {
+		gs.logger.Error("failed to copy sheets to continuation spreadsheet :: stacktrace ::", err)
+		return "", err
+	}
+	gs.catalog.forget(created.SpreadsheetId)
+
+	return created.SpreadsheetId, nil
+}
+
+// finishPart copies the sheets of the part before to part unless that was
+// done already.
+func (gs *GoogleSheetClient) finishPart(before string, part string) error {
+	info, err := gs.spreadsheetInfo(part, false)
+	if err != nil || info.copied {
+		return err
+	}
+
+	beforeInfo, err := gs.spreadsheetInfo(before, false)
+	if err != nil {
+		return err
+	}
+
+	to, err := gs.api.GetSpreadsheet(context.Background(), part, nil, false)
+	if err != nil {
+		return err
+	}
+
+	if err := gs.copySheets(before, beforeInfo, to); err != nil {
+		gs.logger.Error("failed to copy sheets to continuation spreadsheet :: stacktrace ::", err)
+		return err
+	}
+
+	gs.catalog.forget(part)
+	return nil
+}
+
+func partMetadata(key string, value string) *sheets.Request {
+	return &sheets.Request{
+		CreateDeveloperMetadata: &sheets.CreateDeveloperMetadataRequest{
+			DeveloperMetadata: &sheets.DeveloperMetadata{
+				MetadataKey:   key,
+				MetadataValue: value,
+				Location:      &sheets.DeveloperMetadataLocation{Spreadsheet: true},
+				Visibility:    "DOCUMENT",
+			},
+		},
+	}
+}
+
+// copySheets sets up the sheets of from in the new spreadsheet, each with the
+// header row, header formatting and frozen rows and columns of the original,
+// and marks it copied. Sheets it already has are left as they are, so a copy
+// that failed half way can be run again.
+func (gs *GoogleSheetClient) copySheets(from string, info spreadsheetInfo, to *sheets.Spreadsheet) error {
+	copied := partMetadata(copiedMetadataKey, from)
+
+	existing := map[string]bool{}
+	for _, sheet := range to.Sheets {
+		if sheet.Properties != nil {
+			existing[sheet.Properties.Title] = true
+		}
+	}
+
+	missing := []*sheets.SheetProperties{}
+	wanted := map[string]bool{}
+	for _, p := range info.sheets {
+		wanted[p.Title] = true
+		if !existing[p.Title] {
+			missing = append(missing, p)
+		}
+	}
+
+	if len(missing) == 0 {
+		_, err := gs.api.BatchUpdate(context.Background(), to.SpreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+			Requests: []*sheets.Request{copied},
+		})
+		return err
+	}
+
+	ranges := make([]string, len(missing))
+	for i, p := range missing {
+		ranges[i] = fmt.Sprintf("%s!1:1", quoteSheet(p.Title))
+	}
+
+	source, err := gs.api.GetSpreadsheet(context.Background(), from, ranges, true)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string][]*sheets.RowData{}
+	for _, sheet := range source.Sheets {
+		if sheet.Properties != nil && len(sheet.Data) > 0 {
+			headers[sheet.Properties.Title] = sheet.Data[0].RowData
+		}
+	}
+
+	// the new spreadsheet comes with one sheet, which becomes the first copy
+	spare := len(to.Sheets) == 1 && to.Sheets[0].Properties != nil && !wanted[to.Sheets[0].Properties.Title]
+
+	requests := []*sheets.Request{}
+	for i, p := range missing {
+		props := &sheets.SheetProperties{Title: p.Title}
+		if p.GridProperties != nil {
+			props.GridProperties = &sheets.GridProperties{
+				FrozenRowCount:    p.GridProperties.FrozenRowCount,
+				FrozenColumnCount: p.GridProperties.FrozenColumnCount,
+			}
+		}
+
+		if i == 0 && spare {
+			props.SheetId = to.Sheets[0].Properties.SheetId
+			requests = append(requests, &sheets.Request{
+				UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+					Properties: props,
+					Fields:     "title,gridProperties.frozenRowCount,gridProperties.frozenColumnCount",
+				},
+			})
+			continue
+		}
+
+		requests = append(requests, &sheets.Request{AddSheet: &sheets.AddSheetRequest{Properties: props}})
+	}
+
+	resp, err := gs.api.BatchUpdate(context.Background(), to.SpreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests:                     requests,
+		IncludeSpreadsheetInResponse: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// the header rows go with the mark, so a part is only marked copied once
+	// all of its sheets are set up
+	requests = []*sheets.Request{}
+	for _, sheet := range resp.UpdatedSpreadsheet.Sheets {
+		rows, ok := headers[sheet.Properties.Title]
+		if !ok || len(rows) == 0 || existing[sheet.Properties.Title] {
+			continue
+		}
+
+		requests = append(requests, &sheets.Request{
+			UpdateCells: &sheets.UpdateCellsRequest{
+				Start:  &sheets.GridCoordinate{SheetId: sheet.Properties.SheetId},
+				Rows:   rows,
+				Fields: "userEnteredValue,userEnteredFormat",
+			},
+		})
+	}
+
+	_, err = gs.api.BatchUpdate(context.Background(), to.SpreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: append(requests, copied),
+	})
+	return err
+}
@@ -0,0 +1,257 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"google.golang.org/api/sheets/v4"
+)
+
+var ErrSheetNotFound = errors.New("sheet not found")
+
+// how long what we know about a spreadsheet is trusted
+const sheetCatalogTTL = 5 * time.Minute
+
+// spreadsheetInfo is what the connector keeps about a spreadsheet: its
+// sheets, for routing and sizing, and the part continuing it, see Parts.
+type spreadsheetInfo struct {
+	title string
+	next  string
+	// copied is set on parts that got the sheets of the one they continue
+	copied  bool
+	sheets  []*sheets.SheetProperties
+	fetched time.Time
+	// cells added by appends since the sheets were read
+	added int64
+}
+
+// cells is the number of cells of the spreadsheet's grids, which is what
+// Google's cell limit counts.
+func (i spreadsheetInfo) cells() int64 {
+	cells := i.added
+	for _, p := range i.sheets {
+		if p.GridProperties != nil {
+			cells += p.GridProperties.RowCount * p.GridProperties.ColumnCount
+		}
+	}
+	return cells
+}
+
+// sheetCatalog caches what we know about every spreadsheet written to, so
+// routing a message doesn't cost a request.
+type sheetCatalog struct {
+	mu           sync.Mutex
+	spreadsheets map[string]spreadsheetInfo
+}
+
+func newSheetCatalog() *sheetCatalog {
+	return &sheetCatalog{spreadsheets: make(map[string]spreadsheetInfo)}
+}
+
+func (c *sheetCatalog) get(spreadSheetID string) (spreadsheetInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, ok := c.spreadsheets[spreadSheetID]
+	if !ok || time.Since(info.fetched) > sheetCatalogTTL {
+		return spreadsheetInfo{}, false
+	}
+	return info, true
+}
+
+func (c *sheetCatalog) put(spreadSheetID string, info spreadsheetInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info.fetched = time.Now()
+	c.spreadsheets[spreadSheetID] = info
+}
+
+func (c *sheetCatalog) forget(spreadSheetID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.spreadsheets, spreadSheetID)
+}
+
+// grow accounts for rows appended to a sheet, which grow its grid by a row
+// of its full width each.
+func (c *sheetCatalog) grow(spreadSheetID string, sheet string, rows int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, ok := c.spreadsheets[spreadSheetID]
+	if !ok {
+		return
+	}
+
+	for _, p := range info.sheets {
+		if p.Title == sheet && p.GridProperties != nil {
+			info.added += int64(rows) * p.GridProperties.ColumnCount
+		}
+	}
+	c.spreadsheets[spreadSheetID] = info
+}
+
+// spreadsheetInfo returns what we know about the spreadsheet, reading it
+// again when fresh is set.
+func (gs *GoogleSheetClient) spreadsheetInfo(spreadSheetID string, fresh bool) (spreadsheetInfo, error) {
+	if !fresh {
+		if info, ok := gs.catalog.get(spreadSheetID); ok {
+			return info, nil
+		}
+	}
+
+	spreadsheet, err := gs.api.GetSpreadsheet(context.Background(), spreadSheetID, nil, false)
+	if err != nil {
+		return spreadsheetInfo{}, err
+	}
+
+	info := spreadsheetInfo{}
+	if spreadsheet.Properties != nil {
+		info.title = spreadsheet.Properties.Title
+	}
+
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties != nil {
+			info.sheets = append(info.sheets, sheet.Properties)
+		}
+	}
+
+	for _, metadata := range spreadsheet.DeveloperMetadata {
+		switch metadata.MetadataKey {
+		case nextPartMetadataKey:
+			info.next = metadata.MetadataValue
+		case copiedMetadataKey:
+			info.copied = true
+		}
+	}
+
+	gs.catalog.put(spreadSheetID, info)
+	return info, nil
+}
+
+// sheetProperties returns the properties of every sheet of the spreadsheet,
+// reading them again when fresh is set.
+func (gs *GoogleSheetClient) sheetProperties(spreadSheetID string, fresh bool) ([]*sheets.SheetProperties, error) {
+	info, err := gs.spreadsheetInfo(spreadSheetID, fresh)
+	if err != nil {
+		return nil, err
+	}
+	return info.sheets, nil
+}
+
+// findSheet looks a sheet up by its ID or title.
+func (gs *GoogleSheetClient) findSheet(spreadSheetID string, sheet string, fresh bool) (*sheets.SheetProperties, error) {
+	props, err := gs.sheetProperties(spreadSheetID, fresh)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range props {
+		if p.Title == sheet || strconv.FormatInt(p.SheetId, 10) == sheet {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+// Route returns the target of a message. The sheet named by SheetID, by ID or
+// title, is used when given. Otherwise every form is written to a tab of its
+// own titled by its ID, which Apply creates with its header row the first
+// time the form is written to. Deletes and redactions that name neither, or
+// whose form has no tab, are applied to every sheet of the spreadsheet.
+func (gs *GoogleSheetClient) Route(message *model.GoogleSheetKafkaMessage) (Target, error) {
+	target := Target{
+		SpreadSheetID: message.SpreadSheetID,
+		Columns:       message.Columns,
+		Mode:          message.Mode,
+		RedactFields:  message.RedactFields,
+	}
+
+	if message.SheetID != "" {
+		props, err := gs.findSheet(message.SpreadSheetID, message.SheetID, false)
+		if err == nil && props == nil {
+			props, err = gs.findSheet(message.SpreadSheetID, message.SheetID, true)
+		}
+		if err != nil {
+			return Target{}, err
+		}
+		if props == nil {
+			return Target{}, ErrSheetNotFound
+		}
+
+		target.Sheet = props.Title
+		return target, nil
+	}
+
+	if message.Questionnaire.FormID != nil {
+		target.Sheet = FormSheetTitle(*message.Questionnaire.FormID)
+	}
+
+	// only erasures can do without a sheet, they then look through all of them
+	if target.Sheet == "" && !message.Operation.Erases() {
+		return Target{}, ErrSheetNotFound
+	}
+	return target, nil
+}
+
+// EnsureSheet creates the target's sheet with its header row unless it exists,
+// in the latest part of the spreadsheet. Apply does so itself before writing.
+func (gs *GoogleSheetClient) EnsureSheet(target Target) error {
+	unlock := gs.locks.lock(sheetKey{spreadSheetID: target.SpreadSheetID, sheet: target.Sheet})
+	defer unlock()
+
+	part, err := gs.currentPart(target.SpreadSheetID)
+	if err != nil {
+		return err
+	}
+	target.SpreadSheetID = part
+
+	return gs.ensureSheet(target)
+}
+
+// ensureSheet creates the target's sheet with its header row unless it
+// exists. The caller holds the lock of the sheet.
+func (gs *GoogleSheetClient) ensureSheet(target Target) error {
+	props, err := gs.findSheet(target.SpreadSheetID, target.Sheet, false)
+	if err == nil && props == nil {
+		// someone may have added it since the sheets were listed
+		props, err = gs.findSheet(target.SpreadSheetID, target.Sheet, true)
+	}
+	if err != nil || props != nil {
+		return err
+	}
+
+	sheetID, err := gs.CreateSheet(target.SpreadSheetID, target.Sheet, 0)
+	if err != nil {
+		// lost a race with another instance of the connector
+		if props, _ := gs.findSheet(target.SpreadSheetID, target.Sheet, true); props != nil {
+			return nil
+		}
+
+		gs.logger.Error("failed to create sheet :: stacktrace ::", err)
+		return err
+	}
+
+	gs.catalog.forget(target.SpreadSheetID)
+
+	// should this fail, the first write still adds the missing headers, only
+	// without their formatting
+	return gs.AppendColumnHeaders(target.SpreadSheetID, *sheetID, target.Sheet, target.columns())
+}
+
+// FormSheetTitle is the title of the tab of a form. Sheet titles are limited
+// to 100 characters.
+func FormSheetTitle(formID string) string {
+	title := []rune(strings.TrimSpace(formID))
+	if len(title) > 100 {
+		title = title[:100]
+	}
+	return string(title)
+}
@@ -0,0 +1,43 @@
+package google
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestFormSheetTitle(t *testing.T) {
+	require.Equal(t, "form-1", FormSheetTitle(" form-1 "))
+
+	title := FormSheetTitle(strings.Repeat("é", 120))
+	require.Equal(t, strings.Repeat("é", 100), title)
+}
+
+func TestSheetCatalog(t *testing.T) {
+	catalog := newSheetCatalog()
+
+	_, ok := catalog.get("spreadsheet")
+	require.False(t, ok)
+
+	props := []*sheets.SheetProperties{
+		{SheetId: 0, Title: "Sheet1", GridProperties: &sheets.GridProperties{RowCount: 1000, ColumnCount: 26}},
+		{SheetId: 42, Title: "form-1", GridProperties: &sheets.GridProperties{RowCount: 10, ColumnCount: 20}},
+	}
+	catalog.put("spreadsheet", spreadsheetInfo{sheets: props})
+
+	info, ok := catalog.get("spreadsheet")
+	require.True(t, ok)
+	require.Equal(t, props, info.sheets)
+	require.EqualValues(t, 26200, info.cells())
+
+	// appended rows are as wide as their sheet's grid
+	catalog.grow("spreadsheet", "form-1", 5)
+	info, _ = catalog.get("spreadsheet")
+	require.EqualValues(t, 26300, info.cells())
+
+	catalog.forget("spreadsheet")
+	_, ok = catalog.get("spreadsheet")
+	require.False(t, ok)
+}
@@ -2,15 +2,11 @@ package google
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"sort"
-	"strings"
 
 	"github.com/adetunjii/google-sheets-connector/internal/model"
 	"github.com/adetunjii/google-sheets-connector/pkg/logger"
 	"golang.org/x/oauth2"
-	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
@@ -24,34 +20,105 @@ type SpreadSheet struct {
 	Sheets []*sheets.Sheet `json:"sheets"`
 	Title  string          `json:"title"`
 	Url    string          `json:"url"`
-	Token  *oauth2.Token   `json:"token"`
+	Token  *oauth2.Token   `json:"token,omitempty"`
+	// OrgID is the organization the spreadsheet is created for, which a
+	// stored token has to be issued for.
+	OrgID string `json:"org_id,omitempty"`
+	// FormID is the form whose tab is created along with the spreadsheet.
+	// Without one, tabs are created with the first answer to their form.
+	FormID  string `json:"form_id,omitempty"`
+	TokenID string `json:"token_id,omitempty"`
+	// Credentials, ServiceAccountKeyID and Subject are as in model.Integration.
+	Credentials         model.CredentialType  `json:"credentials,omitempty"`
+	ServiceAccountKeyID string                `json:"service_account_key_id,omitempty"`
+	Subject             string                `json:"subject,omitempty"`
+	Columns             []model.ColumnMapping `json:"columns,omitempty"`
+	Mode                model.WriteMode       `json:"mode,omitempty"`
+	// Parts lists the spreadsheet followed by its continuations, see Parts.
+	Parts []string `json:"parts,omitempty"`
+}
+
+// Target is where and how questionnaire answers are written.
+type Target struct {
+	SpreadSheetID string
+	Sheet         string
+	Columns       []model.ColumnMapping
+	Mode          model.WriteMode
+	// RedactFields are the fields a redaction blanks, see RedactedFields.
+	RedactFields []string
+	// anySheet is set on the sheets an erasure naming no sheet goes through.
+	anySheet bool
+}
+
+// columns returns the column mapping the target's mode writes.
+func (t Target) columns() []model.ColumnMapping {
+	return ModeColumnMapping(t.Mode, t.Columns)
+}
+
+// ModeColumnMapping returns the columns a sheet in the given mode is written
+// with, see ResponseColumnMapping and UpsertColumnMapping.
+func ModeColumnMapping(mode model.WriteMode, columns []model.ColumnMapping) []model.ColumnMapping {
+	switch mode {
+	case model.WriteModeResponse:
+		return ResponseColumnMapping(columns)
+	case model.WriteModeUpsert:
+		return UpsertColumnMapping(columns)
+	default:
+		return columns
+	}
 }
 
 type GoogleSheetClient struct {
-	svc    *sheets.Service
-	logger logger.AppLogger
+	api     SheetsAPI
+	layouts *layoutCache
+	indexes *rowIndexCache
+	locks   *sheetLocks
+	catalog *sheetCatalog
+	logger  logger.AppLogger
+
+	rolloverCells int64
+	onRollover    RolloverHook
 }
 
 func NewGoogleSheetClient(googleClient *GoogleClient, token *oauth2.Token, logger logger.AppLogger) *GoogleSheetClient {
-	client := googleClient.config.Client(context.Background(), token)
+	return newGoogleSheetClient(googleClient, googleClient.config.TokenSource(context.Background(), token), userKey(token), logger)
+}
 
-	svc, err := sheets.NewService(context.Background(), option.WithHTTPClient(client))
+// newGoogleSheetClient builds a client authorised by source, rate limited as
+// the principal it acts as.
+func newGoogleSheetClient(googleClient *GoogleClient, source oauth2.TokenSource, principal string, logger logger.AppLogger) *GoogleSheetClient {
+	client := oauth2.NewClient(context.Background(), source)
+	client.Transport = googleClient.rateLimiter.transport(client.Transport, principal)
+
+	api, err := googleClient.sheetsAPI(client)
 	if err != nil {
 		return nil
 	}
+	return newSheetClient(googleClient, api, logger)
+}
 
+// newSheetClient returns a client calling api, sharing the caches of
+// googleClient with every other client.
+func newSheetClient(googleClient *GoogleClient, api SheetsAPI, logger logger.AppLogger) *GoogleSheetClient {
 	return &GoogleSheetClient{
-		svc:    svc,
-		logger: logger,
+		api:     api,
+		layouts: googleClient.layouts,
+		indexes: googleClient.indexes,
+		locks:   googleClient.locks,
+		catalog: googleClient.catalog,
+		logger:  logger,
+
+		rolloverCells: googleClient.rolloverCells,
+		onRollover:    googleClient.onRollover,
 	}
 }
 
 func (gs *GoogleSheetClient) CreateSpreadSheet(name string) (*sheets.Spreadsheet, error) {
-	spreadsheet, err := gs.svc.Spreadsheets.Create(&sheets.Spreadsheet{
+	spreadsheet, err := gs.api.CreateSpreadsheet(context.Background(), &sheets.Spreadsheet{
 		Properties: &sheets.SpreadsheetProperties{
 			Title: name,
 		},
-	}).Do()
+	})
 
 	if err != nil {
 		return nil, err
@@ -73,7 +140,7 @@ func (gs *GoogleSheetClient) CreateSheet(spreadsheetId string, name string, colu
 		},
 	}
 
-	resp, err := gs.svc.Spreadsheets.BatchUpdate(spreadsheetId, req).Context(context.Background()).Do()
+	resp, err := gs.api.BatchUpdate(context.Background(), spreadsheetId, req)
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +162,7 @@ func (gs *GoogleSheetClient) DeleteSheet(spreadSheetID string, sheetID int64) er
 		},
 	}
 
-	_, err := gs.svc.Spreadsheets.BatchUpdate(spreadSheetID, updateSheetRequest).Context(context.Background()).Do()
+	_, err := gs.api.BatchUpdate(context.Background(), spreadSheetID, updateSheetRequest)
 	if err != nil {
 		return err
 	}
@@ -103,17 +170,23 @@ func (gs *GoogleSheetClient) DeleteSheet(spreadSheetID string, sheetID int64) er
 	return nil
 }
 
-func (gs *GoogleSheetClient) AppendColumnHeaders(spreadSheetId string, sheetID int64, sheetTitle string, headers []string) error {
+// AppendColumnHeaders writes the header row of a sheet, one header per column
+// of the mapping in the mapping's order. An empty mapping writes every field,
+// see DefaultColumnMapping.
+func (gs *GoogleSheetClient) AppendColumnHeaders(spreadSheetId string, sheetID int64, sheetTitle string, columns []model.ColumnMapping) error {
 
-	h := make([]interface{}, len(headers))
+	mapped, err := resolveColumns(columns)
+	if err != nil {
+		return err
+	}
+
+	h := make([]interface{}, len(mapped))
 
 	// value range values
 	v := [][]interface{}{}
 
-	sort.Strings(headers)
-
-	for i, header := range headers {
-		h[i] = strings.ToUpper(header)
+	for i, c := range mapped {
+		h[i] = c.header
 	}
 
 	v = append(v, h)
@@ -123,7 +196,7 @@ func (gs *GoogleSheetClient) AppendColumnHeaders(spreadSheetId string, sheetID i
 	}
 
 	// cellRange, valueRange
-	cellRange := fmt.Sprintf("%s!R1C1:R1C%d", sheetTitle, len(headers))
+	cellRange := fmt.Sprintf("%s!R1C1:R1C%d", quoteSheet(sheetTitle), len(mapped))
 	// append data to the sheet
 	if err := gs.appendRowData(spreadSheetId, cellRange, values); err != nil {
 		return err
@@ -147,7 +220,7 @@ func (gs *GoogleSheetClient) AppendColumnHeaders(spreadSheetId string, sheetID i
 						StartRowIndex:    0,
 						EndRowIndex:      1,
 						StartColumnIndex: 0,
-						EndColumnIndex:   int64(len(headers)),
+						EndColumnIndex:   int64(len(mapped)),
 					},
 					Cell: &sheets.CellData{
 						UserEnteredFormat: headerFormat,
@@ -170,7 +243,7 @@ func (gs *GoogleSheetClient) AppendColumnHeaders(spreadSheetId string, sheetID i
 		},
 	}
 
-	_, err := gs.svc.Spreadsheets.BatchUpdate(spreadSheetId, req).Context(context.Background()).Do()
+	_, err = gs.api.BatchUpdate(context.Background(), spreadSheetId, req)
 	if err != nil {
 		return err
 	}
@@ -179,66 +252,121 @@ func (gs *GoogleSheetClient) AppendColumnHeaders(spreadSheetId string, sheetID i
 }
 
 func (gs *GoogleSheetClient) RowCount(spreadSheetID string, cellRange string) int {
-	valueRange, err := gs.svc.Spreadsheets.Values.Get(spreadSheetID, cellRange).Context(context.Background()).Do()
+	valueRange, err := gs.api.GetValues(context.Background(), spreadSheetID, cellRange)
 	if err != nil {
 		gs.logger.Error("failed to fetch values :: stacktrace ::", err)
+		return 0
 	}
 	return len(valueRange.Values)
 }
 
 // uses R1C1 notation for cell ranges
 func (gs *GoogleSheetClient) appendRowData(spreadSheetID string, cellRange string, rowValues *sheets.ValueRange) error {
-	fmt.Println(rowValues)
-	_, err := gs.svc.Spreadsheets.Values.Append(spreadSheetID, cellRange, rowValues).ValueInputOption(VALUE_INPUT_OPTION).InsertDataOption(INSERT_DATA_OPTION).Context(context.Background()).Do()
+	_, err := gs.appendValues(spreadSheetID, cellRange, rowValues)
+	return err
+}
+
+func (gs *GoogleSheetClient) appendValues(spreadSheetID string, cellRange string, rowValues *sheets.ValueRange) (*sheets.AppendValuesResponse, error) {
+	resp, err := gs.api.AppendValues(context.Background(), spreadSheetID, cellRange, rowValues)
 	if err != nil {
 		gs.logger.Error("failed to append row data :: stacktrace :: ", err)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// WriteToSheet writes data to the tab of its form, see Route.
+func (gs *GoogleSheetClient) WriteToSheet(spreadSheetID string, columns []model.ColumnMapping, data *model.QuestionnarieData) error {
+	target, err := gs.Route(&model.GoogleSheetKafkaMessage{SpreadSheetID: spreadSheetID, Columns: columns, Questionnaire: *data})
+	if err != nil {
 		return err
 	}
-	return nil
+	return gs.Write(target, []*model.QuestionnarieData{data})
 }
 
-func (gs *GoogleSheetClient) WriteToSheet(spreadSheetID string, data *model.QuestionnarieData) error {
+// Write writes questionnaire answers to the target sheet according to its
+// mode. It is Apply with OperationInsert.
+func (gs *GoogleSheetClient) Write(target Target, data []*model.QuestionnarieData) error {
+	return gs.Apply(target, model.OperationInsert, data)
+}
 
-	if err := data.Validate(); err != nil {
+// Apply carries out op for the given answers on the target sheet. Calls for
+// the same sheet are serialised so that rows looked up by one are still where
+// it left them. Writes go to the latest part of the spreadsheet, see Parts,
+// and create the sheet in it when it is missing.
+func (gs *GoogleSheetClient) Apply(target Target, op model.Operation, data []*model.QuestionnarieData) error {
+	if err := target.Mode.Validate(); err != nil {
 		return err
 	}
 
-	v := [][]interface{}{}
-	row := []interface{}{}
+	if op.Erases() {
+		return gs.erase(target, op, data)
+	}
 
-	m := map[string]interface{}{}
+	unlock := gs.locks.lock(sheetKey{spreadSheetID: target.SpreadSheetID, sheet: target.Sheet})
+	defer unlock()
 
-	bytes, err := json.Marshal(data)
+	part, err := gs.currentPart(target.SpreadSheetID)
 	if err != nil {
 		return err
 	}
+	target.SpreadSheetID = part
 
-	if err := json.Unmarshal(bytes, &m); err != nil {
+	if err := gs.ensureSheet(target); err != nil {
 		return err
 	}
 
-	keys := []string{}
+	switch target.Mode {
+	case model.WriteModeResponse:
+		err = gs.writeResponses(target, data)
+	case model.WriteModeUpsert:
+		err = gs.upsertAnswers(target, data)
+	default:
+		err = gs.appendAnswers(target, data)
+	}
 
-	for key := range m {
-		keys = append(keys, key)
+	if err != nil {
+		gs.forget(target)
 	}
+	return err
+}
+
+// forget drops everything cached about the target sheet, which may have
+// changed under us.
+func (gs *GoogleSheetClient) forget(target Target) {
+	gs.layouts.forget(sheetKey{spreadSheetID: target.SpreadSheetID, sheet: target.Sheet})
+	gs.indexes.forget(sheetKey{spreadSheetID: target.SpreadSheetID, sheet: target.Sheet})
+}
 
-	sort.Strings(keys)
+// appendAnswers writes one row per questionnaire answer to the target sheet
+// with a single append request. Values are placed under the header of their
+// column in the mapping, and columns the sheet has no header for yet get one.
+func (gs *GoogleSheetClient) appendAnswers(target Target, data []*model.QuestionnarieData) error {
 
-	for _, key := range keys {
-		row = append(row, m[key])
+	for _, d := range data {
+		if err := d.Validate(); err != nil {
+			return err
+		}
 	}
 
-	v = append(v, row)
+	layout, err := gs.sheetLayout(target.SpreadSheetID, target.Sheet, target.columns())
+	if err != nil {
+		return err
+	}
+
+	v := [][]interface{}{}
+	for _, d := range data {
+		v = append(v, layout.row(d))
+	}
 
 	valueRange := &sheets.ValueRange{
 		Values: v,
 	}
 
-	fmt.Println("here")
-	if err := gs.appendRowData(spreadSheetID, "Sheet1", valueRange); err != nil {
+	if err := gs.appendRowData(target.SpreadSheetID, quoteSheet(target.Sheet), valueRange); err != nil {
 		return err
 	}
 
+	gs.catalog.grow(target.SpreadSheetID, target.Sheet, len(v))
 	return nil
 }
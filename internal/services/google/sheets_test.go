@@ -0,0 +1,234 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google/sheetstest"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/sheets/v4"
+)
+
+func fakeSheetClient(t *testing.T, opts ...ClientOption) (*GoogleSheetClient, *sheetstest.Fake) {
+	fake := sheetstest.New()
+	opts = append(opts, WithSheetsAPI(func(*http.Client) (SheetsAPI, error) { return fake, nil }))
+
+	g := NewGoogleClient("client", "secret", nil, "", logger.NewLogger(zap.NewNop().Sugar()), opts...)
+	gs := NewGoogleSheetClient(g, &oauth2.Token{AccessToken: "access"}, g.logger)
+	require.NotNil(t, gs)
+	return gs, fake
+}
+
+func answer(formID string, answerID string, text string) *model.QuestionnarieData {
+	at := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	return &model.QuestionnarieData{
+		FormID:          strPtr(formID),
+		QuestionID:      strPtr("q-1"),
+		QuestionTitle:   strPtr("How was it?"),
+		AnswerID:        strPtr(answerID),
+		Answer:          strPtr(text),
+		RespondentID:    strPtr("r-1"),
+		RespondentEmail: strPtr("r@example.com"),
+		OrgID:           strPtr("org"),
+		FormStartDate:   at,
+		CreatedAt:       at,
+		UpdatedAt:       at,
+	}
+}
+
+func TestAppendColumnHeaders(t *testing.T) {
+	gs, fake := fakeSheetClient(t)
+
+	s, err := gs.CreateSpreadSheet("answers")
+	require.NoError(t, err)
+
+	columns := []model.ColumnMapping{{Field: "respondent_email", Header: "Email"}, {Field: "answer"}}
+	require.NoError(t, gs.AppendColumnHeaders(s.SpreadsheetId, 0, "Sheet1", columns))
+
+	require.Equal(t, [][]interface{}{{"Email", "ANSWER"}}, fake.Values(s.SpreadsheetId, "Sheet1"))
+	require.True(t, fake.Format(s.SpreadsheetId, "Sheet1", 1, 2).TextFormat.Bold)
+	require.Nil(t, fake.Format(s.SpreadsheetId, "Sheet1", 1, 3))
+	require.EqualValues(t, 1, fake.Sheet(s.SpreadsheetId, "Sheet1").GridProperties.FrozenRowCount)
+
+	// a sheet that doesn't exist
+	require.Error(t, gs.AppendColumnHeaders(s.SpreadsheetId, 7, "Missing", columns))
+}
+
+func TestWriteToSheet(t *testing.T) {
+	gs, fake := fakeSheetClient(t)
+
+	s, err := gs.CreateSpreadSheet("answers")
+	require.NoError(t, err)
+
+	columns := []model.ColumnMapping{{Field: "answer_id"}, {Field: "answer", Header: "Answer"}}
+	require.NoError(t, gs.WriteToSheet(s.SpreadsheetId, columns, answer("form-1", "a-1", "great")))
+	require.NoError(t, gs.WriteToSheet(s.SpreadsheetId, columns, answer("form-1", "a-2", "fine")))
+	require.NoError(t, gs.WriteToSheet(s.SpreadsheetId, columns, answer("form-2", "a-3", "meh")))
+
+	// a tab per form, each with its header row
+	require.Equal(t, [][]interface{}{{"ANSWERID", "Answer"}, {"a-1", "great"}, {"a-2", "fine"}}, fake.Values(s.SpreadsheetId, "form-1"))
+	require.Equal(t, [][]interface{}{{"ANSWERID", "Answer"}, {"a-3", "meh"}}, fake.Values(s.SpreadsheetId, "form-2"))
+	require.EqualValues(t, 1, fake.Sheet(s.SpreadsheetId, "form-1").GridProperties.FrozenRowCount)
+
+	require.Error(t, gs.WriteToSheet(s.SpreadsheetId, columns, &model.QuestionnarieData{FormID: strPtr("form-1")}))
+	require.Error(t, gs.WriteToSheet("missing", columns, answer("form-1", "a-4", "lost")))
+}
+
+func TestApplyUpsertsAndDeletes(t *testing.T) {
+	gs, fake := fakeSheetClient(t)
+
+	s, err := gs.CreateSpreadSheet("answers")
+	require.NoError(t, err)
+
+	target := Target{SpreadSheetID: s.SpreadsheetId, Sheet: "form-1", Columns: []model.ColumnMapping{{Field: "answer"}}, Mode: model.WriteModeUpsert}
+	require.NoError(t, gs.Write(target, []*model.QuestionnarieData{answer("form-1", "a-1", "great"), answer("form-1", "a-2", "fine")}))
+
+	edited := answer("form-1", "a-1", "good")
+	edited.UpdatedAt = edited.UpdatedAt.Add(time.Minute)
+	require.NoError(t, gs.Apply(target, model.OperationUpdate, []*model.QuestionnarieData{edited}))
+	require.Equal(t, [][]interface{}{
+		{"ANSWERID", "UPDATEDAT", "ANSWER"},
+		{"a-1", "2022-11-01T12:01:00Z", "good"},
+		{"a-2", "2022-11-01T12:00:00Z", "fine"},
+	}, fake.Values(s.SpreadsheetId, "form-1"))
+
+	// the version in the sheet is newer than a redelivery of the original
+	require.NoError(t, gs.Apply(target, model.OperationUpdate, []*model.QuestionnarieData{answer("form-1", "a-1", "great")}))
+	require.Equal(t, "good", fake.Values(s.SpreadsheetId, "form-1")[1][2])
+
+	require.NoError(t, gs.Apply(target, model.OperationDelete, []*model.QuestionnarieData{{AnswerID: strPtr("a-1")}}))
+	require.Equal(t, [][]interface{}{{"ANSWERID", "UPDATEDAT", "ANSWER"}, {"a-2", "2022-11-01T12:00:00Z", "fine"}}, fake.Values(s.SpreadsheetId, "form-1"))
+}
+
+func TestApplyDeletesAnswersFromResponses(t *testing.T) {
+	gs, fake := fakeSheetClient(t)
+
+	s, err := gs.CreateSpreadSheet("answers")
+	require.NoError(t, err)
+
+	target := Target{SpreadSheetID: s.SpreadsheetId, Sheet: "form-1", Columns: []model.ColumnMapping{{Field: "respondent_email"}}, Mode: model.WriteModeResponse}
+	second := answer("form-1", "a-2", "yes")
+	second.QuestionTitle = strPtr("Again?")
+	require.NoError(t, gs.Write(target, []*model.QuestionnarieData{answer("form-1", "a-1", "great"), second}))
+
+	// the respondent's other answers stay in the row
+	deleted := &model.QuestionnarieData{AnswerID: strPtr("a-1"), RespondentID: strPtr("r-1"), FormID: strPtr("form-1"), QuestionTitle: strPtr("How was it?")}
+	require.NoError(t, gs.Apply(target, model.OperationDelete, []*model.QuestionnarieData{deleted}))
+	require.Equal(t, [][]interface{}{
+		{"RESPONDENTID", "FORMID", "RESPONDENTEMAIL", "How was it?", "Again?"},
+		{"r-1", "form-1", "r@example.com", "", "yes"},
+	}, fake.Values(s.SpreadsheetId, "form-1"))
+
+	// redacting the answers blanks the question columns too
+	target.RedactFields = []string{"respondent_id", "respondent_email", "answers"}
+	require.NoError(t, gs.Apply(target, model.OperationRedact, []*model.QuestionnarieData{{RespondentID: strPtr("r-1"), FormID: strPtr("form-1")}}))
+	require.Equal(t, [][]interface{}{
+		{"RESPONDENTID", "FORMID", "RESPONDENTEMAIL", "How was it?", "Again?"},
+		{"", "form-1"},
+	}, fake.Values(s.SpreadsheetId, "form-1"))
+
+	// without its question the answer's cell can't be found
+	err = gs.Apply(target, model.OperationDelete, []*model.QuestionnarieData{{AnswerID: strPtr("a-2"), RespondentID: strPtr("r-1")}})
+	require.ErrorIs(t, err, ErrNoRowReference)
+}
+
+func TestApplyErasesFromTheFormsSheetOnly(t *testing.T) {
+	gs, fake := fakeSheetClient(t)
+
+	s, err := gs.CreateSpreadSheet("answers")
+	require.NoError(t, err)
+
+	// answers of another form, in a sheet without a form_id column
+	columns := []model.ColumnMapping{{Field: "respondent_id"}, {Field: "answer"}}
+	other := Target{SpreadSheetID: s.SpreadsheetId, Sheet: "Sheet1", Columns: columns}
+	require.NoError(t, gs.Write(other, []*model.QuestionnarieData{answer("form-2", "a-1", "great")}))
+
+	// the form has no sheet, so there is nothing of it to erase
+	respondent := &model.QuestionnarieData{RespondentID: strPtr("r-1"), FormID: strPtr("form-1")}
+	target := Target{SpreadSheetID: s.SpreadsheetId, Sheet: "form-1", Columns: columns}
+	require.NoError(t, gs.Apply(target, model.OperationDelete, []*model.QuestionnarieData{respondent}))
+	require.NoError(t, gs.Apply(target, model.OperationRedact, []*model.QuestionnarieData{respondent}))
+
+	// nor does an erasure naming no sheet find the form's respondent elsewhere
+	target.Sheet = ""
+	require.NoError(t, gs.Apply(target, model.OperationDelete, []*model.QuestionnarieData{respondent}))
+	require.Equal(t, [][]interface{}{{"RESPONDENTID", "ANSWER"}, {"r-1", "great"}}, fake.Values(s.SpreadsheetId, "Sheet1"))
+
+	// while a respondent named without a form goes everywhere
+	require.NoError(t, gs.Apply(target, model.OperationDelete, []*model.QuestionnarieData{{RespondentID: strPtr("r-1")}}))
+	require.Equal(t, [][]interface{}{{"RESPONDENTID", "ANSWER"}}, fake.Values(s.SpreadsheetId, "Sheet1"))
+}
+
+func TestApplyRollsOver(t *testing.T) {
+	var rolled []string
+	// new sheets are 1000 by 26 cells, so the spreadsheet is full once the
+	// form's tab is added to the first one
+	gs, fake := fakeSheetClient(t, WithRolloverThreshold(2*26000), WithRolloverHook(func(id string, parts []string) { rolled = parts }))
+
+	s, err := gs.CreateSpreadSheet("answers")
+	require.NoError(t, err)
+
+	target := Target{SpreadSheetID: s.SpreadsheetId, Sheet: "form-1", Columns: []model.ColumnMapping{{Field: "answer"}}}
+	require.NoError(t, gs.Write(target, []*model.QuestionnarieData{answer("form-1", "a-1", "first")}))
+	require.NoError(t, gs.Write(target, []*model.QuestionnarieData{answer("form-1", "a-2", "second")}))
+
+	require.Len(t, rolled, 2)
+	require.Equal(t, s.SpreadsheetId, rolled[0])
+	require.Equal(t, [][]interface{}{{"ANSWER"}, {"first"}}, fake.Values(rolled[0], "form-1"))
+	require.Equal(t, [][]interface{}{{"ANSWER"}, {"second"}}, fake.Values(rolled[1], "form-1"))
+	require.True(t, fake.Format(rolled[1], "form-1", 1, 1).TextFormat.Bold)
+}
+
+// failingCopy fails the requests setting up the sheets of a new part while
+// fail is set.
+type failingCopy struct {
+	*sheetstest.Fake
+	fail bool
+}
+
+func (f *failingCopy) BatchUpdate(ctx context.Context, spreadsheetID string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	for _, r := range req.Requests {
+		if f.fail && r.AddSheet != nil {
+			return nil, errors.New("backend error")
+		}
+	}
+	return f.Fake.BatchUpdate(ctx, spreadsheetID, req)
+}
+
+func TestRolloverResumesFailedCopy(t *testing.T) {
+	api := &failingCopy{Fake: sheetstest.New()}
+	g := NewGoogleClient("client", "secret", nil, "", logger.NewLogger(zap.NewNop().Sugar()),
+		WithRolloverThreshold(2*26000), WithSheetsAPI(func(*http.Client) (SheetsAPI, error) { return api, nil }))
+	gs := NewGoogleSheetClient(g, &oauth2.Token{AccessToken: "access"}, g.logger)
+
+	s, err := gs.CreateSpreadSheet("answers")
+	require.NoError(t, err)
+
+	target := Target{SpreadSheetID: s.SpreadsheetId, Sheet: "form-1", Columns: []model.ColumnMapping{{Field: "answer"}}}
+	require.NoError(t, gs.Write(target, []*model.QuestionnarieData{answer("form-1", "a-1", "first")}))
+
+	api.fail = true
+	require.Error(t, gs.Write(target, []*model.QuestionnarieData{answer("form-1", "a-2", "second")}))
+	require.Len(t, api.SpreadsheetIDs(), 2)
+
+	// the retry finishes the part created already instead of adding another;
+	// copied, it would be as full as the first one under this threshold
+	api.fail = false
+	gs.rolloverCells = DefaultRolloverCells
+	require.NoError(t, gs.Write(target, []*model.QuestionnarieData{answer("form-1", "a-2", "second")}))
+	require.Len(t, api.SpreadsheetIDs(), 2)
+
+	parts, err := gs.Parts(s.SpreadsheetId)
+	require.NoError(t, err)
+	require.Len(t, parts, 2)
+	require.Equal(t, [][]interface{}{{"ANSWER"}, {"first"}}, api.Values(parts[0], "form-1"))
+	require.Equal(t, [][]interface{}{{"ANSWER"}, {"second"}}, api.Values(parts[1], "form-1"))
+	require.True(t, api.Format(parts[1], "form-1", 1, 1).TextFormat.Bold)
+}
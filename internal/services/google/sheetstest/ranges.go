@@ -0,0 +1,132 @@
+package sheetstest
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// gridRange is a 1-based range of cells, with zero ends for ranges open to
+// the end of the sheet.
+type gridRange struct {
+	startRow, startCol int
+	endRow, endCol     int
+}
+
+var (
+	// R1C1, R2C3:C3 and R1C1:R1C5
+	r1c1 = regexp.MustCompile(`^R(\d+)C(\d+)(?::(?:R(\d+))?C(\d+))?$`)
+	// A1, A1:C3, A:C and 1:1
+	a1 = regexp.MustCompile(`^([A-Z]*)(\d*)(?::([A-Z]*)(\d*))?$`)
+)
+
+// parseRange finds the sheet and cells of a range in A1 or R1C1 notation. A
+// range without cells is the whole sheet, one without a sheet is on the first.
+func (s *spreadsheet) parseRange(cellRange string) (*sheet, gridRange, error) {
+	title, cells, quoted := splitRange(cellRange)
+
+	var sh *sheet
+	if title != "" || quoted {
+		sh = s.sheetByTitle(title)
+	} else if len(s.sheets) > 0 {
+		sh = s.sheets[0]
+	}
+
+	// an unquoted name without cells may be either
+	if sh == nil && !quoted && title == "" {
+		return nil, gridRange{}, badRequest("Unable to parse range: %s", cellRange)
+	}
+	if sh == nil && !quoted && cells == "" {
+		if sh = s.sheetByTitle(cellRange); sh == nil && len(s.sheets) > 0 {
+			sh, cells = s.sheets[0], cellRange
+		}
+	}
+	if sh == nil {
+		return nil, gridRange{}, badRequest("Unable to parse range: %s", cellRange)
+	}
+
+	r, ok := parseCells(strings.ToUpper(cells))
+	if !ok {
+		return nil, gridRange{}, badRequest("Unable to parse range: %s", cellRange)
+	}
+	return sh, r, nil
+}
+
+// splitRange splits a range into its sheet title and its cells.
+func splitRange(cellRange string) (string, string, bool) {
+	if strings.HasPrefix(cellRange, "'") {
+		title := strings.Builder{}
+		for i := 1; i < len(cellRange); i++ {
+			if cellRange[i] != '\'' {
+				title.WriteByte(cellRange[i])
+				continue
+			}
+			if i+1 < len(cellRange) && cellRange[i+1] == '\'' {
+				title.WriteByte('\'')
+				i++
+				continue
+			}
+			return title.String(), strings.TrimPrefix(cellRange[i+1:], "!"), true
+		}
+	}
+
+	if i := strings.LastIndex(cellRange, "!"); i >= 0 {
+		return cellRange[:i], cellRange[i+1:], false
+	}
+	return cellRange, "", false
+}
+
+func parseCells(cells string) (gridRange, bool) {
+	r := gridRange{startRow: 1, startCol: 1}
+	if cells == "" {
+		return r, true
+	}
+
+	if m := r1c1.FindStringSubmatch(cells); m != nil {
+		r.startRow, r.startCol = atoi(m[1]), atoi(m[2])
+		if m[4] == "" {
+			r.endRow, r.endCol = r.startRow, r.startCol
+		} else {
+			r.endRow, r.endCol = atoi(m[3]), atoi(m[4])
+		}
+		return r, true
+	}
+
+	m := a1.FindStringSubmatch(cells)
+	if m == nil || (m[1] == "" && m[2] == "") {
+		return r, false
+	}
+
+	if m[1] != "" {
+		r.startCol = columnNumber(m[1])
+	}
+	if m[2] != "" {
+		r.startRow = atoi(m[2])
+	}
+
+	if !strings.Contains(cells, ":") {
+		r.endRow, r.endCol = r.startRow, r.startCol
+		return r, true
+	}
+	if m[3] != "" {
+		r.endCol = columnNumber(m[3])
+	}
+	if m[4] != "" {
+		r.endRow = atoi(m[4])
+	}
+	return r, true
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// columnNumber is the 1-based number of an A1 column name.
+func columnNumber(name string) int {
+	n := 0
+	for _, r := range name {
+		n = n*26 + int(r-'A'+1)
+	}
+	return n
+}
@@ -0,0 +1,794 @@
+// Package sheetstest provides an in-memory stand-in for the Sheets API v4, for
+// tests of code written against google.SheetsAPI.
+package sheetstest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+// the size of new sheets, as in Google Sheets
+const (
+	defaultRowCount    = 1000
+	defaultColumnCount = 26
+)
+
+// Fake keeps spreadsheets as grids of cells. It implements the requests the
+// connector sends and fails others with a 400, like Google does for requests
+// it can't carry out. Values are stored as entered and read back formatted,
+// that is as strings.
+type Fake struct {
+	mu           sync.Mutex
+	spreadsheets map[string]*spreadsheet
+	created      int
+}
+
+type spreadsheet struct {
+	id       string
+	title    string
+	sheets   []*sheet
+	metadata []*sheets.DeveloperMetadata
+	nextID   int64
+}
+
+type sheet struct {
+	props *sheets.SheetProperties
+	// rows of cells, as far as anything was written
+	rows [][]*cell
+}
+
+type cell struct {
+	value  interface{}
+	format *sheets.CellFormat
+}
+
+func New() *Fake {
+	return &Fake{spreadsheets: map[string]*spreadsheet{}}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+func (f *Fake) CreateSpreadsheet(ctx context.Context, request *sheets.Spreadsheet) (*sheets.Spreadsheet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.created++
+	s := &spreadsheet{id: fmt.Sprintf("spreadsheet-%d", f.created), title: "Untitled spreadsheet"}
+	if request.Properties != nil && request.Properties.Title != "" {
+		s.title = request.Properties.Title
+	}
+
+	if len(request.Sheets) == 0 {
+		s.addSheet(&sheets.SheetProperties{Title: "Sheet1"})
+	}
+	for _, requested := range request.Sheets {
+		props := &sheets.SheetProperties{}
+		if requested.Properties != nil {
+			*props = *requested.Properties
+		}
+		if _, err := s.addSheet(props); err != nil {
+			return nil, err
+		}
+	}
+
+	f.spreadsheets[s.id] = s
+	return s.snapshot(nil, false)
+}
+
+func (f *Fake) GetSpreadsheet(ctx context.Context, spreadsheetID string, ranges []string, includeGridData bool) (*sheets.Spreadsheet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := f.spreadsheet(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	return s.snapshot(ranges, includeGridData)
+}
+
+func (f *Fake) BatchUpdate(ctx context.Context, spreadsheetID string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := f.spreadsheet(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// requests apply all or nothing
+	working := s.clone()
+	replies := make([]*sheets.Response, len(req.Requests))
+	for i, request := range req.Requests {
+		if replies[i], err = working.apply(request); err != nil {
+			return nil, err
+		}
+	}
+	f.spreadsheets[spreadsheetID] = working
+
+	resp := &sheets.BatchUpdateSpreadsheetResponse{SpreadsheetId: spreadsheetID, Replies: replies}
+	if req.IncludeSpreadsheetInResponse {
+		if resp.UpdatedSpreadsheet, err = working.snapshot(nil, false); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (f *Fake) GetValues(ctx context.Context, spreadsheetID string, cellRange string) (*sheets.ValueRange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := f.spreadsheet(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	return s.values(cellRange, "ROWS")
+}
+
+func (f *Fake) BatchGetValues(ctx context.Context, spreadsheetID string, ranges []string, majorDimension string) (*sheets.BatchGetValuesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := f.spreadsheet(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &sheets.BatchGetValuesResponse{SpreadsheetId: spreadsheetID}
+	for _, r := range ranges {
+		values, err := s.values(r, majorDimension)
+		if err != nil {
+			return nil, err
+		}
+		resp.ValueRanges = append(resp.ValueRanges, values)
+	}
+	return resp, nil
+}
+
+func (f *Fake) AppendValues(ctx context.Context, spreadsheetID string, cellRange string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := f.spreadsheet(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	sh, r, err := s.parseRange(cellRange)
+	if err != nil {
+		return nil, err
+	}
+
+	// rows go below the last row with anything in it
+	r.startRow = len(sh.rows) + 1
+	for r.startRow > 1 && sh.rowEmpty(r.startRow-1) {
+		r.startRow--
+	}
+
+	updated := sh.write(r.startRow, r.startCol, values.Values)
+	return &sheets.AppendValuesResponse{
+		SpreadsheetId: spreadsheetID,
+		TableRange:    quote(sh.props.Title),
+		Updates:       updated,
+	}, nil
+}
+
+func (f *Fake) UpdateValues(ctx context.Context, spreadsheetID string, cellRange string, values *sheets.ValueRange) (*sheets.UpdateValuesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := f.spreadsheet(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	sh, r, err := s.parseRange(cellRange)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := sh.write(r.startRow, r.startCol, values.Values)
+	updated.SpreadsheetId = spreadsheetID
+	return updated, nil
+}
+
+func (f *Fake) BatchUpdateValues(ctx context.Context, spreadsheetID string, req *sheets.BatchUpdateValuesRequest) (*sheets.BatchUpdateValuesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := f.spreadsheet(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// check every range before writing any
+	targets := make([]*sheet, len(req.Data))
+	ranges := make([]gridRange, len(req.Data))
+	for i, data := range req.Data {
+		if targets[i], ranges[i], err = s.parseRange(data.Range); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &sheets.BatchUpdateValuesResponse{SpreadsheetId: spreadsheetID}
+	for i, data := range req.Data {
+		updated := targets[i].write(ranges[i].startRow, ranges[i].startCol, data.Values)
+		resp.Responses = append(resp.Responses, updated)
+		resp.TotalUpdatedCells += updated.UpdatedCells
+	}
+	return resp, nil
+}
+
+// Values returns the formatted values of a sheet, as Values.Get of the whole
+// sheet would, or nil when there is no such sheet.
+func (f *Fake) Values(spreadsheetID string, sheetTitle string) [][]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.spreadsheets[spreadsheetID]
+	if !ok {
+		return nil
+	}
+
+	values, err := s.values(quote(sheetTitle), "ROWS")
+	if err != nil {
+		return nil
+	}
+	return values.Values
+}
+
+// Sheet returns the properties of a sheet, or nil when there is no such sheet.
+func (f *Fake) Sheet(spreadsheetID string, sheetTitle string) *sheets.SheetProperties {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.spreadsheets[spreadsheetID]
+	if !ok {
+		return nil
+	}
+	if sh := s.sheetByTitle(sheetTitle); sh != nil {
+		return cloneProperties(sh.props)
+	}
+	return nil
+}
+
+// Format returns the format of a cell, by 1-based row and column, or nil when
+// it has none.
+func (f *Fake) Format(spreadsheetID string, sheetTitle string, row int, column int) *sheets.CellFormat {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.spreadsheets[spreadsheetID]
+	if !ok {
+		return nil
+	}
+
+	sh := s.sheetByTitle(sheetTitle)
+	if sh == nil || row > len(sh.rows) || column > len(sh.rows[row-1]) {
+		return nil
+	}
+	if c := sh.rows[row-1][column-1]; c != nil {
+		return c.format
+	}
+	return nil
+}
+
+// SpreadsheetIDs returns the IDs of the spreadsheets created so far.
+func (f *Fake) SpreadsheetIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := []string{}
+	for i := 1; i <= f.created; i++ {
+		ids = append(ids, fmt.Sprintf("spreadsheet-%d", i))
+	}
+	return ids
+}
+
+func (f *Fake) spreadsheet(id string) (*spreadsheet, error) {
+	s, ok := f.spreadsheets[id]
+	if !ok {
+		return nil, notFound("Requested entity was not found.")
+	}
+	return s, nil
+}
+
+func (s *spreadsheet) clone() *spreadsheet {
+	c := &spreadsheet{id: s.id, title: s.title, nextID: s.nextID}
+	for _, m := range s.metadata {
+		copied := *m
+		c.metadata = append(c.metadata, &copied)
+	}
+	for _, sh := range s.sheets {
+		copied := &sheet{props: cloneProperties(sh.props)}
+		for _, row := range sh.rows {
+			cells := make([]*cell, len(row))
+			for i, c := range row {
+				if c != nil {
+					copied := *c
+					cells[i] = &copied
+				}
+			}
+			copied.rows = append(copied.rows, cells)
+		}
+		c.sheets = append(c.sheets, copied)
+	}
+	return c
+}
+
+func cloneProperties(props *sheets.SheetProperties) *sheets.SheetProperties {
+	c := *props
+	if props.GridProperties != nil {
+		grid := *props.GridProperties
+		c.GridProperties = &grid
+	}
+	return &c
+}
+
+func (s *spreadsheet) sheetByTitle(title string) *sheet {
+	for _, sh := range s.sheets {
+		if sh.props.Title == title {
+			return sh
+		}
+	}
+	return nil
+}
+
+func (s *spreadsheet) sheetByID(id int64) *sheet {
+	for _, sh := range s.sheets {
+		if sh.props.SheetId == id {
+			return sh
+		}
+	}
+	return nil
+}
+
+func (s *spreadsheet) addSheet(props *sheets.SheetProperties) (*sheets.SheetProperties, error) {
+	if props.Title == "" {
+		props.Title = fmt.Sprintf("Sheet%d", len(s.sheets)+1)
+	}
+	if s.sheetByTitle(props.Title) != nil {
+		return nil, badRequest("Invalid requests[0].addSheet: A sheet with the name \"%s\" already exists. Please enter another name.", props.Title)
+	}
+
+	if props.SheetId == 0 && len(s.sheets) > 0 {
+		s.nextID++
+		props.SheetId = s.nextID
+	}
+	if s.sheetByID(props.SheetId) != nil {
+		return nil, badRequest("Invalid requests[0].addSheet: Sheet with id %d already exists.", props.SheetId)
+	}
+
+	if props.GridProperties == nil {
+		props.GridProperties = &sheets.GridProperties{}
+	}
+	if props.GridProperties.RowCount == 0 {
+		props.GridProperties.RowCount = defaultRowCount
+	}
+	if props.GridProperties.ColumnCount == 0 {
+		props.GridProperties.ColumnCount = defaultColumnCount
+	}
+	props.SheetType = "GRID"
+	props.Index = int64(len(s.sheets))
+
+	s.sheets = append(s.sheets, &sheet{props: props})
+	return cloneProperties(props), nil
+}
+
+// apply carries out one request of a batch update.
+func (s *spreadsheet) apply(request *sheets.Request) (*sheets.Response, error) {
+	switch {
+	case request.AddSheet != nil:
+		props := &sheets.SheetProperties{}
+		if request.AddSheet.Properties != nil {
+			props = cloneProperties(request.AddSheet.Properties)
+		}
+		added, err := s.addSheet(props)
+		if err != nil {
+			return nil, err
+		}
+		return &sheets.Response{AddSheet: &sheets.AddSheetResponse{Properties: added}}, nil
+
+	case request.DeleteSheet != nil:
+		for i, sh := range s.sheets {
+			if sh.props.SheetId == request.DeleteSheet.SheetId {
+				if len(s.sheets) == 1 {
+					return nil, badRequest("Invalid requests[0].deleteSheet: You can't remove all the sheets in a document.")
+				}
+				s.sheets = append(s.sheets[:i], s.sheets[i+1:]...)
+				for j, rest := range s.sheets {
+					rest.props.Index = int64(j)
+				}
+				return &sheets.Response{}, nil
+			}
+		}
+		return nil, badRequest("Invalid requests[0].deleteSheet: No grid with id: %d", request.DeleteSheet.SheetId)
+
+	case request.UpdateSheetProperties != nil:
+		return &sheets.Response{}, s.updateProperties(request.UpdateSheetProperties)
+
+	case request.RepeatCell != nil:
+		return &sheets.Response{}, s.repeatCell(request.RepeatCell)
+
+	case request.UpdateCells != nil:
+		return &sheets.Response{}, s.updateCells(request.UpdateCells)
+
+	case request.DeleteDimension != nil:
+		return &sheets.Response{}, s.deleteDimension(request.DeleteDimension)
+
+	case request.CreateDeveloperMetadata != nil:
+		metadata := *request.CreateDeveloperMetadata.DeveloperMetadata
+		metadata.MetadataId = int64(len(s.metadata) + 1)
+		s.metadata = append(s.metadata, &metadata)
+		return &sheets.Response{CreateDeveloperMetadata: &sheets.CreateDeveloperMetadataResponse{DeveloperMetadata: &metadata}}, nil
+
+	default:
+		return nil, badRequest("sheetstest: unsupported request")
+	}
+}
+
+func (s *spreadsheet) updateProperties(request *sheets.UpdateSheetPropertiesRequest) error {
+	sh := s.sheetByID(request.Properties.SheetId)
+	if sh == nil {
+		return badRequest("Invalid requests[0].updateSheetProperties: No grid with id: %d", request.Properties.SheetId)
+	}
+
+	for _, field := range strings.Split(request.Fields, ",") {
+		switch strings.TrimSpace(field) {
+		case "title":
+			if other := s.sheetByTitle(request.Properties.Title); other != nil && other != sh {
+				return badRequest("Invalid requests[0].updateSheetProperties: A sheet with the name \"%s\" already exists.", request.Properties.Title)
+			}
+			sh.props.Title = request.Properties.Title
+		case "gridProperties.frozenRowCount":
+			sh.props.GridProperties.FrozenRowCount = gridProperties(request.Properties).FrozenRowCount
+		case "gridProperties.frozenColumnCount":
+			sh.props.GridProperties.FrozenColumnCount = gridProperties(request.Properties).FrozenColumnCount
+		default:
+			return badRequest("sheetstest: unsupported sheet property %q", field)
+		}
+	}
+	return nil
+}
+
+func gridProperties(props *sheets.SheetProperties) *sheets.GridProperties {
+	if props.GridProperties == nil {
+		return &sheets.GridProperties{}
+	}
+	return props.GridProperties
+}
+
+func (s *spreadsheet) repeatCell(request *sheets.RepeatCellRequest) error {
+	r := request.Range
+	sh := s.sheetByID(r.SheetId)
+	if sh == nil {
+		return badRequest("Invalid requests[0].repeatCell: No grid with id: %d", r.SheetId)
+	}
+
+	endRow, endCol := r.EndRowIndex, r.EndColumnIndex
+	if endRow == 0 {
+		endRow = sh.props.GridProperties.RowCount
+	}
+	if endCol == 0 {
+		endCol = sh.props.GridProperties.ColumnCount
+	}
+
+	for row := r.StartRowIndex; row < endRow; row++ {
+		for col := r.StartColumnIndex; col < endCol; col++ {
+			c := sh.cell(int(row)+1, int(col)+1)
+			if request.Cell != nil {
+				c.format = request.Cell.UserEnteredFormat
+			}
+		}
+	}
+	return nil
+}
+
+func (s *spreadsheet) updateCells(request *sheets.UpdateCellsRequest) error {
+	if request.Start == nil {
+		return badRequest("sheetstest: updateCells needs a start")
+	}
+
+	sh := s.sheetByID(request.Start.SheetId)
+	if sh == nil {
+		return badRequest("Invalid requests[0].updateCells: No grid with id: %d", request.Start.SheetId)
+	}
+
+	for i, row := range request.Rows {
+		for j, data := range row.Values {
+			c := sh.cell(int(request.Start.RowIndex)+i+1, int(request.Start.ColumnIndex)+j+1)
+			c.value = extendedValue(data.UserEnteredValue)
+			c.format = data.UserEnteredFormat
+		}
+	}
+	return nil
+}
+
+func (s *spreadsheet) deleteDimension(request *sheets.DeleteDimensionRequest) error {
+	r := request.Range
+	sh := s.sheetByID(r.SheetId)
+	if sh == nil {
+		return badRequest("Invalid requests[0].deleteDimension: No grid with id: %d", r.SheetId)
+	}
+	if r.Dimension != "ROWS" {
+		return badRequest("sheetstest: only rows are deleted")
+	}
+	if r.StartIndex >= r.EndIndex || r.EndIndex > sh.props.GridProperties.RowCount {
+		return badRequest("Invalid requests[0].deleteDimension: Invalid range")
+	}
+
+	start, end := int(r.StartIndex), int(r.EndIndex)
+	if start < len(sh.rows) {
+		if end > len(sh.rows) {
+			end = len(sh.rows)
+		}
+		sh.rows = append(sh.rows[:start], sh.rows[end:]...)
+	}
+	sh.props.GridProperties.RowCount -= r.EndIndex - r.StartIndex
+	return nil
+}
+
+func extendedValue(v *sheets.ExtendedValue) interface{} {
+	switch {
+	case v == nil:
+		return nil
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.NumberValue != nil:
+		return *v.NumberValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.FormulaValue != nil:
+		return *v.FormulaValue
+	default:
+		return nil
+	}
+}
+
+func toExtendedValue(value interface{}) *sheets.ExtendedValue {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case float64:
+		return &sheets.ExtendedValue{NumberValue: &v}
+	case bool:
+		return &sheets.ExtendedValue{BoolValue: &v}
+	default:
+		s := fmt.Sprint(formatted(v))
+		return &sheets.ExtendedValue{StringValue: &s}
+	}
+}
+
+// snapshot returns the spreadsheet as the API does, with grid data for
+// ranges when includeGridData is set.
+func (s *spreadsheet) snapshot(ranges []string, includeGridData bool) (*sheets.Spreadsheet, error) {
+	result := &sheets.Spreadsheet{
+		SpreadsheetId:  s.id,
+		SpreadsheetUrl: fmt.Sprintf("https://docs.google.com/spreadsheets/d/%s/edit", s.id),
+		Properties:     &sheets.SpreadsheetProperties{Title: s.title},
+	}
+	for _, m := range s.metadata {
+		copied := *m
+		result.DeveloperMetadata = append(result.DeveloperMetadata, &copied)
+	}
+
+	data := map[*sheet][]*sheets.GridData{}
+	if includeGridData {
+		for _, cellRange := range ranges {
+			sh, r, err := s.parseRange(cellRange)
+			if err != nil {
+				return nil, err
+			}
+			data[sh] = append(data[sh], sh.gridData(r))
+		}
+	}
+
+	for _, sh := range s.sheets {
+		result.Sheets = append(result.Sheets, &sheets.Sheet{Properties: cloneProperties(sh.props), Data: data[sh]})
+	}
+	return result, nil
+}
+
+// values reads a range as Values.Get does, dropping trailing empty rows and
+// cells.
+func (s *spreadsheet) values(cellRange string, majorDimension string) (*sheets.ValueRange, error) {
+	sh, r, err := s.parseRange(cellRange)
+	if err != nil {
+		return nil, err
+	}
+
+	endRow, endCol := r.endRow, r.endCol
+	if endRow == 0 || endRow > len(sh.rows) {
+		endRow = len(sh.rows)
+	}
+	if endCol == 0 {
+		for _, row := range sh.rows {
+			if len(row) > endCol {
+				endCol = len(row)
+			}
+		}
+	}
+
+	grid := [][]interface{}{}
+	for row := r.startRow; row <= endRow; row++ {
+		values := []interface{}{}
+		for col := r.startCol; col <= endCol; col++ {
+			values = append(values, sh.value(row, col))
+		}
+		grid = append(grid, values)
+	}
+
+	if majorDimension == "COLUMNS" {
+		columns := [][]interface{}{}
+		for col := 0; col <= endCol-r.startCol; col++ {
+			values := []interface{}{}
+			for _, row := range grid {
+				values = append(values, row[col])
+			}
+			columns = append(columns, values)
+		}
+		grid = columns
+	} else {
+		majorDimension = "ROWS"
+	}
+
+	return &sheets.ValueRange{Range: cellRange, MajorDimension: majorDimension, Values: trim(grid)}, nil
+}
+
+func trim(grid [][]interface{}) [][]interface{} {
+	for i, line := range grid {
+		end := len(line)
+		for end > 0 && line[end-1] == "" {
+			end--
+		}
+		grid[i] = line[:end]
+	}
+
+	end := len(grid)
+	for end > 0 && len(grid[end-1]) == 0 {
+		end--
+	}
+	return grid[:end]
+}
+
+// gridData returns the cells of r with their values and formats.
+func (sh *sheet) gridData(r gridRange) *sheets.GridData {
+	data := &sheets.GridData{StartRow: int64(r.startRow - 1), StartColumn: int64(r.startCol - 1)}
+
+	endRow, endCol := r.endRow, r.endCol
+	if endRow == 0 || endRow > len(sh.rows) {
+		endRow = len(sh.rows)
+	}
+	for row := r.startRow; row <= endRow; row++ {
+		cells := sh.rows[row-1]
+		last := len(cells)
+		if endCol != 0 && endCol < last {
+			last = endCol
+		}
+
+		rowData := &sheets.RowData{}
+		for col := r.startCol; col <= last; col++ {
+			cellData := &sheets.CellData{}
+			if c := cells[col-1]; c != nil {
+				cellData.UserEnteredValue = toExtendedValue(c.value)
+				cellData.UserEnteredFormat = c.format
+			}
+			rowData.Values = append(rowData.Values, cellData)
+		}
+		data.RowData = append(data.RowData, rowData)
+	}
+	return data
+}
+
+// cell returns the cell at row and column, growing the sheet to hold it.
+func (sh *sheet) cell(row int, column int) *cell {
+	for len(sh.rows) < row {
+		sh.rows = append(sh.rows, nil)
+	}
+	for len(sh.rows[row-1]) < column {
+		sh.rows[row-1] = append(sh.rows[row-1], nil)
+	}
+
+	grid := sh.props.GridProperties
+	if int64(row) > grid.RowCount {
+		grid.RowCount = int64(row)
+	}
+	if int64(column) > grid.ColumnCount {
+		grid.ColumnCount = int64(column)
+	}
+
+	if sh.rows[row-1][column-1] == nil {
+		sh.rows[row-1][column-1] = &cell{}
+	}
+	return sh.rows[row-1][column-1]
+}
+
+func (sh *sheet) value(row int, column int) interface{} {
+	if row > len(sh.rows) || column > len(sh.rows[row-1]) {
+		return ""
+	}
+	if c := sh.rows[row-1][column-1]; c != nil {
+		return formatted(c.value)
+	}
+	return ""
+}
+
+func (sh *sheet) rowEmpty(row int) bool {
+	for col := range sh.rows[row-1] {
+		if sh.value(row, col+1) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// write puts values at row and column as entered, skipping nil values like
+// the API skips nulls.
+func (sh *sheet) write(row int, column int, values [][]interface{}) *sheets.UpdateValuesResponse {
+	updated := &sheets.UpdateValuesResponse{}
+	width := 0
+
+	for i, line := range values {
+		for j, value := range line {
+			if value == nil {
+				continue
+			}
+			sh.cell(row+i, column+j).value = value
+			updated.UpdatedCells++
+		}
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+
+	if len(values) > 0 && width > 0 {
+		updated.UpdatedRows = int64(len(values))
+		updated.UpdatedColumns = int64(width)
+		updated.UpdatedRange = fmt.Sprintf("%s!%s%d:%s%d", quote(sh.props.Title), columnName(column), row, columnName(column+width-1), row+len(values)-1)
+	}
+	return updated
+}
+
+// formatted is a value as Values.Get returns it by default.
+func formatted(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func quote(title string) string {
+	return "'" + strings.ReplaceAll(title, "'", "''") + "'"
+}
+
+// columnName is the A1 name of a 1-based column.
+func columnName(column int) string {
+	name := ""
+	for column > 0 {
+		column--
+		name = string(rune('A'+column%26)) + name
+		column /= 26
+	}
+	return name
+}
@@ -0,0 +1,72 @@
+package sheetstest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestParseCells(t *testing.T) {
+	for cells, want := range map[string]gridRange{
+		"":            {startRow: 1, startCol: 1},
+		"1:1":         {startRow: 1, startCol: 1, endRow: 1},
+		"A1":          {startRow: 1, startCol: 1, endRow: 1, endCol: 1},
+		"B2:AA10":     {startRow: 2, startCol: 2, endRow: 10, endCol: 27},
+		"C:C":         {startRow: 1, startCol: 3, endCol: 3},
+		"R5C3":        {startRow: 5, startCol: 3, endRow: 5, endCol: 3},
+		"R1C1:R1C5":   {startRow: 1, startCol: 1, endRow: 1, endCol: 5},
+		"R2C3:C3":     {startRow: 2, startCol: 3, endCol: 3},
+		"R1C1:R2C2":   {startRow: 1, startCol: 1, endRow: 2, endCol: 2},
+		"NOT A RANGE": {},
+	} {
+		got, ok := parseCells(cells)
+		if want == (gridRange{}) {
+			require.False(t, ok, cells)
+			continue
+		}
+		require.True(t, ok, cells)
+		require.Equal(t, want, got, cells)
+	}
+}
+
+func TestValues(t *testing.T) {
+	ctx := context.Background()
+	f := New()
+
+	s, err := f.CreateSpreadsheet(ctx, &sheets.Spreadsheet{Properties: &sheets.SpreadsheetProperties{Title: "answers"}})
+	require.NoError(t, err)
+
+	_, err = f.BatchUpdate(ctx, s.SpreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: "it's"}}}},
+	})
+	require.NoError(t, err)
+
+	resp, err := f.AppendValues(ctx, s.SpreadsheetId, "'it''s'", &sheets.ValueRange{Values: [][]interface{}{{"a", 1.5, true}, {"b", nil, ""}}})
+	require.NoError(t, err)
+	require.Equal(t, "'it''s'!A1:C2", resp.Updates.UpdatedRange)
+
+	resp, err = f.AppendValues(ctx, s.SpreadsheetId, "'it''s'!R1C2:R1C3", &sheets.ValueRange{Values: [][]interface{}{{"c"}}})
+	require.NoError(t, err)
+	require.Equal(t, "'it''s'!B3:B3", resp.Updates.UpdatedRange)
+
+	require.Equal(t, [][]interface{}{{"a", "1.5", "TRUE"}, {"b"}, {"", "c"}}, f.Values(s.SpreadsheetId, "it's"))
+
+	columns, err := f.BatchGetValues(ctx, s.SpreadsheetId, []string{"'it''s'!R2C1:C1"}, "COLUMNS")
+	require.NoError(t, err)
+	require.Equal(t, [][]interface{}{{"b"}}, columns.ValueRanges[0].Values)
+
+	// nothing of a failing batch is applied
+	_, err = f.BatchUpdate(ctx, s.SpreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{DeleteDimension: &sheets.DeleteDimensionRequest{Range: &sheets.DimensionRange{SheetId: 1, Dimension: "ROWS", StartIndex: 0, EndIndex: 1}}},
+			{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: 42}},
+		},
+	})
+	require.Error(t, err)
+	require.Len(t, f.Values(s.SpreadsheetId, "it's"), 3)
+
+	_, err = f.GetValues(ctx, s.SpreadsheetId, "'missing'!A1")
+	require.Error(t, err)
+}
@@ -0,0 +1,248 @@
+package google
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultLoginTTL is how long a user has to get through Google's consent
+// screen once the login started.
+const DefaultLoginTTL = 10 * time.Minute
+
+var (
+	ErrInvalidLoginState = errors.New("invalid or expired login state")
+	ErrInvalidReturnURL  = errors.New("return url is not allowed")
+	ErrMissingLoginOrg   = errors.New("a login needs the organization its token is for")
+)
+
+// LoginState is carried through Google's consent screen and back to the
+// callback, signed so that it can't be forged or reused once it expired.
+type LoginState struct {
+	OrgID     string `json:"org_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	ReturnURL string `json:"return_url,omitempty"`
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Login is a started login. URL is where the user is sent to, and Session has
+// to come back with the callback from the same browser, e.g. in a cookie: it
+// holds the PKCE code verifier and ties the state to the login that made it.
+type Login struct {
+	URL     string
+	Session string
+	Expires time.Time
+}
+
+// WithLoginStateKey signs login states with key. Every instance behind the same
+// callback URL needs the same key.
+func WithLoginStateKey(key []byte) ClientOption {
+	return func(g *GoogleClient) {
+		if len(key) > 0 {
+			g.stateKey = key
+		}
+	}
+}
+
+// WithLoginTTL sets how long a login can take, see DefaultLoginTTL.
+func WithLoginTTL(ttl time.Duration) ClientOption {
+	return func(g *GoogleClient) {
+		if ttl > 0 {
+			g.loginTTL = ttl
+		}
+	}
+}
+
+// WithConsentPrompt sets the prompt Google is asked to show, e.g. "consent" or
+// "select_account consent". Google only hands out a refresh token again with
+// "consent"; empty leaves it to Google.
+func WithConsentPrompt(prompt string) ClientOption {
+	return func(g *GoogleClient) {
+		g.prompt = prompt
+	}
+}
+
+// WithReturnURLs allows logins to return to absolute URLs with the scheme and
+// host of one of urls and a path under its path. Paths on the connector's own
+// host are always allowed.
+func WithReturnURLs(urls ...string) ClientOption {
+	return func(g *GoogleClient) {
+		for _, raw := range urls {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			if u, err := url.Parse(raw); err == nil && u.IsAbs() && u.Host != "" {
+				g.returnURLs = append(g.returnURLs, u)
+			}
+		}
+	}
+}
+
+// randomString returns n random bytes, base64url encoded.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge is the S256 PKCE challenge of verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (g *GoogleClient) allowedReturnURL(returnURL string) bool {
+	if returnURL == "" {
+		return true
+	}
+
+	u, err := url.Parse(returnURL)
+	if err != nil {
+		return false
+	}
+
+	// "//host" and "\\host" are taken as other hosts by browsers
+	if !u.IsAbs() && u.Host == "" && strings.HasPrefix(returnURL, "/") && !strings.HasPrefix(returnURL, "//") && !strings.HasPrefix(returnURL, "/\\") {
+		return true
+	}
+
+	if u.User != nil {
+		return false
+	}
+
+	for _, allowed := range g.returnURLs {
+		if u.Scheme == allowed.Scheme && strings.EqualFold(u.Host, allowed.Host) && underPath(u.Path, allowed.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// underPath reports whether p is dir or lies below it once "." and ".."
+// are resolved, as a browser would.
+func underPath(p string, dir string) bool {
+	p = path.Clean("/" + p)
+	dir = strings.TrimSuffix(path.Clean("/"+dir), "/")
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// sign encodes state as its base64url JSON and the HMAC-SHA256 of that.
+func (g *GoogleClient) sign(state *LoginState) (string, error) {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, g.stateKey)
+	mac.Write([]byte(encoded))
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify returns the state signed into value if it hasn't expired.
+func (g *GoogleClient) verify(value string) (*LoginState, error) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidLoginState
+	}
+
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrInvalidLoginState
+	}
+
+	mac := hmac.New(sha256.New, g.stateKey)
+	mac.Write([]byte(encoded))
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrInvalidLoginState
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidLoginState
+	}
+
+	state := &LoginState{}
+	if err := json.Unmarshal(payload, state); err != nil {
+		return nil, ErrInvalidLoginState
+	}
+	if time.Now().Unix() > state.ExpiresAt {
+		return nil, ErrInvalidLoginState
+	}
+	return state, nil
+}
+
+// HandleGoogleLogin starts a login on behalf of the organisation and user in
+// state, returning to its ReturnURL when done. The consent screen asks for
+// offline access so that the connector gets a refresh token.
+func (g *GoogleClient) HandleGoogleLogin(state LoginState) (*Login, error) {
+	if state.OrgID == "" {
+		return nil, ErrMissingLoginOrg
+	}
+	if !g.allowedReturnURL(state.ReturnURL) {
+		return nil, ErrInvalidReturnURL
+	}
+
+	nonce, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(g.loginTTL)
+	state.Nonce = nonce
+	state.ExpiresAt = expires.Unix()
+
+	signed, err := g.sign(&state)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+	if g.prompt != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", g.prompt))
+	}
+
+	return &Login{
+		URL:     g.config.AuthCodeURL(signed, opts...),
+		Session: nonce + "." + verifier,
+		Expires: expires,
+	}, nil
+}
+
+// verifyLogin checks that the state the callback came back with is one we
+// signed for the login of session, and returns it with the code verifier.
+func (g *GoogleClient) verifyLogin(value string, session string) (*LoginState, string, error) {
+	state, err := g.verify(value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nonce, verifier, ok := strings.Cut(session, ".")
+	if !ok || subtle.ConstantTimeCompare([]byte(nonce), []byte(state.Nonce)) != 1 {
+		return nil, "", ErrInvalidLoginState
+	}
+	return state, verifier, nil
+}
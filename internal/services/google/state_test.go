@@ -0,0 +1,86 @@
+package google
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginStateRoundTrip(t *testing.T) {
+	g := NewGoogleClient("client", "secret", []string{"scope"}, "https://connector.example.com/callback", nil, WithLoginStateKey([]byte("key")))
+
+	login, err := g.HandleGoogleLogin(LoginState{OrgID: "org", UserID: "user", ReturnURL: "/done"})
+	require.NoError(t, err)
+
+	u, err := url.Parse(login.URL)
+	require.NoError(t, err)
+	query := u.Query()
+	require.Equal(t, "offline", query.Get("access_type"))
+	require.Equal(t, "consent", query.Get("prompt"))
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	_, verifier, _ := strings.Cut(login.Session, ".")
+	require.Equal(t, codeChallenge(verifier), query.Get("code_challenge"))
+
+	state, gotVerifier, err := g.verifyLogin(query.Get("state"), login.Session)
+	require.NoError(t, err)
+	require.Equal(t, verifier, gotVerifier)
+	require.Equal(t, "org", state.OrgID)
+	require.Equal(t, "user", state.UserID)
+	require.Equal(t, "/done", state.ReturnURL)
+
+	// the state of another login
+	other, err := g.HandleGoogleLogin(LoginState{OrgID: "org"})
+	require.NoError(t, err)
+	_, _, err = g.verifyLogin(query.Get("state"), other.Session)
+	require.ErrorIs(t, err, ErrInvalidLoginState)
+
+	// signed with another key
+	forger := NewGoogleClient("client", "secret", nil, "", nil, WithLoginStateKey([]byte("other")))
+	forged, err := forger.sign(&LoginState{OrgID: "org", Nonce: state.Nonce, ExpiresAt: state.ExpiresAt})
+	require.NoError(t, err)
+	_, _, err = g.verifyLogin(forged, login.Session)
+	require.ErrorIs(t, err, ErrInvalidLoginState)
+
+	expired, err := g.sign(&LoginState{Nonce: state.Nonce, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	require.NoError(t, err)
+	_, _, err = g.verifyLogin(expired, login.Session)
+	require.ErrorIs(t, err, ErrInvalidLoginState)
+
+	// the token it ends with is bound to the organization
+	_, err = g.HandleGoogleLogin(LoginState{UserID: "user"})
+	require.ErrorIs(t, err, ErrMissingLoginOrg)
+}
+
+func TestLoginReturnURL(t *testing.T) {
+	g := NewGoogleClient("client", "secret", nil, "", nil, WithReturnURLs("https://app.example.com/", "https://admin.example.com/connect"))
+
+	for returnURL, allowed := range map[string]bool{
+		"":                                          true,
+		"/integrations":                             true,
+		"https://app.example.com/settings":          true,
+		"https://APP.example.com/settings":          true,
+		"https://admin.example.com/connect":         true,
+		"https://admin.example.com/connect/done":    true,
+		"https://evil.example.com/":                 false,
+		"https://app.example.com.evil/":             false,
+		"https://app.example.com@evil.example.com/": false,
+		"https://app.example.com:8443/":             false,
+		"http://app.example.com/":                   false,
+		"https://admin.example.com/connected":       false,
+		"https://admin.example.com/connect/../app":  false,
+		"//evil.example.com/":                       false,
+		"/\\evil.example.com/":                      false,
+		"javascript:alert(1)":                       false,
+	} {
+		_, err := g.HandleGoogleLogin(LoginState{OrgID: "org", ReturnURL: returnURL})
+		if allowed {
+			require.NoError(t, err, returnURL)
+		} else {
+			require.ErrorIs(t, err, ErrInvalidReturnURL, returnURL)
+		}
+	}
+}
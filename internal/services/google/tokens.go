@@ -0,0 +1,354 @@
+package google
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrTokenNotFound   = errors.New("token not found")
+	ErrUnknownTokenKey = errors.New("token was encrypted with an unknown key")
+	ErrNoTokenStore    = errors.New("no token store configured")
+	ErrTokenNotOwned   = errors.New("token was issued for another organization")
+	// ErrSubjectNotAllowed is returned for a subject a service account key
+	// wasn't stored to act as.
+	ErrSubjectNotAllowed = errors.New("service account key may not act as the subject")
+	ErrMissingKeyOrg     = errors.New("a service account key needs the organization it is for")
+)
+
+// TokenBackend stores sealed tokens by their ID. GetToken returns nil for an
+// ID it doesn't know.
+type TokenBackend interface {
+	GetToken(ctx context.Context, id string) ([]byte, error)
+	PutToken(ctx context.Context, id string, sealed []byte) error
+	DeleteToken(ctx context.Context, id string) error
+	TokenIDs(ctx context.Context) ([]string, error)
+}
+
+// TokenKey is an AES key tokens are encrypted with. The ID is stored with
+// every token it seals, so that the key can be found again after rotation.
+type TokenKey struct {
+	ID  string
+	Key []byte
+}
+
+// ParseTokenKeys parses keys given as a comma separated list of id:key pairs,
+// with base64 encoded 16, 24 or 32 byte keys. The first key is the one new
+// tokens are sealed with, the others are only kept to open older tokens.
+func ParseTokenKeys(spec string) ([]TokenKey, error) {
+	keys := []TokenKey{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("token key %q is not an id:key pair", pair)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("token key %s is not base64: %w", id, err)
+		}
+
+		keys = append(keys, TokenKey{ID: id, Key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no token keys configured")
+	}
+	return keys, nil
+}
+
+// what a sealed secret holds, OAuth tokens having none for compatibility
+const (
+	secretToken          = ""
+	secretServiceAccount = "service_account"
+)
+
+// TokenOwner is the organization and user a token was issued for. Tokens
+// stored before they had owners have none.
+type TokenOwner struct {
+	OrgID  string
+	UserID string
+}
+
+// Owns reports whether a token of owner may be used for orgID.
+func (o TokenOwner) Owns(orgID string) bool {
+	return o.OrgID == "" || o.OrgID == orgID
+}
+
+// sealedToken is how a token or service account key is kept at rest.
+type sealedToken struct {
+	KeyID  string `json:"kid"`
+	Kind   string `json:"kind,omitempty"`
+	OrgID  string `json:"org_id,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+	// Subjects are the users a service account key may act as.
+	Subjects   []string `json:"subjects,omitempty"`
+	Nonce      []byte   `json:"nonce"`
+	Ciphertext []byte   `json:"ciphertext"`
+}
+
+func (st *sealedToken) owner() TokenOwner {
+	return TokenOwner{OrgID: st.OrgID, UserID: st.UserID}
+}
+
+// TokenStore keeps OAuth tokens encrypted with AES-GCM, so that messages and
+// API calls only have to carry a token's ID. Tokens are keyed by whatever the
+// caller stores them under, e.g. an integration or a user.
+type TokenStore struct {
+	backend    TokenBackend
+	primary    string
+	aeads      map[string]cipher.AEAD
+	refreshing refreshLocks
+}
+
+// NewTokenStore seals new tokens with the first of keys and opens tokens
+// sealed with any of them, see ParseTokenKeys.
+func NewTokenStore(backend TokenBackend, keys []TokenKey) (*TokenStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no token keys configured")
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	for _, key := range keys {
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("token key %s: %w", key.ID, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads[key.ID] = aead
+	}
+
+	return &TokenStore{
+		backend: backend,
+		primary: keys[0].ID,
+		aeads:   aeads,
+	}, nil
+}
+
+// Create stores token of owner under a new random ID and returns it.
+func (s *TokenStore) Create(ctx context.Context, owner TokenOwner, token *oauth2.Token) (string, error) {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	id := uuid.NewString()
+	if err := s.put(ctx, id, sealedToken{Kind: secretToken, OrgID: owner.OrgID, UserID: owner.UserID}, plaintext); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Save stores token under id, replacing the token stored there and keeping
+// its owner.
+func (s *TokenStore) Save(ctx context.Context, id string, token *oauth2.Token) error {
+	_, owner, err := s.load(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, id, sealedToken{Kind: secretToken, OrgID: owner.OrgID, UserID: owner.UserID}, plaintext)
+}
+
+// Load returns the token stored under id.
+func (s *TokenStore) Load(ctx context.Context, id string) (*oauth2.Token, error) {
+	token, _, err := s.load(ctx, id)
+	return token, err
+}
+
+// Owner returns the owner of the token stored under id.
+func (s *TokenStore) Owner(ctx context.Context, id string) (TokenOwner, error) {
+	_, owner, err := s.load(ctx, id)
+	return owner, err
+}
+
+func (s *TokenStore) load(ctx context.Context, id string) (*oauth2.Token, TokenOwner, error) {
+	plaintext, st, err := s.get(ctx, id, secretToken)
+	if err != nil {
+		return nil, TokenOwner{}, err
+	}
+
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(plaintext, token); err != nil {
+		return nil, TokenOwner{}, err
+	}
+	return token, st.owner(), nil
+}
+
+// CreateServiceAccountKey stores the JSON key of a service account of owner,
+// as downloaded from the Google Cloud console, under a new random ID. The key
+// may act as the users in subjects through domain-wide delegation, and as no
+// one else.
+func (s *TokenStore) CreateServiceAccountKey(ctx context.Context, owner TokenOwner, key []byte, subjects []string) (string, error) {
+	if owner.OrgID == "" {
+		return "", ErrMissingKeyOrg
+	}
+
+	id := uuid.NewString()
+	header := sealedToken{Kind: secretServiceAccount, OrgID: owner.OrgID, UserID: owner.UserID, Subjects: subjects}
+	if err := s.put(ctx, id, header, key); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// LoadServiceAccountKey returns the service account key stored under id and
+// the subjects it may act as.
+func (s *TokenStore) LoadServiceAccountKey(ctx context.Context, id string) ([]byte, []string, error) {
+	key, st, err := s.get(ctx, id, secretServiceAccount)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, st.Subjects, nil
+}
+
+// put seals plaintext with what header says about it and stores it under id.
+func (s *TokenStore) put(ctx context.Context, id string, header sealedToken, plaintext []byte) error {
+	sealed, err := s.seal(id, header, plaintext)
+	if err != nil {
+		return err
+	}
+	return s.backend.PutToken(ctx, id, sealed)
+}
+
+// get opens the secret of the given kind stored under id. Secrets sealed with
+// an older key are only sealed again by Rotate, which can't overwrite a token
+// refreshed in the meantime.
+func (s *TokenStore) get(ctx context.Context, id string, kind string) ([]byte, *sealedToken, error) {
+	sealed, err := s.backend.GetToken(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sealed == nil {
+		return nil, nil, ErrTokenNotFound
+	}
+
+	plaintext, st, err := s.open(id, sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.Kind != kind {
+		return nil, nil, ErrTokenNotFound
+	}
+	return plaintext, st, nil
+}
+
+func (s *TokenStore) Delete(ctx context.Context, id string) error {
+	return s.backend.DeleteToken(ctx, id)
+}
+
+// Rotate seals every stored token that isn't sealed with the current key
+// again, after which the older keys can be dropped.
+func (s *TokenStore) Rotate(ctx context.Context) (int, error) {
+	ids, err := s.backend.TokenIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, id := range ids {
+		ok, err := s.rotate(ctx, id)
+		if err != nil {
+			return rotated, err
+		}
+		if ok {
+			rotated++
+		}
+	}
+	return rotated, nil
+}
+
+// rotate seals the secret stored under id again unless it is sealed with the
+// current key, holding off refreshes of it in between.
+func (s *TokenStore) rotate(ctx context.Context, id string) (bool, error) {
+	unlock := s.refreshing.lock(id)
+	defer unlock()
+
+	sealed, err := s.backend.GetToken(ctx, id)
+	if err != nil || sealed == nil {
+		return false, err
+	}
+
+	plaintext, st, err := s.open(id, sealed)
+	if err != nil {
+		return false, fmt.Errorf("token %s: %w", id, err)
+	}
+	if st.KeyID == s.primary {
+		return false, nil
+	}
+	return true, s.put(ctx, id, *st, plaintext)
+}
+
+// seal encrypts a secret with the current key. The ID, kind, owner and
+// subjects in header are authenticated with it, so a sealed secret can't be
+// passed off as another.
+func (s *TokenStore) seal(id string, header sealedToken, plaintext []byte) ([]byte, error) {
+	aead := s.aeads[s.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header.KeyID = s.primary
+	header.Nonce = nonce
+	header.Ciphertext = aead.Seal(nil, nonce, plaintext, additionalData(id, &header))
+	return json.Marshal(header)
+}
+
+func (s *TokenStore) open(id string, sealed []byte) ([]byte, *sealedToken, error) {
+	st := &sealedToken{}
+	if err := json.Unmarshal(sealed, st); err != nil {
+		return nil, nil, err
+	}
+
+	aead, ok := s.aeads[st.KeyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownTokenKey, st.KeyID)
+	}
+
+	plaintext, err := aead.Open(nil, st.Nonce, st.Ciphertext, additionalData(id, st))
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, st, nil
+}
+
+// additionalData is what a secret is authenticated with besides its content.
+// Tokens sealed before there were kinds only have their ID, those sealed
+// before there were owners their ID and kind.
+func additionalData(id string, st *sealedToken) []byte {
+	switch {
+	case len(st.Subjects) > 0:
+		return []byte(id + "\x00" + st.Kind + "\x00" + st.OrgID + "\x00" + st.UserID + "\x00" + strings.Join(st.Subjects, "\x00"))
+	case st.owner() != (TokenOwner{}):
+		return []byte(id + "\x00" + st.Kind + "\x00" + st.OrgID + "\x00" + st.UserID)
+	case st.Kind == secretToken:
+		return []byte(id)
+	default:
+		return []byte(id + "\x00" + st.Kind)
+	}
+}
@@ -0,0 +1,145 @@
+package google
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+type memoryTokens map[string][]byte
+
+func (m memoryTokens) GetToken(ctx context.Context, id string) ([]byte, error) { return m[id], nil }
+func (m memoryTokens) PutToken(ctx context.Context, id string, sealed []byte) error {
+	m[id] = sealed
+	return nil
+}
+func (m memoryTokens) DeleteToken(ctx context.Context, id string) error {
+	delete(m, id)
+	return nil
+}
+func (m memoryTokens) TokenIDs(ctx context.Context) ([]string, error) {
+	ids := []string{}
+	for id := range m {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func tokenKey(id string, b byte) TokenKey {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	return TokenKey{ID: id, Key: key}
+}
+
+func TestTokenStoreSealsTokens(t *testing.T) {
+	ctx := context.Background()
+	backend := memoryTokens{}
+
+	s, err := NewTokenStore(backend, []TokenKey{tokenKey("k1", 1)})
+	require.NoError(t, err)
+
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)}
+	id, err := s.Create(ctx, TokenOwner{OrgID: "org", UserID: "user"}, token)
+	require.NoError(t, err)
+	require.NotContains(t, string(backend[id]), "refresh")
+
+	loaded, err := s.Load(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, token, loaded)
+
+	owner, err := s.Owner(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, TokenOwner{OrgID: "org", UserID: "user"}, owner)
+	require.True(t, owner.Owns("org"))
+	require.False(t, owner.Owns("other"))
+
+	// refreshing keeps the owner
+	require.NoError(t, s.Save(ctx, id, &oauth2.Token{AccessToken: "fresh"}))
+	owner, err = s.Owner(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, "org", owner.OrgID)
+
+	// nor can the owner be swapped for another
+	sealed := backend[id]
+	backend[id] = []byte(strings.Replace(string(sealed), `"org_id":"org"`, `"org_id":"other"`, 1))
+	_, err = s.Load(ctx, id)
+	require.Error(t, err)
+	backend[id] = sealed
+
+	// a sealed token only opens under its own ID
+	backend["other"] = backend[id]
+	_, err = s.Load(ctx, "other")
+	require.Error(t, err)
+
+	require.NoError(t, s.Delete(ctx, id))
+	_, err = s.Load(ctx, id)
+	require.ErrorIs(t, err, ErrTokenNotFound)
+}
+
+func TestTokenStoreRotatesKeys(t *testing.T) {
+	ctx := context.Background()
+	backend := memoryTokens{}
+
+	old, err := NewTokenStore(backend, []TokenKey{tokenKey("k1", 1)})
+	require.NoError(t, err)
+
+	first, err := old.Create(ctx, TokenOwner{OrgID: "org"}, &oauth2.Token{AccessToken: "first"})
+	require.NoError(t, err)
+	second, err := old.Create(ctx, TokenOwner{}, &oauth2.Token{AccessToken: "second"})
+	require.NoError(t, err)
+
+	rotating, err := NewTokenStore(backend, []TokenKey{tokenKey("k2", 2), tokenKey("k1", 1)})
+	require.NoError(t, err)
+
+	// reading leaves the tokens as they are, they are sealed again by Rotate
+	_, err = rotating.Load(ctx, first)
+	require.NoError(t, err)
+	_, err = old.Load(ctx, first)
+	require.NoError(t, err)
+
+	rotated, err := rotating.Rotate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, rotated)
+
+	current, err := NewTokenStore(backend, []TokenKey{tokenKey("k2", 2)})
+	require.NoError(t, err)
+
+	for id, access := range map[string]string{first: "first", second: "second"} {
+		token, err := current.Load(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, access, token.AccessToken)
+	}
+
+	owner, err := current.Owner(ctx, first)
+	require.NoError(t, err)
+	require.Equal(t, "org", owner.OrgID)
+
+	_, err = old.Load(ctx, first)
+	require.ErrorIs(t, err, ErrUnknownTokenKey)
+}
+
+func TestParseTokenKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	keys, err := ParseTokenKeys("k2:" + key + ", k1:" + key)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, "k2", keys[0].ID)
+	require.Len(t, keys[0].Key, 32)
+
+	_, err = ParseTokenKeys("")
+	require.Error(t, err)
+
+	_, err = ParseTokenKeys("k1")
+	require.Error(t, err)
+
+	_, err = NewTokenStore(memoryTokens{}, []TokenKey{{ID: "short", Key: []byte("too short")}})
+	require.Error(t, err)
+}
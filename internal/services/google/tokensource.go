@@ -0,0 +1,87 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"golang.org/x/oauth2"
+)
+
+// TokenRevokedHook is told the ID of a stored token whose refresh token Google
+// no longer accepts, so that whatever depends on it can ask the user to
+// authorize the connector again.
+type TokenRevokedHook func(tokenID string)
+
+// ErrReauthorizationRequired is returned when Google refuses the refresh
+// token of a stored token. Unlike with a token passed along, writing is worth
+// retrying once the user authorized the connector again.
+var ErrReauthorizationRequired = errors.New("token was revoked, the user has to authorize the connector again")
+
+// refreshLocks serialises refreshes of the same stored token.
+type refreshLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (l *refreshLocks) lock(id string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sync.Mutex)
+	}
+	m, ok := l.locks[id]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[id] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// storedTokenSource refreshes the token stored under id and saves the new
+// token back, where config.TokenSource would keep it in memory only and the
+// next client would refresh all over again.
+type storedTokenSource struct {
+	ctx       context.Context
+	config    *oauth2.Config
+	tokens    *TokenStore
+	id        string
+	onRevoked TokenRevokedHook
+	logger    logger.AppLogger
+}
+
+func (s *storedTokenSource) Token() (*oauth2.Token, error) {
+	unlock := s.tokens.refreshing.lock(s.id)
+	defer unlock()
+
+	// someone else may have refreshed it while we waited
+	current, err := s.tokens.Load(s.ctx, s.id)
+	if err != nil {
+		return nil, err
+	}
+	if current.Valid() {
+		return current, nil
+	}
+
+	refreshed, err := s.config.TokenSource(s.ctx, current).Token()
+	if err != nil {
+		if !isInvalidGrant(err) {
+			return nil, err
+		}
+
+		if s.onRevoked != nil {
+			s.onRevoked(s.id)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrReauthorizationRequired, err)
+	}
+
+	if err := s.tokens.Save(s.ctx, s.id, refreshed); err != nil {
+		// the token still works, it just gets refreshed again next time
+		s.logger.Error("failed to save refreshed token :: stacktrace ::", err)
+	}
+	return refreshed, nil
+}
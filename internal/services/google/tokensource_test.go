@@ -0,0 +1,88 @@
+package google
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestStoredTokenSourceSavesRefreshedToken(t *testing.T) {
+	ctx := context.Background()
+
+	var refreshes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&refreshes, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"fresh","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	tokens, err := NewTokenStore(memoryTokens{}, []TokenKey{tokenKey("k1", 1)})
+	require.NoError(t, err)
+
+	id, err := tokens.Create(ctx, TokenOwner{OrgID: "org"}, &oauth2.Token{AccessToken: "stale", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+
+	config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL, AuthStyle: oauth2.AuthStyleInParams}}
+
+	// clients of the same token refresh it once between them
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s := &storedTokenSource{ctx: ctx, config: config, tokens: tokens, id: id}
+			token, err := s.Token()
+			require.NoError(t, err)
+			require.Equal(t, "fresh", token.AccessToken)
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, atomic.LoadInt32(&refreshes))
+
+	stored, err := tokens.Load(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, "fresh", stored.AccessToken)
+	require.Equal(t, "refresh", stored.RefreshToken)
+}
+
+func TestStoredTokenSourceReportsRevokedToken(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`))
+	}))
+	defer server.Close()
+
+	tokens, err := NewTokenStore(memoryTokens{}, []TokenKey{tokenKey("k1", 1)})
+	require.NoError(t, err)
+
+	id, err := tokens.Create(ctx, TokenOwner{OrgID: "org"}, &oauth2.Token{AccessToken: "stale", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+
+	revoked := ""
+	s := &storedTokenSource{
+		ctx:       ctx,
+		config:    &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: server.URL, AuthStyle: oauth2.AuthStyleInParams}},
+		tokens:    tokens,
+		id:        id,
+		onRevoked: func(tokenID string) { revoked = tokenID },
+	}
+
+	_, err = s.Token()
+	require.Error(t, err)
+	// the integrations using it are held until it is replaced
+	require.ErrorIs(t, err, ErrReauthorizationRequired)
+	require.False(t, IsPermanentError(err))
+	require.Equal(t, id, revoked)
+}
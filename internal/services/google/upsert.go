@@ -0,0 +1,138 @@
+package google
+
+import (
+	"errors"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+)
+
+var ErrMissingAnswerID = errors.New("answer_id is required to upsert an answer")
+
+// UpsertKeyField identifies a row in upsert mode.
+const UpsertKeyField = "answer_id"
+
+// UpsertVersionField holds when the answer in a row was last updated, so an
+// older version delivered late never overwrites a newer one.
+const UpsertVersionField = "updated_at"
+
+// UpsertColumnMapping returns the columns of a sheet in upsert mode. It is
+// the given mapping, or every field without one, with answer_id and
+// updated_at columns added when the mapping leaves them out.
+func UpsertColumnMapping(columns []model.ColumnMapping) []model.ColumnMapping {
+	if len(columns) == 0 {
+		columns = DefaultColumnMapping()
+	}
+	return withKeyColumns(columns, UpsertKeyField, UpsertVersionField)
+}
+
+// IsStale reports whether an answer updated at updatedAt is older than the
+// version in the updated_at cell of its row. Cells that don't hold a time,
+// e.g. because the column was formatted as a date, never make it stale.
+func IsStale(stored interface{}, updatedAt time.Time) bool {
+	text, ok := stored.(string)
+	if !ok || text == "" {
+		return false
+	}
+
+	version, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return false
+	}
+	// the cell holds whole seconds
+	return updatedAt.Truncate(time.Second).Before(version)
+}
+
+// upsertAnswers writes every answer to the row holding its AnswerID, and
+// appends the ones that have no row yet. When a batch holds the same answer
+// more than once, the version updated last wins; answers older than the
+// version already in their row are left out.
+func (gs *GoogleSheetClient) upsertAnswers(target Target, data []*model.QuestionnarieData) error {
+
+	for _, d := range data {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+		if d.AnswerID == nil || *d.AnswerID == "" {
+			return ErrMissingAnswerID
+		}
+	}
+
+	layout, err := gs.sheetLayout(target.SpreadSheetID, target.Sheet, target.columns())
+	if err != nil {
+		return err
+	}
+
+	keyColumn, _ := layout.field(UpsertKeyField)
+
+	index, err := gs.rowIndex(target.SpreadSheetID, target.Sheet, keyColumn)
+	if err != nil {
+		return err
+	}
+
+	rows := []*keyedRow{}
+	byKey := map[string]*keyedRow{}
+	updatedAt := map[string]time.Time{}
+
+	for _, d := range data {
+		cells := layout.cells(d)
+		key := layout.key(cells, []int{keyColumn})
+
+		row, ok := byKey[key]
+		if !ok || key == "" {
+			row = &keyedRow{key: key}
+			byKey[key] = row
+			rows = append(rows, row)
+		} else if d.UpdatedAt.Before(updatedAt[key]) {
+			continue
+		}
+
+		// every mapped cell is rewritten, so values cleared by an edit are
+		// cleared in the sheet too
+		row.cells = cells
+		updatedAt[key] = d.UpdatedAt
+	}
+
+	rows, err = gs.dropStale(target, layout, index, rows, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	return gs.putRows(target, len(layout.headers), index, rows)
+}
+
+// dropStale leaves out the rows whose answer is older than the version
+// stored in the sheet. The stored versions are only read when one of the
+// rows is already in the sheet.
+func (gs *GoogleSheetClient) dropStale(target Target, layout *sheetLayout, index map[string]int, rows []*keyedRow, updatedAt map[string]time.Time) ([]*keyedRow, error) {
+	versionColumn, ok := layout.field(UpsertVersionField)
+	if !ok {
+		return rows, nil
+	}
+
+	existing := false
+	for _, row := range rows {
+		if _, ok := index[row.key]; ok && row.key != "" {
+			existing = true
+			break
+		}
+	}
+	if !existing {
+		return rows, nil
+	}
+
+	values, _, err := gs.columnValues(target.SpreadSheetID, target.Sheet, versionColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := rows[:0]
+	for _, row := range rows {
+		number, ok := index[row.key]
+		if ok && row.key != "" && number-2 < len(values[0]) && IsStale(values[0][number-2], updatedAt[row.key]) {
+			continue
+		}
+		fresh = append(fresh, row)
+	}
+	return fresh, nil
+}
@@ -0,0 +1,44 @@
+package google
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpsertColumnMapping(t *testing.T) {
+	require.Equal(t, DefaultColumnMapping(), UpsertColumnMapping(nil))
+
+	columns := UpsertColumnMapping([]model.ColumnMapping{{Field: "answer", Header: "Answer"}})
+	require.Equal(t, []model.ColumnMapping{{Field: "answer_id"}, {Field: "updated_at"}, {Field: "answer", Header: "Answer"}}, columns)
+}
+
+func TestIsStale(t *testing.T) {
+	stored := "2022-11-01T12:00:00Z"
+	at := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	require.True(t, IsStale(stored, at.Add(-time.Second)))
+	// a redelivery of the version in the row is written again
+	require.False(t, IsStale(stored, at.Add(500*time.Millisecond)))
+	require.False(t, IsStale(stored, at.Add(time.Minute)))
+	require.False(t, IsStale("", at))
+	require.False(t, IsStale("Nov 1, 2022", at))
+}
+
+func TestSheetLayoutKeysRowsByWrittenValues(t *testing.T) {
+	mapped, err := resolveColumns([]model.ColumnMapping{{Field: "answer_id", Formatter: "upper"}, {Field: "answer"}})
+	require.NoError(t, err)
+
+	layout := newSheetLayout([]string{"ANSWER", "ANSWERID"}, mapped)
+	keyColumn, ok := layout.field("answer_id")
+	require.True(t, ok)
+
+	cells := layout.cells(&model.QuestionnarieData{AnswerID: strPtr("a-1"), Answer: strPtr("yes")})
+	require.Equal(t, map[int]interface{}{0: "yes", 1: "A-1"}, cells)
+	require.Equal(t, "A-1", layout.key(cells, []int{keyColumn}))
+
+	cells = layout.cells(&model.QuestionnarieData{Answer: strPtr("yes")})
+	require.Empty(t, layout.key(cells, []int{keyColumn}))
+}
@@ -0,0 +1,126 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+)
+
+type batchKey struct {
+	sink        model.SinkType
+	destination string
+	sheet       string
+	credentials string
+	// the columns, mode and redacted fields of the target
+	layout string
+}
+
+func newBatchKey(target Target) batchKey {
+	layout, _ := json.Marshal(struct {
+		Columns      []model.ColumnMapping
+		Mode         model.WriteMode
+		RedactFields []string
+	}{target.Columns, target.Mode, target.RedactFields})
+
+	return batchKey{
+		sink:        target.Sink,
+		destination: target.Destination,
+		sheet:       target.Sheet,
+		credentials: target.Credentials,
+		layout:      string(layout),
+	}
+}
+
+type rowBatch struct {
+	sink   Sink
+	target Target
+	ops    []model.Operation
+	data   []*model.QuestionnarieData
+	done   []func(error)
+}
+
+// BatchWriter buffers questionnaire answers per target, and writes every
+// buffer with one call to its sink when it is flushed. It is
+// meant to live for a single batch of messages and is not safe for
+// concurrent use.
+type BatchWriter struct {
+	batches map[batchKey]*rowBatch
+	order   []batchKey
+	logger  logger.AppLogger
+}
+
+func NewBatchWriter(logger logger.AppLogger) *BatchWriter {
+	return &BatchWriter{
+		batches: make(map[batchKey]*rowBatch),
+		logger:  logger,
+	}
+}
+
+// Add buffers op on data for the target sheet. done is called with the result
+// once the buffer has been flushed. Answers are only written together with
+// those for the same sheet, written as the same credentials with the same
+// columns and mode, through the sink of the first one added.
+func (b *BatchWriter) Add(s Sink, target Target, op model.Operation, data *model.QuestionnarieData, done func(error)) {
+	key := newBatchKey(target)
+
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &rowBatch{sink: s, target: target}
+		b.batches[key] = batch
+		b.order = append(b.order, key)
+	}
+
+	batch.ops = append(batch.ops, op)
+	batch.data = append(batch.data, data)
+	batch.done = append(batch.done, done)
+}
+
+// Flush writes every buffered sheet in the order they were first added to,
+// and reports the outcome of each answer through its done callback. Within a
+// sheet, consecutive answers are written together as long as they call for
+// the same kind of change, so a delete never overtakes the insert before it.
+// Answers written together that fail for good are written one by one again,
+// so that one bad answer doesn't fail the others.
+func (b *BatchWriter) Flush(ctx context.Context) {
+	for _, key := range b.order {
+		batch := b.batches[key]
+
+		for start := 0; start < len(batch.data); {
+			end := start + 1
+			for end < len(batch.data) && sameChange(batch.ops[start], batch.ops[end]) {
+				end++
+			}
+
+			err := Apply(ctx, batch.sink, batch.target, batch.ops[start], batch.data[start:end])
+			if err != nil {
+				b.logger.Error("failed to write batch to sink :: stacktrace ::", err)
+			}
+
+			if end-start > 1 && google.IsPermanentError(err) {
+				for i := start; i < end; i++ {
+					batch.done[i](Apply(ctx, batch.sink, batch.target, batch.ops[i], batch.data[i:i+1]))
+				}
+			} else {
+				for _, done := range batch.done[start:end] {
+					done(err)
+				}
+			}
+			start = end
+		}
+	}
+
+	b.batches = make(map[batchKey]*rowBatch)
+	b.order = nil
+}
+
+// sameChange reports whether a and b can be applied in one go. Inserts and
+// updates are both writes.
+func sameChange(a model.Operation, b model.Operation) bool {
+	if a.Erases() || b.Erases() {
+		return a == b
+	}
+	return true
+}
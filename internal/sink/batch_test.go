@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSameChange(t *testing.T) {
+	require.True(t, sameChange(model.OperationInsert, model.OperationUpdate))
+	require.True(t, sameChange("", model.OperationInsert))
+	require.True(t, sameChange(model.OperationDelete, model.OperationDelete))
+	require.False(t, sameChange(model.OperationInsert, model.OperationDelete))
+	require.False(t, sameChange(model.OperationRedact, model.OperationDelete))
+}
+
+// recordingSink records the answers of every write, failing those with a bad
+// answer for good.
+type recordingSink struct {
+	writes [][]string
+}
+
+func (r *recordingSink) EnsureSchema(ctx context.Context, target Target) error { return nil }
+
+func (r *recordingSink) WriteRows(ctx context.Context, target Target, data []*model.QuestionnarieData) error {
+	answers := []string{}
+	for _, d := range data {
+		if *d.Answer == "bad" {
+			return google.ErrMissingAnswerID
+		}
+		answers = append(answers, *d.Answer)
+	}
+	r.writes = append(r.writes, answers)
+	return nil
+}
+
+func (r *recordingSink) Upsert(ctx context.Context, target Target, data []*model.QuestionnarieData) error {
+	return r.WriteRows(ctx, target, data)
+}
+
+func (r *recordingSink) Delete(ctx context.Context, target Target, op model.Operation, data []*model.QuestionnarieData) error {
+	return r.WriteRows(ctx, target, data)
+}
+
+func TestBatchWriter(t *testing.T) {
+	first, second := &recordingSink{}, &recordingSink{}
+	writer := NewBatchWriter(logger.NewLogger(zap.NewNop().Sugar()))
+
+	results := map[string]error{}
+	add := func(s Sink, credentials string, value string) {
+		target := Target{Sink: model.SinkGoogleSheets, Destination: "sheet", Sheet: "form", Credentials: credentials}
+		writer.Add(s, target, model.OperationInsert, answer(value, "r-1", "q-1", value), func(err error) { results[value] = err })
+	}
+
+	// answers written as other credentials go through their own sink
+	add(first, "org\x00token\x00a", "yes")
+	add(first, "org\x00token\x00a", "bad")
+	add(first, "org\x00token\x00a", "no")
+	add(second, "other\x00token\x00b", "maybe")
+	writer.Flush(context.Background())
+
+	// the bad answer fails the write of all three, which are then written
+	// one by one
+	require.Equal(t, [][]string{{"yes"}, {"no"}}, first.writes)
+	require.Equal(t, [][]string{{"maybe"}}, second.writes)
+	require.NoError(t, results["yes"])
+	require.NoError(t, results["no"])
+	require.NoError(t, results["maybe"])
+	require.ErrorIs(t, results["bad"], google.ErrMissingAnswerID)
+}
@@ -0,0 +1,234 @@
+package sink
+
+import (
+	"encoding/csv"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+)
+
+const csvExtension = ".csv"
+
+// CSV is a sink writing every file as a directory holding a CSV file per tab,
+// named after the tab, with the header row first.
+type CSV struct {
+	fileSink
+}
+
+var _ Sink = (*CSV)(nil)
+
+// NewCSV returns a sink writing under dir, which is created when needed.
+func NewCSV(dir string, logger logger.AppLogger) *CSV {
+	return &CSV{fileSink{dir: dir, format: csvFormat{}, logger: logger}}
+}
+
+type csvFormat struct{}
+
+func (csvFormat) path(dir string, file string) string {
+	return filepath.Join(dir, filepath.FromSlash(file))
+}
+
+// title makes a sheet title fit for a file name.
+func (csvFormat) title(sheet string) string {
+	title := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, sheet)
+
+	title = strings.Trim(title, " .")
+	if title == "" {
+		return "sheet"
+	}
+	return title
+}
+
+func (csvFormat) load(path string) ([]*table, error) {
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), csvExtension) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	tabs := make([]*table, 0, len(names))
+	for _, name := range names {
+		t, err := readCSV(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		t.title = strings.TrimSuffix(name, csvExtension)
+		tabs = append(tabs, t)
+	}
+	return tabs, nil
+}
+
+func readCSV(path string) (*table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	// edits by hand may leave rows of any length
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		for i, cell := range record {
+			record[i] = unescapeCell(cell)
+		}
+	}
+
+	t := &table{rows: [][]string{}}
+	if len(records) > 0 {
+		t.headers, t.rows = records[0], records[1:]
+	}
+	t.loaded = len(t.rows)
+	return t, nil
+}
+
+// save only writes the tabs that changed, each to a file of its own. Tabs
+// that only had rows appended get them appended to their file.
+func (csvFormat) save(path string, tabs []*table) error {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return err
+	}
+
+	for _, t := range tabs {
+		if !t.dirty {
+			continue
+		}
+
+		p := filepath.Join(path, t.title+csvExtension)
+		if !t.rewrite {
+			if err := appendCSV(p, t.headers, t.rows[t.loaded:]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := writeFile(p, func(file *os.File) error {
+			return writeCSV(file, t.headers, append([][]string{t.headers}, t.rows...))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendCSV appends rows to the CSV file at path, starting them on a line of
+// their own should the file not end with one.
+func appendCSV(path string, headers []string, rows [][]string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := endLine(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := writeCSV(file, headers, rows); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// endLine adds a line break to the end of file unless it is empty or ends
+// with one already.
+func endLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+
+	_, err = file.Write([]byte("\n"))
+	return err
+}
+
+// writeCSV writes rows padded to the width of headers.
+func writeCSV(file *os.File, headers []string, rows [][]string) error {
+	writer := csv.NewWriter(file)
+	for _, row := range rows {
+		record := make([]string, 0, len(headers))
+		for _, cell := range row {
+			record = append(record, escapeCell(cell))
+		}
+		for len(record) < len(headers) {
+			record = append(record, "")
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// numericCell matches the numbers spreadsheet applications read as such,
+// phone numbers like +2348012345678 included.
+var numericCell = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// escapeCell keeps spreadsheet applications opening a CSV file from taking a
+// cell for a formula, by prefixing cells starting like one with a quote.
+// Cells that would look escaped get another quote, so that unescapeCell reads
+// every cell back as it was.
+func escapeCell(cell string) string {
+	if formulaCell(cell) || escapedCell(cell) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// unescapeCell drops the quote escapeCell prefixed the cell with, leaving
+// cells starting with a quote typed by hand as they are.
+func unescapeCell(cell string) string {
+	if escapedCell(cell) {
+		return cell[1:]
+	}
+	return cell
+}
+
+func formulaCell(cell string) bool {
+	return cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) && !numericCell.MatchString(cell)
+}
+
+func escapedCell(cell string) bool {
+	return strings.HasPrefix(cell, "'") && (formulaCell(cell[1:]) || escapedCell(cell[1:]))
+}
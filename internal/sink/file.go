@@ -0,0 +1,190 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+)
+
+// format reads and writes the tabs of a file sink's destination.
+type format interface {
+	// path returns where the destination file is kept under dir.
+	path(dir string, file string) string
+	// title returns the title a tab is kept under, which the format may
+	// have to shorten or strip of characters it can't hold.
+	title(sheet string) string
+	// load returns the tabs at path in order, none when it doesn't exist.
+	load(path string) ([]*table, error)
+	// save writes the tabs that changed to path, replacing what was there
+	// or, where the format allows, appending the rows added since load.
+	save(path string, tabs []*table) error
+}
+
+// fileSink writes to files under a directory, reading the whole file, laying
+// the answers out like a Google spreadsheet in the target's mode and writing
+// back what changed, see format.save. Writes to the same sink are serialised.
+type fileSink struct {
+	dir    string
+	format format
+	logger logger.AppLogger
+
+	mu sync.Mutex
+}
+
+func (f *fileSink) EnsureSchema(ctx context.Context, target Target) error {
+	return f.edit(target, func(tabs []*table) ([]*table, error) {
+		tabs, t := f.tab(tabs, target.Sheet)
+		_, err := newLayout(t, google.ModeColumnMapping(target.Mode, target.Columns), false)
+		return tabs, err
+	})
+}
+
+func (f *fileSink) WriteRows(ctx context.Context, target Target, data []*model.QuestionnarieData) error {
+	return f.edit(target, func(tabs []*table) ([]*table, error) {
+		tabs, t := f.tab(tabs, target.Sheet)
+		if target.Mode == model.WriteModeResponse {
+			return tabs, writeResponses(t, target, data)
+		}
+		return tabs, appendAnswers(t, target, data)
+	})
+}
+
+func (f *fileSink) Upsert(ctx context.Context, target Target, data []*model.QuestionnarieData) error {
+	return f.edit(target, func(tabs []*table) ([]*table, error) {
+		tabs, t := f.tab(tabs, target.Sheet)
+		return tabs, upsertAnswers(t, target, data)
+	})
+}
+
+// Delete erases from the target's tab, if the file has it, or from every tab
+// when the target names none. Tabs without the columns to find rows by are
+// then left alone.
+func (f *fileSink) Delete(ctx context.Context, target Target, op model.Operation, data []*model.QuestionnarieData) error {
+	return f.edit(target, func(tabs []*table) ([]*table, error) {
+		anyTab := target.Sheet == ""
+
+		// answers of other forms share the file, so a file without the
+		// target's tab holds nothing to erase
+		erased := tabs
+		if !anyTab {
+			erased = nil
+			if t := f.find(tabs, target.Sheet); t != nil {
+				erased = []*table{t}
+			}
+		}
+
+		for _, t := range erased {
+			l, err := newLayout(t, google.ModeColumnMapping(target.Mode, target.Columns), true)
+			if err != nil {
+				return nil, err
+			}
+			l.anyTab = anyTab
+
+			if op == model.OperationDelete {
+				err = deleteRows(l, target.Mode, data)
+			} else {
+				err = redactRows(l, target, data)
+			}
+
+			if err != nil && (!anyTab || !errors.Is(err, google.ErrNoRowReference)) {
+				return nil, err
+			}
+		}
+		return tabs, nil
+	})
+}
+
+// edit reads the target's file, changes its tabs with fn and writes them
+// back when any changed.
+func (f *fileSink) edit(target Target, fn func(tabs []*table) ([]*table, error)) error {
+	if err := model.ValidateFile(target.Destination); err != nil {
+		return err
+	}
+	path := f.format.path(f.dir, target.Destination)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tabs, err := f.format.load(path)
+	if err != nil {
+		f.logger.Error("failed to read sink file :: stacktrace ::", err)
+		return err
+	}
+
+	tabs, err = fn(tabs)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, t := range tabs {
+		changed = changed || t.dirty
+	}
+	if !changed {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	if err := f.format.save(path, tabs); err != nil {
+		f.logger.Error("failed to write sink file :: stacktrace ::", err)
+		return err
+	}
+	return nil
+}
+
+// find returns the tab of a sheet, nil when there is none. Titles differing
+// only in case name the same tab, as they do in Excel and on some file
+// systems.
+func (f *fileSink) find(tabs []*table, sheet string) *table {
+	if sheet == "" {
+		return nil
+	}
+
+	title := f.format.title(sheet)
+	for _, t := range tabs {
+		if strings.EqualFold(t.title, title) {
+			return t
+		}
+	}
+	return nil
+}
+
+// tab returns the tab of a sheet, adding it when there is none.
+func (f *fileSink) tab(tabs []*table, sheet string) ([]*table, *table) {
+	if t := f.find(tabs, sheet); t != nil {
+		return tabs, t
+	}
+
+	t := &table{title: f.format.title(sheet), dirty: true, rewrite: true}
+	return append(tabs, t), t
+}
+
+// writeFile writes a file through a temporary one next to it, so readers
+// never see it half written.
+func writeFile(path string, write func(file *os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
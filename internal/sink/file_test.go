@@ -0,0 +1,252 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func strPtr(s string) *string { return &s }
+
+func answer(answerID string, respondentID string, question string, value string) *model.QuestionnarieData {
+	return &model.QuestionnarieData{
+		FormID:          strPtr("form"),
+		QuestionID:      strPtr(question),
+		QuestionTitle:   strPtr(question),
+		AnswerID:        strPtr(answerID),
+		Answer:          strPtr(value),
+		RespondentID:    strPtr(respondentID),
+		RespondentEmail: strPtr(respondentID + "@example.com"),
+		OrgID:           strPtr("org"),
+	}
+}
+
+// sinks returns a sink of every file format writing under dir, and how to
+// read back the rows of a tab.
+func sinks(t *testing.T, dir string) map[string]struct {
+	sink Sink
+	rows func(file string, sheet string) [][]string
+} {
+	appLogger := logger.NewLogger(zap.NewNop().Sugar())
+	xlsx, csv := NewXLSX(dir, appLogger), NewCSV(dir, appLogger)
+
+	read := func(f *fileSink) func(file string, sheet string) [][]string {
+		return func(file string, sheet string) [][]string {
+			tabs, err := f.format.load(f.format.path(dir, file))
+			require.NoError(t, err)
+
+			tab := f.find(tabs, sheet)
+			require.NotNil(t, tab)
+
+			// CSV rows run the width of the header row, XLSX rows stop at
+			// their last cell
+			rows := [][]string{tab.headers}
+			for _, row := range tab.rows {
+				for len(row) < len(tab.headers) {
+					row = append(row, "")
+				}
+				rows = append(rows, row)
+			}
+			return rows
+		}
+	}
+
+	return map[string]struct {
+		sink Sink
+		rows func(file string, sheet string) [][]string
+	}{
+		"xlsx": {xlsx, read(&xlsx.fileSink)},
+		"csv":  {csv, read(&csv.fileSink)},
+	}
+}
+
+func TestFileSinks(t *testing.T) {
+	ctx := context.Background()
+	columns := []model.ColumnMapping{
+		{Field: "answer_id", Header: "Answer ID"},
+		{Field: "respondent_id", Header: "Respondent"},
+		{Field: "respondent_email", Header: "Email"},
+		{Field: "answer", Header: "Answer"},
+	}
+
+	for name, s := range sinks(t, t.TempDir()) {
+		t.Run(name, func(t *testing.T) {
+			target := Target{Destination: "org/answers", Sheet: "form", Columns: columns}
+
+			require.NoError(t, Apply(ctx, s.sink, target, model.OperationInsert, []*model.QuestionnarieData{
+				answer("a-1", "r-1", "q-1", "yes"),
+				answer("a-2", "r-2", "q-1", "no"),
+			}))
+
+			// redelivered and edited answers rewrite their row in upsert mode
+			upsert := target
+			upsert.Sheet, upsert.Mode = "upserts", model.WriteModeUpsert
+			edited := answer("a-1", "r-1", "q-1", "maybe")
+			edited.UpdatedAt = time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+			require.NoError(t, Apply(ctx, s.sink, upsert, model.OperationInsert, []*model.QuestionnarieData{
+				answer("a-1", "r-1", "q-1", "yes"),
+				answer("a-3", "r-3", "q-1", "no"),
+			}))
+			require.NoError(t, Apply(ctx, s.sink, upsert, model.OperationUpdate, []*model.QuestionnarieData{edited}))
+
+			// an older version delivered late leaves the newer one in place
+			stale := answer("a-1", "r-1", "q-1", "no way")
+			stale.UpdatedAt = edited.UpdatedAt.Add(-time.Minute)
+			require.NoError(t, Apply(ctx, s.sink, upsert, model.OperationUpdate, []*model.QuestionnarieData{stale}))
+
+			require.Equal(t, [][]string{
+				{"UPDATEDAT", "Answer ID", "Respondent", "Email", "Answer"},
+				{"2022-11-01T12:00:00Z", "a-1", "r-1", "r-1@example.com", "maybe"},
+				{"", "a-3", "r-3", "r-3@example.com", "no"},
+			}, s.rows("org/answers", "upserts"))
+
+			// an erasure naming no sheet looks through all of them
+			erase := Target{Destination: "org/answers", Columns: columns}
+			require.NoError(t, Apply(ctx, s.sink, erase, model.OperationDelete, []*model.QuestionnarieData{{AnswerID: strPtr("a-1")}}))
+			require.NoError(t, Apply(ctx, s.sink, erase, model.OperationRedact, []*model.QuestionnarieData{{RespondentID: strPtr("r-2")}}))
+
+			require.Equal(t, [][]string{
+				{"Answer ID", "Respondent", "Email", "Answer"},
+				{"a-2", "r-2", "", "no"},
+			}, s.rows("org/answers", "form"))
+			require.Equal(t, [][]string{
+				{"UPDATEDAT", "Answer ID", "Respondent", "Email", "Answer"},
+				{"", "a-3", "r-3", "r-3@example.com", "no"},
+			}, s.rows("org/answers", "upserts"))
+		})
+	}
+}
+
+func TestFileSinkResponses(t *testing.T) {
+	ctx := context.Background()
+	columns := []model.ColumnMapping{{Field: "respondent_email", Header: "Email"}}
+
+	for name, s := range sinks(t, t.TempDir()) {
+		t.Run(name, func(t *testing.T) {
+			target := Target{Destination: "responses", Sheet: "form", Columns: columns, Mode: model.WriteModeResponse}
+
+			require.NoError(t, Apply(ctx, s.sink, target, model.OperationInsert, []*model.QuestionnarieData{
+				answer("a-1", "r-1", "How was it?", "great"),
+				answer("a-2", "r-2", "How was it?", "fine"),
+			}))
+			require.NoError(t, Apply(ctx, s.sink, target, model.OperationInsert, []*model.QuestionnarieData{
+				answer("a-3", "r-1", "Again?", "yes"),
+			}))
+
+			require.Equal(t, [][]string{
+				{"RESPONDENTID", "FORMID", "Email", "How was it?", "Again?"},
+				{"r-1", "form", "r-1@example.com", "great", "yes"},
+				{"r-2", "form", "r-2@example.com", "fine", ""},
+			}, s.rows("responses", "form"))
+
+			// deleting an answer clears its cell and keeps the response
+			require.NoError(t, Apply(ctx, s.sink, target, model.OperationDelete, []*model.QuestionnarieData{answer("a-1", "r-1", "How was it?", "")}))
+			require.Equal(t, [][]string{
+				{"RESPONDENTID", "FORMID", "Email", "How was it?", "Again?"},
+				{"r-1", "form", "r-1@example.com", "", "yes"},
+				{"r-2", "form", "r-2@example.com", "fine", ""},
+			}, s.rows("responses", "form"))
+
+			// the integration's fields to redact can take the answers along
+			redact := target
+			redact.RedactFields = []string{"respondent_email", "answers"}
+			require.NoError(t, Apply(ctx, s.sink, redact, model.OperationRedact, []*model.QuestionnarieData{{RespondentID: strPtr("r-2")}}))
+			require.Equal(t, [][]string{
+				{"RESPONDENTID", "FORMID", "Email", "How was it?", "Again?"},
+				{"r-1", "form", "r-1@example.com", "", "yes"},
+				{"r-2", "form", "", "", ""},
+			}, s.rows("responses", "form"))
+		})
+	}
+}
+
+func TestFileSinkStaysInDirectory(t *testing.T) {
+	dir := t.TempDir()
+	s := NewCSV(filepath.Join(dir, "exports"), logger.NewLogger(zap.NewNop().Sugar()))
+
+	target := Target{Destination: "../outside", Sheet: "form"}
+	err := Apply(context.Background(), s, target, model.OperationInsert, []*model.QuestionnarieData{answer("a-1", "r-1", "q-1", "yes")})
+	require.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "outside"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCSVAppendsAndEscapes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := NewCSV(dir, logger.NewLogger(zap.NewNop().Sugar()))
+	target := Target{Destination: "org/answers", Sheet: "form", Columns: []model.ColumnMapping{
+		{Field: "answer_id", Header: "Answer ID"},
+		{Field: "answer", Header: "Answer"},
+	}}
+	path := filepath.Join(dir, "org", "answers", "form.csv")
+
+	require.NoError(t, Apply(ctx, s, target, model.OperationInsert, []*model.QuestionnarieData{
+		answer("a-1", "r-1", "q-1", "=HYPERLINK(\"https://evil.example.com\")"),
+	}))
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// answers only appended are appended to the file, not written through
+	// another one
+	require.NoError(t, Apply(ctx, s, target, model.OperationInsert, []*model.QuestionnarieData{
+		answer("a-2", "r-2", "q-1", "'quoted"),
+		answer("a-3", "r-3", "q-1", "+2348012345678"),
+		answer("a-4", "r-4", "q-1", "-12.5"),
+		answer("a-5", "r-5", "q-1", "'=1+1"),
+	}))
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(before, after))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "Answer ID,Answer\na-1,\"'=HYPERLINK(\"\"https://evil.example.com\"\")\"\na-2,'quoted\na-3,+2348012345678\na-4,-12.5\na-5,''=1+1\n", string(content))
+
+	// cells read back as they were written
+	tabs, err := s.format.load(s.format.path(dir, "org/answers"))
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"a-1", "=HYPERLINK(\"https://evil.example.com\")"},
+		{"a-2", "'quoted"},
+		{"a-3", "+2348012345678"},
+		{"a-4", "-12.5"},
+		{"a-5", "'=1+1"},
+	}, tabs[0].rows)
+}
+
+func TestFileSinkErasesFromTheFormsTabOnly(t *testing.T) {
+	ctx := context.Background()
+	columns := []model.ColumnMapping{{Field: "respondent_id", Header: "Respondent"}, {Field: "answer", Header: "Answer"}}
+
+	for name, s := range sinks(t, t.TempDir()) {
+		t.Run(name, func(t *testing.T) {
+			// answers of another form, in a tab without a form_id column
+			other := Target{Destination: "org/answers", Sheet: "other", Columns: columns}
+			require.NoError(t, Apply(ctx, s.sink, other, model.OperationInsert, []*model.QuestionnarieData{answer("a-1", "r-1", "q-1", "yes")}))
+
+			// the form has no tab, so there is nothing of it to erase
+			respondent := &model.QuestionnarieData{RespondentID: strPtr("r-1"), FormID: strPtr("form")}
+			target := Target{Destination: "org/answers", Sheet: "form", Columns: columns}
+			require.NoError(t, Apply(ctx, s.sink, target, model.OperationDelete, []*model.QuestionnarieData{respondent}))
+
+			// nor does an erasure naming no tab find the form's respondent
+			// elsewhere
+			target.Sheet = ""
+			require.NoError(t, Apply(ctx, s.sink, target, model.OperationDelete, []*model.QuestionnarieData{respondent}))
+			require.Equal(t, [][]string{{"Respondent", "Answer"}, {"r-1", "yes"}}, s.rows("org/answers", "other"))
+
+			// while a respondent named without a form goes everywhere
+			require.NoError(t, Apply(ctx, s.sink, target, model.OperationDelete, []*model.QuestionnarieData{{RespondentID: strPtr("r-1")}}))
+			require.Equal(t, [][]string{{"Respondent", "Answer"}}, s.rows("org/answers", "other"))
+		})
+	}
+}
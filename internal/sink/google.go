@@ -0,0 +1,60 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+)
+
+// GoogleSheets writes to Google spreadsheets through a sheets client, which
+// carries the credentials of the integration.
+type GoogleSheets struct {
+	client *google.GoogleSheetClient
+}
+
+var _ Sink = (*GoogleSheets)(nil)
+
+func NewGoogleSheets(client *google.GoogleSheetClient) *GoogleSheets {
+	return &GoogleSheets{client: client}
+}
+
+// GoogleTarget returns the target of a message routed by a sheets client.
+func GoogleTarget(target google.Target) Target {
+	return Target{
+		Sink:         model.SinkGoogleSheets,
+		Destination:  target.SpreadSheetID,
+		Sheet:        target.Sheet,
+		Columns:      target.Columns,
+		Mode:         target.Mode,
+		RedactFields: target.RedactFields,
+	}
+}
+
+func (g *GoogleSheets) EnsureSchema(ctx context.Context, target Target) error {
+	return g.client.EnsureSheet(g.target(target))
+}
+
+func (g *GoogleSheets) WriteRows(ctx context.Context, target Target, data []*model.QuestionnarieData) error {
+	return g.client.Apply(g.target(target), model.OperationInsert, data)
+}
+
+func (g *GoogleSheets) Upsert(ctx context.Context, target Target, data []*model.QuestionnarieData) error {
+	t := g.target(target)
+	t.Mode = model.WriteModeUpsert
+	return g.client.Apply(t, model.OperationInsert, data)
+}
+
+func (g *GoogleSheets) Delete(ctx context.Context, target Target, op model.Operation, data []*model.QuestionnarieData) error {
+	return g.client.Apply(g.target(target), op, data)
+}
+
+func (g *GoogleSheets) target(target Target) google.Target {
+	return google.Target{
+		SpreadSheetID: target.Destination,
+		Sheet:         target.Sheet,
+		Columns:       target.Columns,
+		Mode:          target.Mode,
+		RedactFields:  target.RedactFields,
+	}
+}
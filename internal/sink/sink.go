@@ -0,0 +1,88 @@
+// Package sink writes questionnaire answers to where an integration keeps
+// them: a Google spreadsheet, or an XLSX or CSV export on disk for those who
+// don't use Google. Every sink lays answers out the same way, see the write
+// modes of model.WriteMode.
+package sink
+
+import (
+	"context"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+)
+
+// Target is where and how questionnaire answers are written.
+type Target struct {
+	Sink model.SinkType
+	// Destination is the spreadsheet ID for Google Sheets, and the file
+	// relative to the sink's directory for file sinks.
+	Destination string
+	// Sheet is the title of the tab written to. Erasures without one apply to
+	// every tab of the destination.
+	Sheet   string
+	Columns []model.ColumnMapping
+	Mode    model.WriteMode
+	// RedactFields are the fields a redaction blanks, see
+	// google.RedactedFields.
+	RedactFields []string
+	// Credentials identifies who the destination is written as, see
+	// google.CredentialRef.Key. File sinks have none.
+	Credentials string
+}
+
+// Sink is a destination for questionnaire answers.
+type Sink interface {
+	// EnsureSchema creates the target's tab with its header row unless it
+	// exists, and adds the headers of mapped columns it lacks.
+	EnsureSchema(ctx context.Context, target Target) error
+	// WriteRows writes answers to the target in its mode, a row per answer or
+	// a row per response.
+	WriteRows(ctx context.Context, target Target, data []*model.QuestionnarieData) error
+	// Upsert writes every answer to the row holding its answer ID, and
+	// appends those that have none yet.
+	Upsert(ctx context.Context, target Target, data []*model.QuestionnarieData) error
+	// Delete erases answers: OperationDelete removes their rows, or all rows
+	// of their respondent when they have no answer ID, and OperationRedact
+	// blanks the personal data of their respondent.
+	Delete(ctx context.Context, target Target, op model.Operation, data []*model.QuestionnarieData) error
+}
+
+// Apply carries out op for the given answers on the target with the sink's
+// method for it.
+func Apply(ctx context.Context, s Sink, target Target, op model.Operation, data []*model.QuestionnarieData) error {
+	if op.Erases() {
+		return s.Delete(ctx, target, op, data)
+	}
+
+	if err := s.EnsureSchema(ctx, target); err != nil {
+		return err
+	}
+
+	if target.Mode == model.WriteModeUpsert {
+		return s.Upsert(ctx, target, data)
+	}
+	return s.WriteRows(ctx, target, data)
+}
+
+// Route returns the target of a message to a file sink: the tab it names, or
+// else the tab of its form, titled like the tabs of Google spreadsheets.
+func Route(message *model.GoogleSheetKafkaMessage) (Target, error) {
+	target := Target{
+		Sink:         message.Sink,
+		Destination:  message.File,
+		Sheet:        message.SheetID,
+		Columns:      message.Columns,
+		Mode:         message.Mode,
+		RedactFields: message.RedactFields,
+	}
+
+	if target.Sheet == "" && message.Questionnaire.FormID != nil {
+		target.Sheet = google.FormSheetTitle(*message.Questionnaire.FormID)
+	}
+
+	// only erasures can do without a sheet, they then look through all of them
+	if target.Sheet == "" && !message.Operation.Erases() {
+		return Target{}, google.ErrSheetNotFound
+	}
+	return target, nil
+}
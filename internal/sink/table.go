@@ -0,0 +1,456 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+)
+
+// table is a tab of a file: its header row and the rows below it, cells held
+// as text.
+type table struct {
+	title   string
+	headers []string
+	rows    [][]string
+	// changed since it was read
+	dirty bool
+	// loaded is the number of rows read, rewrite whether anything but the
+	// rows appended after them changed, so that formats able to append
+	// don't have to write the rows read again
+	loaded  int
+	rewrite bool
+}
+
+// column returns the column headed by header, skipping the taken ones.
+func (t *table) column(header string, taken map[int]bool) (int, bool) {
+	for i, h := range t.headers {
+		if !taken[i] && google.SameHeader(h, header) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// addColumn heads a new column after the existing ones.
+func (t *table) addColumn(header string) int {
+	t.headers = append(t.headers, header)
+	t.dirty = true
+	t.rewrite = true
+	return len(t.headers) - 1
+}
+
+func (t *table) cell(row int, column int) string {
+	if column < len(t.rows[row]) {
+		return t.rows[row][column]
+	}
+	return ""
+}
+
+func (t *table) set(row int, column int, value string) {
+	for len(t.rows[row]) <= column {
+		t.rows[row] = append(t.rows[row], "")
+	}
+	t.rows[row][column] = value
+	t.dirty = true
+	t.rewrite = t.rewrite || row < t.loaded
+}
+
+// layout places the columns of a mapping in a table.
+type layout struct {
+	table   *table
+	columns []model.ColumnMapping
+	// table column of every mapped column, in mapping order
+	positions []int
+	fields    map[string]int
+	// anyTab is set on the tabs an erasure naming no tab goes through.
+	anyTab bool
+}
+
+// newLayout places every mapped column under its header, adding the headers
+// the table lacks unless readOnly is set. Columns without a header are then
+// left out.
+func newLayout(t *table, columns []model.ColumnMapping, readOnly bool) (*layout, error) {
+	cells, err := google.MapCells(columns, &model.QuestionnarieData{})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &layout{
+		table:     t,
+		columns:   columns,
+		positions: make([]int, len(cells)),
+		fields:    make(map[string]int, len(cells)),
+	}
+
+	taken := map[int]bool{}
+	for i, c := range cells {
+		position, ok := t.column(c.Header, taken)
+		if !ok && readOnly {
+			l.positions[i] = -1
+			continue
+		}
+		if !ok {
+			position = t.addColumn(c.Header)
+		}
+
+		taken[position] = true
+		l.positions[i] = position
+		l.fields[c.Field] = position
+	}
+	return l, nil
+}
+
+// cells returns the text of the mapped columns for data by table column.
+func (l *layout) cells(data *model.QuestionnarieData) (map[int]string, error) {
+	mapped, err := google.MapCells(l.columns, data)
+	if err != nil {
+		return nil, err
+	}
+
+	cells := make(map[int]string, len(mapped))
+	for i, c := range mapped {
+		if l.positions[i] >= 0 {
+			cells[l.positions[i]] = text(c.Value)
+		}
+	}
+	return cells, nil
+}
+
+// key returns the key of a row from its cells in the columns of fields, empty
+// when any of them is.
+func (l *layout) key(cells func(column int) string, fields ...string) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		position, ok := l.fields[field]
+		if !ok {
+			return ""
+		}
+		if parts[i] = cells(position); parts[i] == "" {
+			return ""
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// extra returns the column of a header that doesn't belong to any of the
+// mapped columns, e.g. a question column in response mode.
+func (l *layout) extra(header string) (int, bool) {
+	taken := map[int]bool{}
+	for _, position := range l.positions {
+		taken[position] = true
+	}
+	return l.table.column(header, taken)
+}
+
+// questions returns the columns that don't belong to any of the mapped
+// columns, which are the question columns in response mode.
+func (l *layout) questions() []int {
+	taken := map[int]bool{}
+	for _, position := range l.positions {
+		taken[position] = true
+	}
+
+	columns := []int{}
+	for column := range l.table.headers {
+		if !taken[column] {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// index maps the key of every row in the columns of fields to the row.
+func (l *layout) index(fields ...string) map[string]int {
+	index := make(map[string]int, len(l.table.rows))
+	for row := range l.table.rows {
+		row := row
+		if k := l.key(func(column int) string { return l.table.cell(row, column) }, fields...); k != "" {
+			index[k] = row
+		}
+	}
+	return index
+}
+
+// put writes cells to row, appending a row when it is -1, and returns the row.
+func (l *layout) put(row int, cells map[int]string) int {
+	if row < 0 {
+		l.table.rows = append(l.table.rows, make([]string, len(l.table.headers)))
+		row = len(l.table.rows) - 1
+	}
+
+	for column, value := range cells {
+		l.table.set(row, column, value)
+	}
+	l.table.dirty = true
+	return row
+}
+
+// text is what a formatted value reads as in a file.
+func text(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// appendAnswers writes one row per answer.
+func appendAnswers(t *table, target Target, data []*model.QuestionnarieData) error {
+	l, err := newLayout(t, google.ModeColumnMapping(target.Mode, target.Columns), false)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range data {
+		cells, err := l.cells(d)
+		if err != nil {
+			return err
+		}
+		l.put(-1, cells)
+	}
+	return nil
+}
+
+// writeResponses merges answers into one row per respondent and form, with a
+// column per question headed by its title, as in a Google spreadsheet.
+func writeResponses(t *table, target Target, data []*model.QuestionnarieData) error {
+	l, err := newLayout(t, google.ModeColumnMapping(model.WriteModeResponse, target.Columns), false)
+	if err != nil {
+		return err
+	}
+
+	index := l.index(google.ResponseKeyFields...)
+
+	for _, d := range data {
+		cells, err := l.cells(d)
+		if err != nil {
+			return err
+		}
+
+		// an answer missing a respondent detail must not blank what an
+		// earlier answer wrote
+		for column, value := range cells {
+			if value == "" {
+				delete(cells, column)
+			}
+		}
+
+		title := ""
+		if d.QuestionTitle != nil {
+			title = *d.QuestionTitle
+		}
+		position, ok := l.extra(title)
+		if !ok {
+			position = t.addColumn(title)
+		}
+		cells[position] = text(google.AnswerValue(d))
+
+		key := l.key(func(column int) string { return cells[column] }, google.ResponseKeyFields...)
+
+		row, ok := index[key]
+		if !ok || key == "" {
+			row = -1
+		}
+
+		row = l.put(row, cells)
+		if key != "" {
+			index[key] = row
+		}
+	}
+	return nil
+}
+
+// upsertAnswers writes every answer to the row of its answer ID. When the
+// same answer comes more than once, the version updated last wins, and an
+// answer older than the version in its row is left out.
+func upsertAnswers(t *table, target Target, data []*model.QuestionnarieData) error {
+	for _, d := range data {
+		if d.AnswerID == nil || *d.AnswerID == "" {
+			return google.ErrMissingAnswerID
+		}
+	}
+
+	l, err := newLayout(t, google.ModeColumnMapping(model.WriteModeUpsert, target.Columns), false)
+	if err != nil {
+		return err
+	}
+
+	index := l.index(google.UpsertKeyField)
+	written := map[string]*model.QuestionnarieData{}
+
+	for _, d := range data {
+		cells, err := l.cells(d)
+		if err != nil {
+			return err
+		}
+
+		key := l.key(func(column int) string { return cells[column] }, google.UpsertKeyField)
+		if previous, ok := written[key]; ok && d.UpdatedAt.Before(previous.UpdatedAt) {
+			continue
+		}
+
+		row, ok := index[key]
+		if !ok {
+			row = -1
+		} else if version, ok := l.fields[google.UpsertVersionField]; ok && google.IsStale(t.cell(row, version), d.UpdatedAt) {
+			// the file already holds a newer version of the answer
+			continue
+		}
+
+		// every mapped cell is rewritten, so values cleared by an edit are
+		// cleared in the file too
+		index[key] = l.put(row, cells)
+		written[key] = d
+	}
+	return nil
+}
+
+// matchingRows returns the rows of the answers in data, or of their
+// respondents with byRespondent or when they have no answer ID, in
+// ascending order. In a tab that isn't the target's, see anyTab, a
+// respondent of a form is only found along with the form. Answers whose rows
+// the tab lacks the columns to find by fail with google.ErrNoRowReference.
+func matchingRows(l *layout, data []*model.QuestionnarieData, byRespondent bool) ([]int, error) {
+	type match struct {
+		fields []string
+		key    string
+	}
+
+	matches := []match{}
+	for _, d := range data {
+		cells, err := l.cells(d)
+		if err != nil {
+			return nil, err
+		}
+		value := func(column int) string { return cells[column] }
+
+		fields := []string{"respondent_id"}
+		if !byRespondent && d.AnswerID != nil && *d.AnswerID != "" {
+			fields = []string{google.UpsertKeyField}
+		} else if _, ok := l.fields["form_id"]; ok && cells[l.fields["form_id"]] != "" {
+			fields = append(fields, "form_id")
+		} else if l.anyTab && d.FormID != nil && *d.FormID != "" {
+			// the respondent's rows of other forms aren't the ones asked for
+			return nil, google.ErrNoRowReference
+		}
+
+		key := l.key(value, fields...)
+		if key == "" {
+			return nil, google.ErrNoRowReference
+		}
+		matches = append(matches, match{fields: fields, key: key})
+	}
+
+	rows := []int{}
+	for row := range l.table.rows {
+		row := row
+		value := func(column int) string { return l.table.cell(row, column) }
+
+		for _, m := range matches {
+			if l.key(value, m.fields...) == m.key {
+				rows = append(rows, row)
+				break
+			}
+		}
+	}
+	return rows, nil
+}
+
+// deleteRows removes the rows of the answers in data. In response mode a row
+// holds every answer of a respondent, so single answers are cleared from it
+// instead.
+func deleteRows(l *layout, mode model.WriteMode, data []*model.QuestionnarieData) error {
+	if mode == model.WriteModeResponse {
+		respondents := []*model.QuestionnarieData{}
+		for _, d := range data {
+			if d.AnswerID == nil || *d.AnswerID == "" {
+				respondents = append(respondents, d)
+				continue
+			}
+			if err := clearAnswer(l, d); err != nil {
+				return err
+			}
+		}
+
+		if data = respondents; len(data) == 0 {
+			return nil
+		}
+	}
+
+	rows, err := matchingRows(l, data, false)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+
+	drop := make(map[int]bool, len(rows))
+	for _, row := range rows {
+		drop[row] = true
+	}
+
+	kept := l.table.rows[:0]
+	for row, cells := range l.table.rows {
+		if !drop[row] {
+			kept = append(kept, cells)
+		}
+	}
+	l.table.rows = kept
+	l.table.dirty = true
+	l.table.rewrite = true
+	return nil
+}
+
+// clearAnswer blanks the cell of an answer in the row of its respondent in a
+// tab in response mode.
+func clearAnswer(l *layout, data *model.QuestionnarieData) error {
+	if data.QuestionTitle == nil || *data.QuestionTitle == "" {
+		return fmt.Errorf("%w: deleting an answer from a response needs its question_title", google.ErrNoRowReference)
+	}
+
+	column, ok := l.extra(*data.QuestionTitle)
+	if !ok {
+		return nil
+	}
+
+	rows, err := matchingRows(l, []*model.QuestionnarieData{data}, true)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		l.table.set(row, column, "")
+	}
+	return nil
+}
+
+// redactRows blanks the columns of the target's fields to redact in every
+// row of the respondents of data.
+func redactRows(l *layout, target Target, data []*model.QuestionnarieData) error {
+	fields, answers := google.RedactedFields(target.RedactFields)
+
+	columns := []int{}
+	for _, field := range fields {
+		if position, ok := l.fields[field]; ok {
+			columns = append(columns, position)
+		}
+	}
+	if answers && target.Mode == model.WriteModeResponse {
+		columns = append(columns, l.questions()...)
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	rows, err := matchingRows(l, data, true)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for _, column := range columns {
+			l.table.set(row, column, "")
+		}
+	}
+	return nil
+}
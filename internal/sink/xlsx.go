@@ -0,0 +1,360 @@
+package sink
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+)
+
+const (
+	xlsxExtension = ".xlsx"
+	// Excel refuses longer worksheet names
+	xlsxMaxTitle = 31
+)
+
+// XLSX is a sink writing every file as an Excel workbook with a worksheet per
+// tab. Cells are written as text, the header row in bold and frozen like the
+// header rows of Google spreadsheets. Workbooks are written whole, keeping
+// only the text of cells: formatting, formulas and anything else added in
+// Excel is lost on the next write.
+type XLSX struct {
+	fileSink
+}
+
+var _ Sink = (*XLSX)(nil)
+
+// NewXLSX returns a sink writing under dir, which is created when needed.
+func NewXLSX(dir string, logger logger.AppLogger) *XLSX {
+	return &XLSX{fileSink{dir: dir, format: xlsxFormat{}, logger: logger}}
+}
+
+type xlsxFormat struct{}
+
+func (xlsxFormat) path(dir string, file string) string {
+	p := filepath.Join(dir, filepath.FromSlash(file))
+	if !strings.EqualFold(filepath.Ext(p), xlsxExtension) {
+		p += xlsxExtension
+	}
+	return p
+}
+
+// title makes a sheet title fit for a worksheet name.
+func (xlsxFormat) title(sheet string) string {
+	title := []rune(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, sheet))
+
+	if len(title) > xlsxMaxTitle {
+		title = title[:xlsxMaxTitle]
+	}
+
+	trimmed := strings.Trim(string(title), "'")
+	if trimmed == "" {
+		return "Sheet"
+	}
+	return trimmed
+}
+
+// the parts of a workbook read back
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+
+	b := strings.Builder{}
+	for _, run := range t.Runs {
+		b.WriteString(run.T)
+	}
+	return b.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string   `xml:"r,attr"`
+			T      string   `xml:"t,attr"`
+			V      string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func (xlsxFormat) load(p string) ([]*table, error) {
+	archive, err := zip.OpenReader(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	workbook := xlsxWorkbook{}
+	if err := readXML(&archive.Reader, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+
+	rels := xlsxRelationships{}
+	if err := readXML(&archive.Reader, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+
+	targets := map[string]string{}
+	for _, rel := range rels.Relationships {
+		// relative to the workbook unless absolute
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	shared := xlsxSharedStrings{}
+	if err := readXML(&archive.Reader, "xl/sharedStrings.xml", &shared); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	tabs := make([]*table, 0, len(workbook.Sheets))
+	for _, sheet := range workbook.Sheets {
+		worksheet := xlsxWorksheet{}
+		if err := readXML(&archive.Reader, targets[sheet.RID], &worksheet); err != nil {
+			return nil, err
+		}
+
+		grid := [][]string{}
+		for i, row := range worksheet.Rows {
+			r := row.R - 1
+			if row.R == 0 {
+				r = i
+			}
+			for len(grid) <= r {
+				grid = append(grid, []string{})
+			}
+
+			for j, c := range row.Cells {
+				column := j
+				if c.R != "" {
+					column = columnIndex(c.R)
+				}
+
+				value := c.V
+				switch c.T {
+				case "s":
+					if n, err := strconv.Atoi(c.V); err == nil && n < len(shared.Items) {
+						value = shared.Items[n].String()
+					}
+				case "inlineStr":
+					value = c.Inline.String()
+				case "b":
+					value = strings.ToUpper(strconv.FormatBool(c.V == "1"))
+				}
+
+				for len(grid[r]) <= column {
+					grid[r] = append(grid[r], "")
+				}
+				grid[r][column] = value
+			}
+		}
+
+		t := &table{title: sheet.Name, rows: [][]string{}}
+		if len(grid) > 0 {
+			t.headers, t.rows = grid[0], grid[1:]
+		}
+		tabs = append(tabs, t)
+	}
+	return tabs, nil
+}
+
+func readXML(archive *zip.Reader, name string, v interface{}) error {
+	file, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return xml.NewDecoder(file).Decode(v)
+}
+
+// columnIndex returns the column of a cell reference such as "AB12", from 0.
+func columnIndex(ref string) int {
+	n := 0
+	for _, r := range strings.ToUpper(ref) {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		n = n*26 + int(r-'A'+1)
+	}
+	return n - 1
+}
+
+// columnName returns the letters of a column, from 0.
+func columnName(column int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name
+}
+
+const (
+	xlsxMainNamespace = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRelNamespace  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsxPackageRels   = "http://schemas.openxmlformats.org/package/2006/relationships"
+	xlsxHeader        = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+)
+
+// the header row uses the second cell format, in bold
+const xlsxStyles = xlsxHeader + `<styleSheet xmlns="` + xlsxMainNamespace + `">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+func (xlsxFormat) save(p string, tabs []*table) error {
+	return writeFile(p, func(file *os.File) error {
+		archive := zip.NewWriter(file)
+
+		parts := []struct {
+			name    string
+			content string
+		}{
+			{"[Content_Types].xml", xlsxContentTypes(len(tabs))},
+			{"_rels/.rels", xlsxHeader + `<Relationships xmlns="` + xlsxPackageRels + `">` +
+				`<Relationship Id="rId1" Type="` + xlsxRelNamespace + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+			{"xl/workbook.xml", xlsxWorkbookPart(tabs)},
+			{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels(len(tabs))},
+			{"xl/styles.xml", xlsxStyles},
+		}
+
+		for i, t := range tabs {
+			parts = append(parts, struct {
+				name    string
+				content string
+			}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxWorksheetPart(t)})
+		}
+
+		for _, part := range parts {
+			w, err := archive.Create(part.name)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, part.content); err != nil {
+				return err
+			}
+		}
+		return archive.Close()
+	})
+}
+
+func xlsxContentTypes(sheets int) string {
+	b := strings.Builder{}
+	b.WriteString(xlsxHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	b.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+func xlsxWorkbookPart(tabs []*table) string {
+	b := strings.Builder{}
+	b.WriteString(xlsxHeader + `<workbook xmlns="` + xlsxMainNamespace + `" xmlns:r="` + xlsxRelNamespace + `"><sheets>`)
+	for i, t := range tabs {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escapeXML(t.title), i+1, i+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	return b.String()
+}
+
+// the worksheets are rId1 to rIdN, the styles follow them
+func xlsxWorkbookRels(sheets int) string {
+	b := strings.Builder{}
+	b.WriteString(xlsxHeader + `<Relationships xmlns="` + xlsxPackageRels + `">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, i, xlsxRelNamespace, i)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="%s/styles" Target="styles.xml"/>`, sheets+1, xlsxRelNamespace)
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+func xlsxWorksheetPart(t *table) string {
+	b := strings.Builder{}
+	b.WriteString(xlsxHeader + `<worksheet xmlns="` + xlsxMainNamespace + `">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	b.WriteString(`<sheetData>`)
+
+	writeRow := func(r int, cells []string, style string) {
+		fmt.Fprintf(&b, `<row r="%d">`, r)
+		for column, value := range cells {
+			if value == "" {
+				continue
+			}
+			fmt.Fprintf(&b, `<c r="%s%d" t="inlineStr"%s><is><t xml:space="preserve">%s</t></is></c>`, columnName(column), r, style, escapeXML(value))
+		}
+		b.WriteString(`</row>`)
+	}
+
+	writeRow(1, t.headers, ` s="1"`)
+	for i, row := range t.rows {
+		writeRow(i+2, row, "")
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+func escapeXML(s string) string {
+	b := strings.Builder{}
+	// never fails writing to a builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
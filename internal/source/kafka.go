@@ -0,0 +1,100 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	kafkahandler "github.com/adetunjii/google-sheets-connector/pkg/kafka-handler"
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Kafka delivers the messages of Kafka topics through a kafkahandler
+// subscriber. Messages nacked with requeue move on to the retry topics, the
+// others and those out of attempts are parked on the dead-letter topic. The
+// offset of a message is committed once it was acked, or once the retry or
+// dead-letter topic took it.
+type Kafka struct {
+	name       string
+	handler    *kafkahandler.KafkaHandler
+	topics     []string
+	retry      *kafkahandler.RetryPublisher
+	deadLetter *kafkahandler.DeadLetterPublisher
+	opts       []kafkahandler.SubscriberOption
+	logger     logger.AppLogger
+}
+
+var _ Source = (*Kafka)(nil)
+
+func NewKafka(name string, handler *kafkahandler.KafkaHandler, topics []string, retry *kafkahandler.RetryPublisher, deadLetter *kafkahandler.DeadLetterPublisher, logger logger.AppLogger, opts ...kafkahandler.SubscriberOption) *Kafka {
+	return &Kafka{
+		name:       name,
+		handler:    handler,
+		topics:     topics,
+		retry:      retry,
+		deadLetter: deadLetter,
+		opts:       opts,
+		logger:     logger,
+	}
+}
+
+func (k *Kafka) Name() string {
+	return k.name
+}
+
+func (k *Kafka) Run(ctx context.Context, handler Handler) error {
+	subscriber, err := k.handler.NewBatchSubscriber(k.topics, func(ctx context.Context, messages []*kafka.Message) error {
+		envelopes := make([]*Envelope, len(messages))
+		for i, message := range messages {
+			envelopes[i] = k.envelope(message)
+		}
+		return handler(ctx, envelopes)
+	}, k.opts...)
+	if err != nil {
+		return err
+	}
+
+	return subscriber.Run(ctx)
+}
+
+func (k *Kafka) envelope(message *kafka.Message) *Envelope {
+	ack := func() error { return nil }
+	nack := func(reason error, requeue bool) error {
+		if requeue {
+			return k.reschedule(message, reason)
+		}
+		return k.park(message, reason)
+	}
+
+	return NewEnvelope(message.TopicPartition.String(), message.Value, kafkahandler.Attempt(message), ack, nack)
+}
+
+// reschedule moves message to the next retry topic, or parks it once it has
+// run out of attempts.
+func (k *Kafka) reschedule(message *kafka.Message, reason error) error {
+	err := k.retry.Publish(message, reason)
+	if errors.Is(err, kafkahandler.ErrRetriesExhausted) {
+		return k.park(message, fmt.Errorf("gave up after %d attempts: %w", kafkahandler.Attempt(message), reason))
+	}
+
+	if err != nil {
+		k.logger.Error("failed to schedule message for retry :: stacktrace ::", err)
+		return err
+	}
+
+	k.logger.Info(fmt.Sprintf("message from %s scheduled for retry: %v", message.TopicPartition, reason))
+	return nil
+}
+
+// park moves message to the dead-letter topic. The message only counts as
+// handled once the dead-letter topic has accepted it.
+func (k *Kafka) park(message *kafka.Message, reason error) error {
+	if err := k.deadLetter.Publish(message, reason); err != nil {
+		k.logger.Error("failed to park message on dead-letter topic :: stacktrace ::", err)
+		return err
+	}
+
+	k.logger.Info(fmt.Sprintf("message from %s parked on %s: %v", message.TopicPartition, k.deadLetter.Topic(), reason))
+	return nil
+}
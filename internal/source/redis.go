@@ -0,0 +1,308 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// fields of the entries of a Redis stream
+const (
+	// FieldMessage holds the message of an entry.
+	FieldMessage = "message"
+
+	// added to the entries parked on the dead-letter stream
+	FieldErrorReason = "error_reason"
+	FieldSourceID    = "source_id"
+	FieldAttempt     = "attempt"
+	FieldFailedAt    = "failed_at"
+)
+
+const (
+	defaultRedisBatchSize  = 50
+	defaultRedisBlock      = 2 * time.Second
+	defaultRedisRetryDelay = 30 * time.Second
+	// how long Run waits after Redis failed
+	redisFailureBackoff = time.Second
+)
+
+// RedisStreamConfig describes the stream a RedisStream reads and how.
+type RedisStreamConfig struct {
+	Stream string
+	// Group is the consumer group the stream is read by, Consumer the name
+	// of this member of it.
+	Group    string
+	Consumer string
+	// DeadLetterStream receives the entries that can't be handled, defaults
+	// to <Stream>.dlq.
+	DeadLetterStream string
+	// RetryDelay is how long an entry nacked with requeue waits to be
+	// delivered again.
+	RetryDelay time.Duration
+	// MaxAttempts counts every delivery of an entry, zero means no limit.
+	MaxAttempts int
+	BatchSize   int
+	// Block is how long a read waits for new entries.
+	Block time.Duration
+}
+
+// RedisStream delivers the entries of a Redis stream, each holding a message
+// in FieldMessage, through a consumer group. Acked entries are acknowledged
+// with XACK. Entries nacked with requeue stay pending and are claimed again
+// once they were idle for the retry delay; the others, and those out of
+// attempts, are added to the dead-letter stream along with why they failed.
+// Entries are kept claimed while they are handled, so that a batch taking
+// longer than the retry delay isn't taken over by another consumer.
+type RedisStream struct {
+	client redis.UniversalClient
+	config RedisStreamConfig
+	logger logger.AppLogger
+}
+
+var _ Source = (*RedisStream)(nil)
+
+func NewRedisStream(client redis.UniversalClient, config RedisStreamConfig, logger logger.AppLogger) (*RedisStream, error) {
+	if config.Stream == "" || config.Group == "" || config.Consumer == "" {
+		return nil, errors.New("redis stream source needs a stream, a group and a consumer name")
+	}
+
+	if config.DeadLetterStream == "" {
+		config.DeadLetterStream = config.Stream + ".dlq"
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = defaultRedisRetryDelay
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultRedisBatchSize
+	}
+	// blocking forever would keep Run from ever seeing ctx cancelled
+	if config.Block <= 0 {
+		config.Block = defaultRedisBlock
+	}
+
+	return &RedisStream{
+		client: client,
+		config: config,
+		logger: logger,
+	}, nil
+}
+
+func (s *RedisStream) Name() string {
+	return "redis:" + s.config.Stream
+}
+
+// Run creates the consumer group unless it exists, starting at the beginning
+// of the stream, and hands entries to handler in batches until ctx is
+// cancelled. Entries that are due again are handed over before new ones.
+func (s *RedisStream) Run(ctx context.Context, handler Handler) error {
+	err := s.client.XGroupCreateMkStream(ctx, s.config.Stream, s.config.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		s.logger.Error("failed to create redis consumer group :: stacktrace ::", err)
+		return err
+	}
+
+	for ctx.Err() == nil {
+		envelopes, err := s.claim(ctx)
+		if err == nil && len(envelopes) == 0 {
+			envelopes, err = s.read(ctx)
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			s.logger.Error("failed to read from redis stream :: stacktrace ::", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(redisFailureBackoff):
+			}
+			continue
+		}
+
+		if len(envelopes) == 0 {
+			continue
+		}
+
+		// entries that failed to settle are still pending, and claimed again
+		release := s.keepClaimed(ctx, envelopes)
+		err = handler(ctx, envelopes)
+		release()
+
+		if err != nil {
+			s.logger.Error("failed to handle redis stream entries :: stacktrace ::", err)
+		}
+	}
+	return nil
+}
+
+// claim takes over the pending entries that have been idle for the retry
+// delay, whoever they were delivered to.
+func (s *RedisStream) claim(ctx context.Context) ([]*Envelope, error) {
+	pending, err := s.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: s.config.Stream,
+		Group:  s.config.Group,
+		Idle:   s.config.RetryDelay,
+		Start:  "-",
+		End:    "+",
+		Count:  int64(s.config.BatchSize),
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	ids := make([]string, len(pending))
+	deliveries := make(map[string]int, len(pending))
+	for i, p := range pending {
+		ids[i] = p.ID
+		deliveries[p.ID] = int(p.RetryCount)
+	}
+
+	messages, err := s.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   s.config.Stream,
+		Group:    s.config.Group,
+		Consumer: s.config.Consumer,
+		MinIdle:  s.config.RetryDelay,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	envelopes := make([]*Envelope, 0, len(messages))
+	for _, message := range messages {
+		envelopes = append(envelopes, s.envelope(message, deliveries[message.ID]+1))
+	}
+	return envelopes, nil
+}
+
+// keepClaimed claims envelopes for this consumer again every half retry delay
+// until the returned func is called, which resets how long their entries
+// have been idle without counting another delivery.
+func (s *RedisStream) keepClaimed(ctx context.Context, envelopes []*Envelope) func() {
+	ids := make([]string, len(envelopes))
+	for i, e := range envelopes {
+		ids[i] = e.ID
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(s.config.RetryDelay / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// acked entries are no longer pending and left alone
+			err := s.client.XClaimJustID(ctx, &redis.XClaimArgs{
+				Stream:   s.config.Stream,
+				Group:    s.config.Group,
+				Consumer: s.config.Consumer,
+				Messages: ids,
+			}).Err()
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("failed to keep redis stream entries claimed :: stacktrace ::", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// read returns the entries that have never been delivered to the group.
+func (s *RedisStream) read(ctx context.Context) ([]*Envelope, error) {
+	streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    s.config.Group,
+		Consumer: s.config.Consumer,
+		Streams:  []string{s.config.Stream, ">"},
+		Count:    int64(s.config.BatchSize),
+		Block:    s.config.Block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	envelopes := []*Envelope{}
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			envelopes = append(envelopes, s.envelope(message, 1))
+		}
+	}
+	return envelopes, nil
+}
+
+func (s *RedisStream) envelope(message redis.XMessage, attempt int) *Envelope {
+	body, _ := message.Values[FieldMessage].(string)
+
+	ack := func() error {
+		return s.client.XAck(context.Background(), s.config.Stream, s.config.Group, message.ID).Err()
+	}
+
+	nack := func(reason error, requeue bool) error {
+		if requeue && (s.config.MaxAttempts <= 0 || attempt < s.config.MaxAttempts) {
+			s.logger.Info(fmt.Sprintf("entry %s of %s to be delivered again in %v: %v", message.ID, s.config.Stream, s.config.RetryDelay, reason))
+			return nil
+		}
+		if requeue {
+			reason = fmt.Errorf("gave up after %d attempts: %w", attempt, reason)
+		}
+		return s.park(message.ID, body, attempt, reason)
+	}
+
+	return NewEnvelope(message.ID, []byte(body), attempt, ack, nack)
+}
+
+// park adds an entry to the dead-letter stream and then acknowledges it. The
+// streams may live on different nodes of a Redis Cluster, so this is no
+// transaction: an entry failing to be acknowledged stays pending and may be
+// parked twice, but is never lost.
+func (s *RedisStream) park(id string, body string, attempt int, reason error) error {
+	ctx := context.Background()
+
+	err := s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.config.DeadLetterStream,
+		Values: map[string]interface{}{
+			FieldMessage:     body,
+			FieldErrorReason: reason.Error(),
+			FieldSourceID:    id,
+			FieldAttempt:     strconv.Itoa(attempt),
+			FieldFailedAt:    time.Now().UTC().Format(time.RFC3339),
+		},
+	}).Err()
+	if err != nil {
+		s.logger.Error("failed to park entry on dead-letter stream :: stacktrace ::", err)
+		return err
+	}
+
+	if err := s.client.XAck(ctx, s.config.Stream, s.config.Group, id).Err(); err != nil {
+		s.logger.Error("failed to acknowledge parked entry :: stacktrace ::", err)
+		return err
+	}
+
+	s.logger.Info(fmt.Sprintf("entry %s of %s parked on %s: %v", id, s.config.Stream, s.config.DeadLetterStream, reason))
+	return nil
+}
@@ -0,0 +1,151 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func testLogger() logger.AppLogger {
+	return logger.NewLogger(zap.NewNop().Sugar())
+}
+
+// runSource runs s with handler until the test ends.
+func runSource(t *testing.T, s Source, handler Handler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- s.Run(ctx, handler) }()
+
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-stopped)
+	})
+}
+
+func TestRedisStream(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	s, err := NewRedisStream(client, RedisStreamConfig{
+		Stream:      "answers",
+		Group:       "connector",
+		Consumer:    "test",
+		RetryDelay:  20 * time.Millisecond,
+		MaxAttempts: 2,
+		Block:       10 * time.Millisecond,
+	}, testLogger())
+	require.NoError(t, err)
+
+	var (
+		mu       sync.Mutex
+		attempts = map[string][]int{}
+	)
+	handler := func(ctx context.Context, envelopes []*Envelope) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, e := range envelopes {
+			body := string(e.Body)
+			attempts[body] = append(attempts[body], e.Attempt)
+
+			switch body {
+			case "good":
+				require.NoError(t, e.Ack())
+			case "flaky":
+				require.NoError(t, e.Nack(errors.New("sheet unavailable"), true))
+			default:
+				require.NoError(t, e.Nack(errors.New("malformed"), false))
+			}
+		}
+		return nil
+	}
+	runSource(t, s, handler)
+
+	ctx := context.Background()
+	for _, body := range []string{"good", "flaky", "bad"} {
+		require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "answers", Values: map[string]interface{}{FieldMessage: body}}).Err())
+	}
+
+	// the flaky entry is delivered again once, then parked with the bad one
+	require.Eventually(t, func() bool {
+		parked, err := client.XLen(ctx, "answers.dlq").Result()
+		return err == nil && parked == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pending, err := client.XPending(ctx, "answers", "connector").Result()
+	require.NoError(t, err)
+	require.Zero(t, pending.Count)
+
+	mu.Lock()
+	require.Equal(t, map[string][]int{"good": {1}, "flaky": {1, 2}, "bad": {1}}, attempts)
+	mu.Unlock()
+
+	parked, err := client.XRange(ctx, "answers.dlq", "-", "+").Result()
+	require.NoError(t, err)
+
+	reasons := map[string]interface{}{}
+	for _, entry := range parked {
+		reasons[entry.Values[FieldMessage].(string)] = entry.Values[FieldErrorReason]
+	}
+	require.Equal(t, map[string]interface{}{"flaky": "gave up after 2 attempts: sheet unavailable", "bad": "malformed"}, reasons)
+}
+
+func TestRedisStreamKeepsEntriesClaimed(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	var (
+		mu         sync.Mutex
+		deliveries []string
+	)
+	// handling the entry takes several retry delays, during which the other
+	// consumer must not take it over
+	handler := func(ctx context.Context, envelopes []*Envelope) error {
+		for _, e := range envelopes {
+			mu.Lock()
+			deliveries = append(deliveries, string(e.Body))
+			mu.Unlock()
+
+			time.Sleep(100 * time.Millisecond)
+			require.NoError(t, e.Ack())
+		}
+		return nil
+	}
+
+	for _, consumer := range []string{"first", "second"} {
+		s, err := NewRedisStream(client, RedisStreamConfig{
+			Stream:     "answers",
+			Group:      "connector",
+			Consumer:   consumer,
+			RetryDelay: 20 * time.Millisecond,
+			Block:      10 * time.Millisecond,
+		}, testLogger())
+		require.NoError(t, err)
+		runSource(t, s, handler)
+	}
+
+	ctx := context.Background()
+	require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "answers", Values: map[string]interface{}{FieldMessage: "slow"}}).Err())
+
+	require.Eventually(t, func() bool {
+		pending, err := client.XPending(ctx, "answers", "connector").Result()
+		mu.Lock()
+		defer mu.Unlock()
+		return err == nil && pending.Count == 0 && len(deliveries) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	require.Equal(t, []string{"slow"}, deliveries)
+	mu.Unlock()
+}
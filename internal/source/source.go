@@ -0,0 +1,111 @@
+// Package source delivers questionnaire messages to the connector from the
+// brokers and endpoints they are sent to: Kafka, Redis streams and a webhook.
+// Every message is handed over in an Envelope the handler settles once it is
+// done with it.
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+)
+
+var ErrAlreadySettled = errors.New("message was already acked or nacked")
+
+// Envelope carries the body of a message, a model.GoogleSheetKafkaMessage
+// encoded as JSON, from its source. It must be settled exactly once, with Ack
+// when it was handled and with Nack when it wasn't.
+type Envelope struct {
+	// ID identifies the message at its source, e.g. a Kafka offset or a stream
+	// entry ID.
+	ID   string
+	Body []byte
+	// Attempt counts the deliveries of the message, including this one.
+	Attempt int
+
+	mu      sync.Mutex
+	settled bool
+	ack     func() error
+	nack    func(reason error, requeue bool) error
+}
+
+// NewEnvelope returns an envelope for a source that is settled with ack and
+// nack, which are called at most once between them.
+func NewEnvelope(id string, body []byte, attempt int, ack func() error, nack func(reason error, requeue bool) error) *Envelope {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	return &Envelope{
+		ID:      id,
+		Body:    body,
+		Attempt: attempt,
+		ack:     ack,
+		nack:    nack,
+	}
+}
+
+// Ack tells the source the message was handled and is not to be delivered
+// again.
+func (e *Envelope) Ack() error {
+	if err := e.settle(); err != nil {
+		return err
+	}
+	return e.ack()
+}
+
+// Nack tells the source the message could not be handled because of reason.
+// With requeue the source delivers it again later, until it runs out of
+// attempts; without, the source parks it as one that can never be handled,
+// e.g. on a dead-letter topic.
+func (e *Envelope) Nack(reason error, requeue bool) error {
+	if err := e.settle(); err != nil {
+		return err
+	}
+	return e.nack(reason, requeue)
+}
+
+func (e *Envelope) settle() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.settled {
+		return ErrAlreadySettled
+	}
+	e.settled = true
+	return nil
+}
+
+// Handler handles envelopes and settles each of them. An error means settling
+// failed for at least one of them, the source then delivers them again.
+type Handler func(ctx context.Context, envelopes []*Envelope) error
+
+// Source delivers messages to a handler.
+type Source interface {
+	// Name identifies the source in logs and config.
+	Name() string
+	// Run hands messages to handler until ctx is cancelled or the source
+	// fails for good.
+	Run(ctx context.Context, handler Handler) error
+}
+
+// Run runs every source with handler and returns once they all stopped.
+// Sources that fail are logged, the others keep running.
+func Run(ctx context.Context, sources []Source, handler Handler, logger logger.AppLogger) {
+	var wg sync.WaitGroup
+	for _, s := range sources {
+		wg.Add(1)
+		go func(s Source) {
+			defer wg.Done()
+
+			logger.Info(fmt.Sprintf("receiving messages from %s", s.Name()))
+			if err := s.Run(ctx, handler); err != nil {
+				logger.Error(fmt.Sprintf("source %s stopped :: stacktrace ::", s.Name()), err)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
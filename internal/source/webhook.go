@@ -0,0 +1,177 @@
+package source
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/adetunjii/google-sheets-connector/pkg/utils/httputils"
+	"github.com/google/uuid"
+)
+
+// headers of webhook requests
+const (
+	// HeaderSignature holds "sha256=" and the hex HMAC-SHA256 of the body
+	// under the webhook's secret.
+	HeaderSignature = "X-Signature"
+	// HeaderAttempt counts the sender's deliveries of the body.
+	HeaderAttempt = "X-Attempt"
+)
+
+// maxWebhookBody is the largest message the webhook accepts.
+const maxWebhookBody = 1 << 20
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Webhook receives messages POSTed to it, one per request. The request is
+// answered once the message was settled: 200 when it was acked, 422 when it
+// was nacked for good and 503 with Retry-After when the sender should deliver
+// it again.
+type Webhook struct {
+	secret     []byte
+	retryAfter int
+
+	mu      sync.RWMutex
+	ctx     context.Context
+	handler Handler
+	logger  logger.AppLogger
+}
+
+var (
+	_ Source       = (*Webhook)(nil)
+	_ http.Handler = (*Webhook)(nil)
+)
+
+// NewWebhook returns a webhook that only accepts requests signed with secret,
+// see HeaderSignature, and none when secret is empty. Senders are asked to
+// retry after retryAfterSeconds.
+func NewWebhook(secret []byte, retryAfterSeconds int, logger logger.AppLogger) *Webhook {
+	return &Webhook{
+		secret:     secret,
+		retryAfter: retryAfterSeconds,
+		logger:     logger,
+	}
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Run hands the messages of requests to handler until ctx is cancelled.
+// Requests are refused with 503 when the webhook isn't running.
+func (w *Webhook) Run(ctx context.Context, handler Handler) error {
+	w.mu.Lock()
+	w.ctx, w.handler = ctx, handler
+	w.mu.Unlock()
+
+	<-ctx.Done()
+
+	w.mu.Lock()
+	w.ctx, w.handler = nil, nil
+	w.mu.Unlock()
+	return nil
+}
+
+// outcome is how the handler settled the message of a request.
+type outcome struct {
+	settled bool
+	reason  error
+	requeue bool
+}
+
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	res := httputils.NewResponseWriter(rw)
+
+	w.mu.RLock()
+	ctx, handler := w.ctx, w.handler
+	w.mu.RUnlock()
+
+	if handler == nil {
+		w.unavailable(rw, errors.New("not receiving messages"))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, maxWebhookBody))
+	if err != nil {
+		res.Error(err, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := w.verify(body, r.Header.Get(HeaderSignature)); err != nil {
+		res.Error(err, http.StatusUnauthorized)
+		return
+	}
+
+	attempt, _ := strconv.Atoi(r.Header.Get(HeaderAttempt))
+
+	var result outcome
+	ack := func() error {
+		result = outcome{settled: true}
+		return nil
+	}
+	nack := func(reason error, requeue bool) error {
+		result = outcome{settled: true, reason: reason, requeue: requeue}
+		return nil
+	}
+
+	envelope := NewEnvelope(uuid.NewString(), body, attempt, ack, nack)
+	if err := handler(ctx, []*Envelope{envelope}); err != nil {
+		w.logger.Error("failed to handle webhook message :: stacktrace ::", err)
+		w.unavailable(rw, err)
+		return
+	}
+
+	switch {
+	case !result.settled:
+		w.unavailable(rw, errors.New("message was not handled"))
+	case result.reason == nil:
+		bytes, _ := json.Marshal(map[string]string{"id": envelope.ID})
+		res.WriteJSON(bytes)
+	case result.requeue:
+		w.unavailable(rw, result.reason)
+	default:
+		res.Error(result.reason, http.StatusUnprocessableEntity)
+	}
+}
+
+// unavailable asks the sender to deliver the message again later.
+func (w *Webhook) unavailable(rw http.ResponseWriter, reason error) {
+	if w.retryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(w.retryAfter))
+	}
+	httputils.NewResponseWriter(rw).Error(reason, http.StatusServiceUnavailable)
+}
+
+func (w *Webhook) verify(body []byte, signature string) error {
+	// no signature can be checked without a secret
+	if len(w.secret) == 0 {
+		return ErrInvalidSignature
+	}
+
+	if !strings.HasPrefix(signature, "sha256=") {
+		return ErrInvalidSignature
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !hmac.Equal(got, Sign(w.secret, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sign returns the HMAC-SHA256 of body under secret, which senders put in
+// HeaderSignature hex encoded after "sha256=".
+func Sign(secret []byte, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
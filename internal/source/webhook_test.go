@@ -0,0 +1,98 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	secret := []byte("secret")
+	webhook := NewWebhook(secret, 30, testLogger())
+
+	post := func(body string, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewBufferString(body))
+		req.Header.Set(HeaderSignature, signature)
+		res := httptest.NewRecorder()
+		webhook.ServeHTTP(res, req)
+		return res
+	}
+	sign := func(body string) string {
+		return "sha256=" + hex.EncodeToString(Sign(secret, []byte(body)))
+	}
+
+	// nothing is received before the webhook runs
+	res := post("good", sign("good"))
+	require.Equal(t, http.StatusServiceUnavailable, res.Code)
+
+	runSource(t, webhook, func(ctx context.Context, envelopes []*Envelope) error {
+		for _, e := range envelopes {
+			switch string(e.Body) {
+			case "good":
+				require.NoError(t, e.Ack())
+			case "flaky":
+				require.NoError(t, e.Nack(errors.New("sheet unavailable"), true))
+			case "bad":
+				require.NoError(t, e.Nack(errors.New("malformed"), false))
+			}
+		}
+		return nil
+	})
+	require.Eventually(t, func() bool {
+		return post("good", sign("good")).Code == http.StatusOK
+	}, time.Second, time.Millisecond)
+
+	tests := []struct {
+		name       string
+		body       string
+		signature  string
+		status     int
+		retryAfter string
+	}{
+		{name: "acked", body: "good", signature: sign("good"), status: http.StatusOK},
+		{name: "requeued", body: "flaky", signature: sign("flaky"), status: http.StatusServiceUnavailable, retryAfter: "30"},
+		{name: "parked", body: "bad", signature: sign("bad"), status: http.StatusUnprocessableEntity},
+		{name: "never settled", body: "other", signature: sign("other"), status: http.StatusServiceUnavailable, retryAfter: "30"},
+		{name: "unsigned", body: "good", status: http.StatusUnauthorized},
+		{name: "signed by someone else", body: "good", signature: sign("bad"), status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := post(tt.body, tt.signature)
+			require.Equal(t, tt.status, res.Code)
+			require.Equal(t, tt.retryAfter, res.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	webhook := NewWebhook(nil, 30, testLogger())
+	runSource(t, webhook, func(ctx context.Context, envelopes []*Envelope) error {
+		for _, e := range envelopes {
+			require.NoError(t, e.Ack())
+		}
+		return nil
+	})
+
+	post := func(signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/messages", bytes.NewBufferString("good"))
+		req.Header.Set(HeaderSignature, signature)
+		res := httptest.NewRecorder()
+		webhook.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	// no request can be verified, so none is accepted
+	require.Eventually(t, func() bool {
+		return post("") == http.StatusUnauthorized
+	}, time.Second, time.Millisecond)
+	require.Equal(t, http.StatusUnauthorized, post("sha256="+hex.EncodeToString(Sign(nil, []byte("good")))))
+}
@@ -0,0 +1,382 @@
+// Package boltdb stores integrations and sealed OAuth tokens in an embedded
+// BoltDB file.
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/store"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	integrationsBucket = []byte("integrations")
+	// org and form ID -> integration ID
+	formsBucket = []byte("integrations_by_form")
+	// token ID -> sealed token, see google.TokenStore
+	tokensBucket = []byte("tokens")
+	// integration ID, time and entry ID -> audit entry
+	auditBucket = []byte("audit")
+	// integration ID -> bucket of held messages by sequence
+	heldBucket = []byte("held")
+)
+
+type Store struct {
+	db *bolt.DB
+}
+
+var (
+	_ store.IntegrationStore = (*Store)(nil)
+	_ store.AuditLog         = (*Store)(nil)
+	_ store.HoldStore        = (*Store)(nil)
+)
+
+// Open opens the database at path, creating it when it doesn't exist.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{integrationsBucket, formsBucket, tokensBucket, auditBucket, heldBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func formKey(orgID string, formID string) []byte {
+	return []byte(orgID + "\x00" + formID)
+}
+
+func (s *Store) Create(ctx context.Context, integration *model.Integration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		forms := tx.Bucket(formsBucket)
+
+		key := formKey(integration.OrgID, integration.FormID)
+		if forms.Get(key) != nil {
+			return store.ErrConflict
+		}
+
+		integration.ID = uuid.NewString()
+		integration.CreatedAt = time.Now().UTC()
+		integration.UpdatedAt = integration.CreatedAt
+
+		if err := put(tx, integration); err != nil {
+			return err
+		}
+		return forms.Put(key, []byte(integration.ID))
+	})
+}
+
+func (s *Store) Get(ctx context.Context, id string) (*model.Integration, error) {
+	var integration *model.Integration
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		integration, err = get(tx, []byte(id))
+		return err
+	})
+	return integration, err
+}
+
+func (s *Store) FindByForm(ctx context.Context, orgID string, formID string) (*model.Integration, error) {
+	var integration *model.Integration
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(formsBucket).Get(formKey(orgID, formID))
+		if id == nil {
+			return store.ErrNotFound
+		}
+
+		var err error
+		integration, err = get(tx, id)
+		return err
+	})
+	return integration, err
+}
+
+func (s *Store) List(ctx context.Context, filter store.Filter) ([]*model.Integration, error) {
+	integrations := []*model.Integration{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(integrationsBucket).ForEach(func(_, value []byte) error {
+			integration := &model.Integration{}
+			if err := json.Unmarshal(value, integration); err != nil {
+				return err
+			}
+
+			if filter.Matches(integration) {
+				integrations = append(integrations, integration)
+			}
+			return nil
+		})
+	})
+	return integrations, err
+}
+
+func (s *Store) Update(ctx context.Context, integration *model.Integration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := get(tx, []byte(integration.ID))
+		if err != nil {
+			return err
+		}
+
+		// moving the integration to another form
+		forms := tx.Bucket(formsBucket)
+		oldKey := formKey(stored.OrgID, stored.FormID)
+		newKey := formKey(integration.OrgID, integration.FormID)
+
+		if string(oldKey) != string(newKey) {
+			if forms.Get(newKey) != nil {
+				return store.ErrConflict
+			}
+			if err := forms.Delete(oldKey); err != nil {
+				return err
+			}
+			if err := forms.Put(newKey, []byte(integration.ID)); err != nil {
+				return err
+			}
+		}
+
+		integration.Parts = stored.Parts
+		if integration.SpreadSheetID != stored.SpreadSheetID {
+			integration.Parts = nil
+			if integration.SpreadSheetID != "" {
+				integration.Parts = []string{integration.SpreadSheetID}
+			}
+		}
+
+		integration.CreatedAt = stored.CreatedAt
+		integration.UpdatedAt = time.Now().UTC()
+		return put(tx, integration)
+	})
+}
+
+func (s *Store) Modify(ctx context.Context, filter store.Filter, fn func(integration *model.Integration) bool) ([]*model.Integration, error) {
+	changed := []*model.Integration{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		// the bucket must not be written to while it is iterated over
+		matching := []*model.Integration{}
+		err := tx.Bucket(integrationsBucket).ForEach(func(_, value []byte) error {
+			integration := &model.Integration{}
+			if err := json.Unmarshal(value, integration); err != nil {
+				return err
+			}
+
+			if filter.Matches(integration) {
+				matching = append(matching, integration)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, integration := range matching {
+			if !fn(integration) {
+				continue
+			}
+
+			integration.UpdatedAt = time.Now().UTC()
+			if err := put(tx, integration); err != nil {
+				return err
+			}
+			changed = append(changed, integration)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := get(tx, []byte(id))
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(formsBucket).Delete(formKey(stored.OrgID, stored.FormID)); err != nil {
+			return err
+		}
+		return tx.Bucket(integrationsBucket).Delete([]byte(id))
+	})
+}
+
+func get(tx *bolt.Tx, id []byte) (*model.Integration, error) {
+	value := tx.Bucket(integrationsBucket).Get(id)
+	if value == nil {
+		return nil, store.ErrNotFound
+	}
+
+	integration := &model.Integration{}
+	if err := json.Unmarshal(value, integration); err != nil {
+		return nil, err
+	}
+	return integration, nil
+}
+
+func put(tx *bolt.Tx, integration *model.Integration) error {
+	value, err := json.Marshal(integration)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(integrationsBucket).Put([]byte(integration.ID), value)
+}
+
+func (s *Store) GetToken(ctx context.Context, id string) ([]byte, error) {
+	var sealed []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// values are only valid for the life of the transaction
+		if value := tx.Bucket(tokensBucket).Get([]byte(id)); value != nil {
+			sealed = append([]byte{}, value...)
+		}
+		return nil
+	})
+	return sealed, err
+}
+
+func (s *Store) PutToken(ctx context.Context, id string, sealed []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).Put([]byte(id), sealed)
+	})
+}
+
+func (s *Store) DeleteToken(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).Delete([]byte(id))
+	})
+}
+
+func (s *Store) TokenIDs(ctx context.Context) ([]string, error) {
+	ids := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).ForEach(func(key, _ []byte) error {
+			ids = append(ids, string(key))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+func (s *Store) Record(ctx context.Context, entry *model.AuditEntry) error {
+	entry.ID = uuid.NewString()
+	if entry.At.IsZero() {
+		entry.At = time.Now().UTC()
+	}
+
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// keys of an integration sort by time
+	key := append(auditPrefix(entry.IntegrationID), []byte(entry.At.UTC().Format("20060102T150405.000000000")+"\x00"+entry.ID)...)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).Put(key, value)
+	})
+}
+
+func (s *Store) AuditEntries(ctx context.Context, integrationID string) ([]*model.AuditEntry, error) {
+	entries := []*model.AuditEntry{}
+	prefix := auditPrefix(integrationID)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		for key, value := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = c.Next() {
+			entry := &model.AuditEntry{}
+			if err := json.Unmarshal(value, entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func auditPrefix(integrationID string) []byte {
+	return []byte(integrationID + "\x00")
+}
+
+func (s *Store) Hold(ctx context.Context, integrationID string, body []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		held, err := tx.Bucket(heldBucket).CreateBucketIfNotExists([]byte(integrationID))
+		if err != nil {
+			return err
+		}
+
+		seq, err := held.NextSequence()
+		if err != nil {
+			return err
+		}
+		// keys sort in the order the messages were held
+		return held.Put([]byte(fmt.Sprintf("%016x", seq)), body)
+	})
+}
+
+func (s *Store) Held(ctx context.Context, integrationID string, limit int) ([]*store.HeldMessage, error) {
+	messages := []*store.HeldMessage{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		held := tx.Bucket(heldBucket).Bucket([]byte(integrationID))
+		if held == nil {
+			return nil
+		}
+
+		c := held.Cursor()
+		for key, value := c.First(); key != nil && len(messages) < limit; key, value = c.Next() {
+			messages = append(messages, &store.HeldMessage{ID: string(key), Body: append([]byte{}, value...)})
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (s *Store) Release(ctx context.Context, integrationID string, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		held := tx.Bucket(heldBucket).Bucket([]byte(integrationID))
+		if held == nil {
+			return nil
+		}
+
+		if err := held.Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		// integrations without held messages are not listed
+		if key, _ := held.Cursor().First(); key == nil {
+			return tx.Bucket(heldBucket).DeleteBucket([]byte(integrationID))
+		}
+		return nil
+	})
+}
+
+func (s *Store) HeldIntegrations(ctx context.Context) ([]string, error) {
+	ids := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(heldBucket).ForEach(func(key, _ []byte) error {
+			ids = append(ids, string(key))
+			return nil
+		})
+	})
+	return ids, err
+}
@@ -0,0 +1,150 @@
+package boltdb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+	"github.com/adetunjii/google-sheets-connector/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+func openStore(t *testing.T) *Store {
+	s, err := Open(filepath.Join(t.TempDir(), "integrations.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func integration(orgID string, formID string) *model.Integration {
+	return &model.Integration{
+		OrgID:         orgID,
+		FormID:        formID,
+		SpreadSheetID: "spreadsheet-" + formID,
+		Owner:         "owner@example.com",
+		Status:        model.IntegrationActive,
+	}
+}
+
+func TestStoreCRUD(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+
+	created := integration("org-1", "form-1")
+	require.NoError(t, s.Create(ctx, created))
+	require.NotEmpty(t, created.ID)
+	require.False(t, created.CreatedAt.IsZero())
+
+	require.ErrorIs(t, s.Create(ctx, integration("org-1", "form-1")), store.ErrConflict)
+	require.NoError(t, s.Create(ctx, integration("org-1", "form-2")))
+	require.NoError(t, s.Create(ctx, integration("org-2", "form-1")))
+
+	found, err := s.FindByForm(ctx, "org-1", "form-1")
+	require.NoError(t, err)
+	require.Equal(t, created.ID, found.ID)
+	require.Equal(t, "spreadsheet-form-1", found.SpreadSheetID)
+
+	listed, err := s.List(ctx, store.Filter{OrgID: "org-1"})
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+
+	modified, err := s.Modify(ctx, store.Filter{SpreadSheetID: "spreadsheet-form-1"}, func(i *model.Integration) bool {
+		i.Parts = []string{"spreadsheet-form-1", "part-2"}
+		return i.OrgID == "org-1"
+	})
+	require.NoError(t, err)
+	require.Len(t, modified, 1)
+	require.Equal(t, created.ID, modified[0].ID)
+
+	// the parts are the store's to keep, whatever an update carries
+	found.FormID = "form-3"
+	found.Parts = nil
+	require.NoError(t, s.Update(ctx, found))
+
+	_, err = s.FindByForm(ctx, "org-1", "form-1")
+	require.ErrorIs(t, err, store.ErrNotFound)
+
+	moved, err := s.FindByForm(ctx, "org-1", "form-3")
+	require.NoError(t, err)
+	require.Equal(t, []string{"spreadsheet-form-1", "part-2"}, moved.Parts)
+	require.Equal(t, created.CreatedAt, moved.CreatedAt)
+
+	moved.FormID = "form-2"
+	require.ErrorIs(t, s.Update(ctx, moved), store.ErrConflict)
+
+	// another spreadsheet starts over
+	moved.FormID = "form-3"
+	moved.SpreadSheetID = "spreadsheet-new"
+	require.NoError(t, s.Update(ctx, moved))
+	require.Equal(t, []string{"spreadsheet-new"}, moved.Parts)
+
+	require.NoError(t, s.Delete(ctx, created.ID))
+	_, err = s.Get(ctx, created.ID)
+	require.ErrorIs(t, err, store.ErrNotFound)
+	require.ErrorIs(t, s.Delete(ctx, created.ID), store.ErrNotFound)
+
+	_, err = s.FindByForm(ctx, "org-1", "form-3")
+	require.ErrorIs(t, err, store.ErrNotFound)
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+
+	at := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Record(ctx, &model.AuditEntry{IntegrationID: "b", Action: model.AuditDisconnected, At: at}))
+	require.NoError(t, s.Record(ctx, &model.AuditEntry{IntegrationID: "a", Action: model.AuditDisconnected, Detail: "second", At: at.Add(time.Hour)}))
+	require.NoError(t, s.Record(ctx, &model.AuditEntry{IntegrationID: "a", Action: model.AuditDisconnected, Detail: "first", At: at}))
+
+	entries, err := s.AuditEntries(ctx, "a")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "first", entries[0].Detail)
+	require.Equal(t, "second", entries[1].Detail)
+	require.NotEmpty(t, entries[0].ID)
+
+	entries, err = s.AuditEntries(ctx, "c")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestHoldStore(t *testing.T) {
+	ctx := context.Background()
+	s := openStore(t)
+
+	for _, body := range []string{"first", "second", "third"} {
+		require.NoError(t, s.Hold(ctx, "a", []byte(body)))
+	}
+	require.NoError(t, s.Hold(ctx, "b", []byte("other")))
+
+	ids, err := s.HeldIntegrations(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "b"}, ids)
+
+	held, err := s.Held(ctx, "a", 2)
+	require.NoError(t, err)
+	require.Len(t, held, 2)
+	require.Equal(t, "first", string(held[0].Body))
+	require.Equal(t, "second", string(held[1].Body))
+
+	require.NoError(t, s.Release(ctx, "a", held[0].ID))
+	held, err = s.Held(ctx, "a", 10)
+	require.NoError(t, err)
+	require.Len(t, held, 2)
+	require.Equal(t, "second", string(held[0].Body))
+
+	// an integration is no longer listed once all its messages are released
+	held, err = s.Held(ctx, "b", 10)
+	require.NoError(t, err)
+	require.NoError(t, s.Release(ctx, "b", held[0].ID))
+
+	ids, err = s.HeldIntegrations(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, ids)
+
+	held, err = s.Held(ctx, "c", 10)
+	require.NoError(t, err)
+	require.Empty(t, held)
+}
@@ -0,0 +1,82 @@
+// Package store keeps the integrations of the connector.
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/adetunjii/google-sheets-connector/internal/model"
+)
+
+var (
+	ErrNotFound = errors.New("integration not found")
+	ErrConflict = errors.New("an integration for the form already exists")
+)
+
+// Filter narrows a listing of integrations down. Empty fields match every
+// integration.
+type Filter struct {
+	ID            string
+	OrgID         string
+	FormID        string
+	SpreadSheetID string
+	TokenID       string
+}
+
+func (f Filter) Matches(integration *model.Integration) bool {
+	return (f.ID == "" || f.ID == integration.ID) &&
+		(f.OrgID == "" || f.OrgID == integration.OrgID) &&
+		(f.FormID == "" || f.FormID == integration.FormID) &&
+		(f.SpreadSheetID == "" || f.SpreadSheetID == integration.SpreadSheetID) &&
+		(f.TokenID == "" || f.TokenID == integration.TokenID)
+}
+
+// IntegrationStore persists integrations. A form of an organisation has at
+// most one integration.
+type IntegrationStore interface {
+	// Create stores a new integration, giving it an ID and its creation time.
+	Create(ctx context.Context, integration *model.Integration) error
+	Get(ctx context.Context, id string) (*model.Integration, error)
+	// FindByForm returns the integration of the form of an organisation.
+	FindByForm(ctx context.Context, orgID string, formID string) (*model.Integration, error)
+	List(ctx context.Context, filter Filter) ([]*model.Integration, error)
+	// Update replaces the stored integration with the same ID. Its Parts are
+	// kept by the store: they stay as stored, unless the integration moved to
+	// another spreadsheet, which starts them over.
+	Update(ctx context.Context, integration *model.Integration) error
+	// Modify calls fn with every integration matching filter and stores
+	// those it reports to have changed, all in one transaction so that no
+	// concurrent update is lost in between. fn must not move an integration
+	// to another form. The changed integrations are returned.
+	Modify(ctx context.Context, filter Filter, fn func(integration *model.Integration) bool) ([]*model.Integration, error)
+	Delete(ctx context.Context, id string) error
+	Close() error
+}
+
+// AuditLog keeps a record of what was done to integrations.
+type AuditLog interface {
+	// Record stores entry, giving it an ID and, without one, the current time.
+	Record(ctx context.Context, entry *model.AuditEntry) error
+	// AuditEntries returns the entries of an integration, oldest first.
+	AuditEntries(ctx context.Context, integrationID string) ([]*model.AuditEntry, error)
+}
+
+// HeldMessage is a message put aside until its integration is active again.
+type HeldMessage struct {
+	ID   string
+	Body []byte
+}
+
+// HoldStore keeps the messages of integrations that are not active, in the
+// order they were held, until they can be written.
+type HoldStore interface {
+	// Hold puts body aside for an integration.
+	Hold(ctx context.Context, integrationID string, body []byte) error
+	// Held returns up to limit of the messages held for an integration,
+	// oldest first.
+	Held(ctx context.Context, integrationID string, limit int) ([]*HeldMessage, error)
+	// Release removes a held message of an integration.
+	Release(ctx context.Context, integrationID string, id string) error
+	// HeldIntegrations returns the IDs of the integrations with held messages.
+	HeldIntegrations(ctx context.Context) ([]string, error)
+}
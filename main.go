@@ -2,24 +2,33 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/adetunjii/google-sheets-connector/internal/handler/httphandler"
+	"github.com/adetunjii/google-sheets-connector/internal/handler/messagehandler"
 	"github.com/adetunjii/google-sheets-connector/internal/model"
 	"github.com/adetunjii/google-sheets-connector/internal/services/google"
+	"github.com/adetunjii/google-sheets-connector/internal/sink"
+	"github.com/adetunjii/google-sheets-connector/internal/source"
+	"github.com/adetunjii/google-sheets-connector/internal/store"
+	"github.com/adetunjii/google-sheets-connector/internal/store/boltdb"
 	kafkahandler "github.com/adetunjii/google-sheets-connector/pkg/kafka-handler"
 	"github.com/adetunjii/google-sheets-connector/pkg/logger"
 	"github.com/adetunjii/google-sheets-connector/pkg/monitoring"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
 )
 
 func main() {
@@ -38,55 +47,149 @@ func main() {
 	sg := logger.NewZapSugarLogger()
 	logger := logger.NewLogger(sg)
 
+	// setup metrics and monitoring
+	metrics := monitoring.NewMetricsWrapper(
+		monitoring.ServiceName("google-sheets-connector"),
+		monitoring.ServiceMetricsLabelPrefix("gsc"),
+	)
+
+	integrations, err := boltdb.Open(viper.GetString("INTEGRATIONS_DB_PATH"))
+	if err != nil {
+		logger.Fatal("failed to open integration store :: stacktrace :: ", err)
+	}
+	defer integrations.Close()
+
+	tokenKeys, err := google.ParseTokenKeys(viper.GetString("GOOGLE_TOKEN_KEYS"))
+	if err != nil {
+		logger.Fatal("failed to read token encryption keys :: stacktrace :: ", err)
+	}
+
+	tokens, err := google.NewTokenStore(integrations, tokenKeys)
+	if err != nil {
+		logger.Fatal("failed to create token store :: stacktrace :: ", err)
+	}
+
+	// tokens sealed with a retired key are sealed again with the current one
+	if rotated, err := tokens.Rotate(context.Background()); err != nil {
+		logger.Error("failed to rotate token keys :: stacktrace :: ", err)
+	} else if rotated > 0 {
+		logger.Info(fmt.Sprintf("rotated token keys of %d tokens", rotated))
+	}
+
+	// without a key login states are signed with one made up at startup, which
+	// only works for a single instance
+	loginStateKey, err := base64.StdEncoding.DecodeString(viper.GetString("GOOGLE_OAUTH_STATE_KEY"))
+	if err != nil {
+		logger.Fatal("failed to read login state key :: stacktrace :: ", err)
+	}
+
+	rateLimits := google.RateLimits{
+		ProjectRPS:   viper.GetFloat64("GOOGLE_SHEETS_PROJECT_RPS"),
+		ProjectBurst: viper.GetInt("GOOGLE_SHEETS_PROJECT_BURST"),
+		UserRPS:      viper.GetFloat64("GOOGLE_SHEETS_USER_RPS"),
+		UserBurst:    viper.GetInt("GOOGLE_SHEETS_USER_BURST"),
+		MaxRetries:   viper.GetInt("GOOGLE_SHEETS_MAX_RETRIES"),
+		MinBackoff:   viper.GetDuration("GOOGLE_SHEETS_MIN_BACKOFF"),
+		MaxBackoff:   viper.GetDuration("GOOGLE_SHEETS_MAX_BACKOFF"),
+	}
+	if err := rateLimits.Validate(); err != nil {
+		logger.Fatal("invalid sheets rate limits :: stacktrace :: ", err)
+	}
+
 	googleClient := google.NewGoogleClient(
 		google_client_id,
 		google_client_secret,
 		google_scopes,
 		google_callback_url,
 		logger,
+		google.WithRateLimits(rateLimits),
+		google.WithRolloverThreshold(viper.GetInt64("GOOGLE_SHEETS_ROLLOVER_CELLS")),
+		google.WithRolloverHook(recordParts(integrations, logger)),
+		google.WithTokenStore(tokens),
+		google.WithTokenRevokedHook(requireReauthorization(integrations, logger)),
+		google.WithLoginStateKey(loginStateKey),
+		google.WithLoginTTL(viper.GetDuration("GOOGLE_OAUTH_LOGIN_TTL")),
+		google.WithConsentPrompt(viper.GetString("GOOGLE_OAUTH_PROMPT")),
+		google.WithReturnURLs(strings.Split(viper.GetString("GOOGLE_OAUTH_RETURN_URLS"), ",")...),
+		google.WithRateLimitMetrics(metrics),
+		// empty unless Google is stood in for, e.g. by googletest
+		google.WithOAuthEndpoint(oauth2.Endpoint{
+			AuthURL:  viper.GetString("GOOGLE_OAUTH_AUTH_URL"),
+			TokenURL: viper.GetString("GOOGLE_OAUTH_TOKEN_URL"),
+		}),
+		google.WithRevocationURL(viper.GetString("GOOGLE_OAUTH_REVOKE_URL")),
+		google.WithSheetsEndpoint(viper.GetString("GOOGLE_SHEETS_ENDPOINT")),
 	)
 
-	// setup metrics and monitoring
-	metrics := monitoring.NewMetricsWrapper(
-		monitoring.ServiceName("google-sheets-connector"),
-		monitoring.ServiceMetricsLabelPrefix("gsc"),
+	// integrations without Google write their exports under one directory
+	sinkDir := viper.GetString("FILE_SINK_DIR")
+	messageHandler := messagehandler.New(googleClient, integrations, integrations, logger,
+		messagehandler.WithSink(model.SinkXLSX, sink.NewXLSX(sinkDir, logger)),
+		messagehandler.WithSink(model.SinkCSV, sink.NewCSV(sinkDir, logger)),
 	)
 
-	// setup kafka
-	kafkahandler := setupKafka(logger)
-	kafkaTopics := viper.GetStringSlice("KAFKA_TOPICS")
-	kafkaConsumer, err := kafkahandler.NewConsumer()
-	if err != nil {
-		logger.Error("failed to create kafka consumer :: stacktrace :: ", err)
-	}
+	router := mux.NewRouter()
 
-	go func() {
-		km := model.GoogleSheetKafkaMessage{}
-		message, err := kafkahandler.Subscribe(kafkaConsumer, kafkaTopics)
-		if err != nil {
-			logger.Error("topic failed subscription failed :: stacktrace :: ", err)
+	// every source feeds the same handler, so messages are written the same
+	// way whichever broker or endpoint they came through
+	var sources []source.Source
+	for _, name := range strings.Split(viper.GetString("SOURCES"), ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+			// left by a stray comma
+		case "kafka":
+			kafkaSources, closeKafka := setupKafkaSources(metrics, logger)
+			defer closeKafka()
+			sources = append(sources, kafkaSources...)
+		case "webhook":
+			secret := viper.GetString("WEBHOOK_SECRET")
+			if secret == "" {
+				logger.Fatal("the webhook source needs WEBHOOK_SECRET :: stacktrace :: ", errors.New("no webhook secret configured"))
+			}
+			webhook := source.NewWebhook([]byte(secret), viper.GetInt("WEBHOOK_RETRY_AFTER"), logger)
+			router.Path("/api/google-sheets/messages").Handler(webhook).Methods(http.MethodPost)
+			sources = append(sources, webhook)
+		case "redis":
+			stream, closeRedis := setupRedisSource(logger)
+			defer closeRedis()
+			sources = append(sources, stream)
+		default:
+			logger.Fatal("unknown message source :: stacktrace :: ", fmt.Errorf("%q is not one of kafka, webhook or redis", name))
 		}
+	}
 
-		if err := json.Unmarshal(message.Value, &km); err != nil {
-			logger.Error("failed to parse message from broker :: stacktrace ::", err)
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		googleSheetClient := google.NewGoogleSheetClient(googleClient, km.Token, logger)
+	consumers := make(chan struct{})
+	go func() {
+		defer close(consumers)
+		source.Run(ctx, sources, messageHandler.HandleBatch, logger)
+	}()
 
-		writeErr := googleSheetClient.WriteToSheet(km.SpreadSheetID, &km.Questionnaire)
-		if writeErr != nil {
-			logger.Error("failed to write message to google sheets :: stacktrace ::", writeErr)
-		}
+	// answers held for inactive integrations are written once they are active
+	// again
+	releases := make(chan struct{})
+	go func() {
+		defer close(releases)
+		messageHandler.ReleaseHeldEvery(ctx, viper.GetDuration("HOLD_RELEASE_INTERVAL"))
 	}()
 
-	router := mux.NewRouter()
-	httpHandler := httphandler.New(googleClient, logger)
+	httpHandler := httphandler.New(googleClient, integrations, integrations, logger)
 
 	router.Use(metrics.MetricsMiddleware)
 	router.Path("/metrics").Handler(promhttp.Handler())
 	router.Path("/api/google-sheets/integrate").HandlerFunc(httpHandler.OauthGoogle)
 	router.Path("/api/google-sheets/integrate/callback").HandlerFunc(httpHandler.OauthGoogleCallback)
 	router.Path("/api/google-sheets/create").HandlerFunc(httpHandler.CreateGoogleSheet).Methods(http.MethodPost)
+	router.Path("/api/google-sheets/integrations").HandlerFunc(httpHandler.ListIntegrations).Methods(http.MethodGet)
+	router.Path("/api/google-sheets/integrations").HandlerFunc(httpHandler.CreateIntegration).Methods(http.MethodPost)
+	router.Path("/api/google-sheets/integrations/{id}").HandlerFunc(httpHandler.GetIntegration).Methods(http.MethodGet)
+	router.Path("/api/google-sheets/integrations/{id}").HandlerFunc(httpHandler.UpdateIntegration).Methods(http.MethodPut)
+	router.Path("/api/google-sheets/integrations/{id}").HandlerFunc(httpHandler.DeleteIntegration).Methods(http.MethodDelete)
+	router.Path("/api/google-sheets/integrations/{id}/connection").HandlerFunc(httpHandler.DisconnectIntegration).Methods(http.MethodDelete)
+	router.Path("/api/google-sheets/integrations/{id}/audit").HandlerFunc(httpHandler.ListAuditEntries).Methods(http.MethodGet)
+	router.Path("/api/google-sheets/service-accounts").HandlerFunc(httpHandler.CreateServiceAccountKey).Methods(http.MethodPost)
 
 	s := http.Server{
 		Addr:        fmt.Sprintf(":%v", port),
@@ -109,15 +212,87 @@ func main() {
 	sig := <-sigChan
 	log.Println("recieved graceful shutdown", sig)
 
-	tc, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	// stop consuming before the server goes away so in-flight messages finish
+	cancel()
+	<-consumers
+	<-releases
+
+	tc, tcCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer tcCancel()
 	s.Shutdown(tc)
 }
 
+// recordParts keeps the parts of the integrations of a spreadsheet up to date
+// as it rolls over.
+func recordParts(integrations store.IntegrationStore, logger logger.AppLogger) google.RolloverHook {
+	return func(spreadSheetID string, parts []string) {
+		_, err := integrations.Modify(context.Background(), store.Filter{SpreadSheetID: spreadSheetID}, func(integration *model.Integration) bool {
+			integration.Parts = parts
+			return true
+		})
+		if err != nil {
+			logger.Error("failed to record spreadsheet parts :: stacktrace :: ", err)
+		}
+	}
+}
+
+// requireReauthorization marks the integrations writing with a revoked token,
+// holding their answers until the user connects the spreadsheet again.
+func requireReauthorization(integrations store.IntegrationStore, logger logger.AppLogger) google.TokenRevokedHook {
+	return func(tokenID string) {
+		marked, err := integrations.Modify(context.Background(), store.Filter{TokenID: tokenID}, func(integration *model.Integration) bool {
+			if integration.Status != model.IntegrationActive && integration.Status != model.IntegrationPaused {
+				return false
+			}
+
+			integration.Status = model.IntegrationReauthorizationRequired
+			return true
+		})
+		if err != nil {
+			logger.Error("failed to mark integrations for reauthorization :: stacktrace :: ", err)
+			return
+		}
+
+		for _, integration := range marked {
+			logger.Info(fmt.Sprintf("token was revoked, integration %s needs to be authorized again", integration.ID))
+		}
+	}
+}
+
 func setupViper() error {
 	viper.SetConfigName("app")
 	viper.AddConfigPath(".")
 	viper.SetConfigType("env")
 
+	viper.SetDefault("INTEGRATIONS_DB_PATH", "integrations.db")
+	viper.SetDefault("SOURCES", "kafka")
+	viper.SetDefault("FILE_SINK_DIR", "exports")
+	viper.SetDefault("HOLD_RELEASE_INTERVAL", "30s")
+	viper.SetDefault("KAFKA_DLQ_TOPIC", "googlesheets.dlq")
+	viper.SetDefault("KAFKA_RETRY_TOPIC_PREFIX", "googlesheets")
+	viper.SetDefault("KAFKA_RETRY_DELAYS", "30s,5m,1h")
+	viper.SetDefault("KAFKA_RETRY_MAX_ATTEMPTS", 4)
+	viper.SetDefault("KAFKA_WORKERS", 8)
+	viper.SetDefault("KAFKA_WORKER_QUEUE_DEPTH", 64)
+	viper.SetDefault("KAFKA_BATCH_SIZE", 50)
+	viper.SetDefault("KAFKA_BATCH_LINGER", "2s")
+	viper.SetDefault("WEBHOOK_RETRY_AFTER", 30)
+	viper.SetDefault("REDIS_STREAM", "googlesheets")
+	viper.SetDefault("REDIS_RETRY_DELAY", "30s")
+	viper.SetDefault("REDIS_MAX_ATTEMPTS", 4)
+	viper.SetDefault("REDIS_BATCH_SIZE", 50)
+
+	viper.SetDefault("GOOGLE_SHEETS_PROJECT_RPS", google.DefaultRateLimits.ProjectRPS)
+	viper.SetDefault("GOOGLE_SHEETS_PROJECT_BURST", google.DefaultRateLimits.ProjectBurst)
+	viper.SetDefault("GOOGLE_SHEETS_USER_RPS", google.DefaultRateLimits.UserRPS)
+	viper.SetDefault("GOOGLE_SHEETS_USER_BURST", google.DefaultRateLimits.UserBurst)
+	viper.SetDefault("GOOGLE_SHEETS_MAX_RETRIES", google.DefaultRateLimits.MaxRetries)
+	viper.SetDefault("GOOGLE_SHEETS_MIN_BACKOFF", google.DefaultRateLimits.MinBackoff)
+	viper.SetDefault("GOOGLE_SHEETS_MAX_BACKOFF", google.DefaultRateLimits.MaxBackoff)
+	viper.SetDefault("GOOGLE_SHEETS_ROLLOVER_CELLS", google.DefaultRolloverCells)
+	viper.SetDefault("GOOGLE_OAUTH_LOGIN_TTL", google.DefaultLoginTTL)
+	viper.SetDefault("GOOGLE_OAUTH_PROMPT", "consent")
+
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
 			viper.AutomaticEnv()
@@ -129,6 +304,107 @@ func setupViper() error {
 	return nil
 }
 
+func setupRetryPolicy() (kafkahandler.RetryPolicy, error) {
+	delays, err := kafkahandler.ParseRetryDelays(strings.Split(viper.GetString("KAFKA_RETRY_DELAYS"), ","))
+	if err != nil {
+		return kafkahandler.RetryPolicy{}, err
+	}
+
+	return kafkahandler.RetryPolicy{
+		TopicPrefix: viper.GetString("KAFKA_RETRY_TOPIC_PREFIX"),
+		Delays:      delays,
+		MaxAttempts: viper.GetInt("KAFKA_RETRY_MAX_ATTEMPTS"),
+	}, nil
+}
+
+// setupKafkaSources returns the sources consuming the Kafka topics and the
+// retry topics, and a func closing the publishers they move messages with.
+func setupKafkaSources(metrics kafkahandler.DispatcherMetrics, logger logger.AppLogger) ([]source.Source, func()) {
+	kafkaHandler := setupKafka(logger)
+	kafkaTopics := viper.GetStringSlice("KAFKA_TOPICS")
+
+	deadLetter, err := kafkaHandler.NewDeadLetterPublisher(viper.GetString("KAFKA_DLQ_TOPIC"))
+	if err != nil {
+		logger.Fatal("failed to create dead-letter publisher :: stacktrace :: ", err)
+	}
+
+	retryPolicy, err := setupRetryPolicy()
+	if err != nil {
+		logger.Fatal("invalid retry configuration :: stacktrace :: ", err)
+	}
+
+	retry, err := kafkaHandler.NewRetryPublisher(retryPolicy)
+	if err != nil {
+		logger.Fatal("failed to create retry publisher :: stacktrace :: ", err)
+	}
+
+	commitOpts := []kafkahandler.SubscriberOption{
+		kafkahandler.CommitBatchSize(viper.GetInt("KAFKA_COMMIT_BATCH_SIZE")),
+		kafkahandler.CommitInterval(viper.GetDuration("KAFKA_COMMIT_INTERVAL")),
+	}
+
+	spreadSheetKey := func(message *kafka.Message) string {
+		return messagehandler.SpreadSheetKey(message.Value)
+	}
+
+	sources := []source.Source{
+		source.NewKafka("kafka", kafkaHandler, kafkaTopics, retry, deadLetter, logger,
+			append(
+				commitOpts,
+				kafkahandler.Concurrency(viper.GetInt("KAFKA_WORKERS"), viper.GetInt("KAFKA_WORKER_QUEUE_DEPTH"), spreadSheetKey),
+				kafkahandler.Batching(viper.GetInt("KAFKA_BATCH_SIZE"), viper.GetDuration("KAFKA_BATCH_LINGER")),
+				kafkahandler.RecordMetrics(metrics),
+			)...,
+		),
+		// retried messages are consumed by their own group so a long delay on a
+		// retry topic never holds up a rebalance of the source topics
+		source.NewKafka("kafka-retry", kafkaHandler, retryPolicy.Topics(), retry, deadLetter, logger,
+			append(commitOpts, kafkahandler.GroupID(viper.GetString("SERVICE_ID")+"-retry"), kafkahandler.HonourRetryDelay())...,
+		),
+	}
+
+	return sources, func() {
+		retry.Close()
+		deadLetter.Close()
+	}
+}
+
+// setupRedisSource returns the source reading the Redis stream and a func
+// closing its client.
+func setupRedisSource(logger logger.AppLogger) (source.Source, func()) {
+	options, err := redis.ParseURL(viper.GetString("REDIS_URL"))
+	if err != nil {
+		logger.Fatal("invalid redis url :: stacktrace :: ", err)
+	}
+	client := redis.NewClient(options)
+
+	consumer := viper.GetString("REDIS_CONSUMER")
+	if consumer == "" {
+		// the members of a group must not share a name
+		consumer, _ = os.Hostname()
+	}
+
+	group := viper.GetString("REDIS_GROUP")
+	if group == "" {
+		group = viper.GetString("SERVICE_ID")
+	}
+
+	stream, err := source.NewRedisStream(client, source.RedisStreamConfig{
+		Stream:           viper.GetString("REDIS_STREAM"),
+		Group:            group,
+		Consumer:         consumer,
+		DeadLetterStream: viper.GetString("REDIS_DLQ_STREAM"),
+		RetryDelay:       viper.GetDuration("REDIS_RETRY_DELAY"),
+		MaxAttempts:      viper.GetInt("REDIS_MAX_ATTEMPTS"),
+		BatchSize:        viper.GetInt("REDIS_BATCH_SIZE"),
+	}, logger)
+	if err != nil {
+		logger.Fatal("failed to create redis stream source :: stacktrace :: ", err)
+	}
+
+	return stream, func() { client.Close() }
+}
+
 func setupKafka(logger logger.AppLogger) *kafkahandler.KafkaHandler {
 	kafka_brokers := viper.GetString("KAFKA_BROKERS")
 	kafka_username := viper.GetString("KAFKA_USERNAME")
@@ -146,6 +422,9 @@ func setupKafka(logger logger.AppLogger) *kafkahandler.KafkaHandler {
 		"auto.offset.reset":  "earliest",
 		"request.timeout.ms": 100000,
 		"acks":               "all",
+		// offsets are committed by the subscriber once a message has been
+		// written to the sheet, never before
+		"enable.auto.commit": false,
 	}
 
 	kHandler := kafkahandler.New(config, logger)
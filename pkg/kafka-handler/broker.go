@@ -0,0 +1,52 @@
+package kafkahandler
+
+import (
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Broker is the message broker a KafkaHandler talks to: a Kafka cluster
+// through confluent-kafka-go, see New, or a MemoryBroker.
+type Broker interface {
+	// Topics lists the topics that exist on the broker.
+	Topics() ([]string, error)
+	// CreateTopic returns ErrTopicAlreadyExists when the topic exists.
+	CreateTopic(topic string) error
+	NewProducer() (Producer, error)
+	// NewConsumer creates a consumer in the consumer group groupID, or in the
+	// broker's default group when it is empty.
+	NewConsumer(groupID string) (Consumer, error)
+}
+
+// Producer is the part of *kafka.Producer the handler publishes with.
+type Producer interface {
+	// Produce sends msg to the topic and partition of its TopicPartition and
+	// reports its delivery on deliveryChan.
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+	Close()
+}
+
+// RebalanceFunc is called with kafka.AssignedPartitions and
+// kafka.RevokedPartitions events when the partitions of a consumer change.
+// The new assignment is applied once it returns.
+type RebalanceFunc func(event kafka.Event) error
+
+// Consumer is the part of *kafka.Consumer subscribers consume with.
+type Consumer interface {
+	SubscribeTopics(topics []string, rebalance RebalanceFunc) error
+	// Poll returns the next message or error, or nil when there was none
+	// within timeoutMs. Rebalance callbacks are called from Poll.
+	Poll(timeoutMs int) kafka.Event
+	// ReadMessage is Poll for messages only. It returns a kafka.Error with
+	// kafka.ErrTimedOut when there was none within timeout.
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	// CommitOffsets commits the offsets of the next messages the group reads.
+	CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	Pause(partitions []kafka.TopicPartition) error
+	Resume(partitions []kafka.TopicPartition) error
+	// Seek makes the next message read from the partition the one at its
+	// offset.
+	Seek(partition kafka.TopicPartition, timeoutMs int) error
+	Close() error
+}
@@ -0,0 +1,175 @@
+package kafkahandler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const (
+	defaultCommitBatchSize = 100
+	defaultCommitInterval  = 5 * time.Second
+)
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+func keyOf(tp kafka.TopicPartition) partitionKey {
+	return partitionKey{topic: *tp.Topic, partition: tp.Partition}
+}
+
+type commitFunc func(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+
+// partitionOffsets follows the messages of one partition that are being
+// handled. Messages may finish out of order, so the committable offset only
+// moves past a message once every message before it has finished too.
+type partitionOffsets struct {
+	tp       kafka.TopicPartition
+	inflight []kafka.Offset
+	done     map[kafka.Offset]bool
+	next     kafka.Offset
+	dirty    bool
+}
+
+// offsetCommitter collects the offsets of messages that have been handled and
+// commits them in batches, either once batchSize messages have been marked or
+// when interval has elapsed since the last commit. It is safe for concurrent use.
+type offsetCommitter struct {
+	mu         sync.Mutex
+	commit     commitFunc
+	batchSize  int
+	interval   time.Duration
+	partitions map[partitionKey]*partitionOffsets
+	marked     int
+	lastCommit time.Time
+}
+
+func newOffsetCommitter(commit commitFunc, batchSize int, interval time.Duration) *offsetCommitter {
+	return &offsetCommitter{
+		commit:     commit,
+		batchSize:  batchSize,
+		interval:   interval,
+		partitions: make(map[partitionKey]*partitionOffsets),
+		lastCommit: time.Now(),
+	}
+}
+
+// track records that the message at tp is about to be handled. Messages of a
+// partition must be tracked in the order they were consumed.
+func (c *offsetCommitter) track(tp kafka.TopicPartition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := keyOf(tp)
+	p, ok := c.partitions[key]
+	if !ok {
+		p = &partitionOffsets{tp: tp, done: make(map[kafka.Offset]bool)}
+		p.tp.Error = nil
+		c.partitions[key] = p
+	}
+
+	p.inflight = append(p.inflight, tp.Offset)
+	p.done[tp.Offset] = false
+}
+
+// untrack drops the message at tp, which is read and tracked again later.
+// It must be the last message tracked for its partition.
+func (c *offsetCommitter) untrack(tp kafka.TopicPartition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.partitions[keyOf(tp)]
+	if !ok {
+		return
+	}
+
+	if n := len(p.inflight); n > 0 && p.inflight[n-1] == tp.Offset {
+		p.inflight = p.inflight[:n-1]
+		delete(p.done, tp.Offset)
+	}
+}
+
+// mark records that the message at tp has been handled. The committed offset
+// is the one after the last message that has been handled with nothing
+// before it still in flight, i.e. the next message the group should read.
+func (c *offsetCommitter) mark(tp kafka.TopicPartition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.partitions[keyOf(tp)]
+	if !ok {
+		return
+	}
+
+	// ignore messages that were in flight when their partition was revoked
+	if _, tracked := p.done[tp.Offset]; !tracked {
+		return
+	}
+	p.done[tp.Offset] = true
+	c.marked++
+
+	for len(p.inflight) > 0 && p.done[p.inflight[0]] {
+		delete(p.done, p.inflight[0])
+		p.next = p.inflight[0] + 1
+		p.dirty = true
+		p.inflight = p.inflight[1:]
+	}
+}
+
+// due reports whether a batch should be committed now.
+func (c *offsetCommitter) due() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.marked == 0 {
+		return false
+	}
+	return c.marked >= c.batchSize || time.Since(c.lastCommit) >= c.interval
+}
+
+// flush commits every committable offset. Offsets are kept on failure so that
+// the next flush retries them.
+func (c *offsetCommitter) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offsets := []kafka.TopicPartition{}
+	for _, p := range c.partitions {
+		if !p.dirty {
+			continue
+		}
+
+		tp := p.tp
+		tp.Offset = p.next
+		offsets = append(offsets, tp)
+	}
+
+	if len(offsets) == 0 {
+		c.marked = 0
+		return nil
+	}
+
+	if _, err := c.commit(offsets); err != nil {
+		return err
+	}
+
+	for _, p := range c.partitions {
+		p.dirty = false
+	}
+	c.marked = 0
+	c.lastCommit = time.Now()
+	return nil
+}
+
+// forget drops the state of partitions this consumer no longer owns.
+func (c *offsetCommitter) forget(partitions []kafka.TopicPartition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tp := range partitions {
+		delete(c.partitions, keyOf(tp))
+	}
+}
@@ -0,0 +1,102 @@
+package kafkahandler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/require"
+)
+
+func topicPartition(topic string, partition int32, offset int64) kafka.TopicPartition {
+	return kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)}
+}
+
+func recordCommits(committed *[]kafka.TopicPartition) commitFunc {
+	return func(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+		*committed = append(*committed, offsets...)
+		return offsets, nil
+	}
+}
+
+func TestOffsetCommitterBatches(t *testing.T) {
+	committed := []kafka.TopicPartition{}
+	c := newOffsetCommitter(recordCommits(&committed), 3, time.Hour)
+
+	for offset := int64(10); offset <= 12; offset++ {
+		c.track(topicPartition(testPubTopic, 0, offset))
+	}
+
+	c.mark(topicPartition(testPubTopic, 0, 10))
+	c.mark(topicPartition(testPubTopic, 0, 11))
+	require.False(t, c.due())
+
+	c.mark(topicPartition(testPubTopic, 0, 12))
+	require.True(t, c.due())
+
+	require.NoError(t, c.flush())
+	require.Len(t, committed, 1)
+	require.Equal(t, kafka.Offset(13), committed[0].Offset)
+	require.False(t, c.due())
+}
+
+func TestOffsetCommitterWaitsForEarlierOffsets(t *testing.T) {
+	committed := []kafka.TopicPartition{}
+	c := newOffsetCommitter(recordCommits(&committed), 1, time.Hour)
+
+	for offset := int64(0); offset < 3; offset++ {
+		c.track(topicPartition(testPubTopic, 0, offset))
+	}
+
+	// offset 0 is still being handled, nothing may be committed yet
+	c.mark(topicPartition(testPubTopic, 0, 2))
+	c.mark(topicPartition(testPubTopic, 0, 1))
+	require.NoError(t, c.flush())
+	require.Empty(t, committed)
+
+	c.mark(topicPartition(testPubTopic, 0, 0))
+	require.NoError(t, c.flush())
+	require.Len(t, committed, 1)
+	require.Equal(t, kafka.Offset(3), committed[0].Offset)
+}
+
+func TestOffsetCommitterKeepsOffsetsOnFailure(t *testing.T) {
+	fail := true
+	committed := []kafka.TopicPartition{}
+	commit := func(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+		if fail {
+			return nil, errors.New("broker unavailable")
+		}
+		committed = append(committed, offsets...)
+		return offsets, nil
+	}
+
+	c := newOffsetCommitter(commit, 1, time.Hour)
+	c.track(topicPartition(testPubTopic, 1, 4))
+	c.mark(topicPartition(testPubTopic, 1, 4))
+
+	require.Error(t, c.flush())
+	require.True(t, c.due())
+
+	fail = false
+	require.NoError(t, c.flush())
+	require.Equal(t, kafka.Offset(5), committed[0].Offset)
+}
+
+func TestOffsetCommitterForgetsRevokedPartitions(t *testing.T) {
+	committed := []kafka.TopicPartition{}
+	c := newOffsetCommitter(recordCommits(&committed), 10, time.Hour)
+	c.track(topicPartition(testPubTopic, 0, 1))
+	c.track(topicPartition(testPubTopic, 1, 1))
+
+	c.forget([]kafka.TopicPartition{topicPartition(testPubTopic, 0, 0)})
+
+	// a message that finishes after its partition was revoked is ignored
+	c.mark(topicPartition(testPubTopic, 0, 1))
+	c.mark(topicPartition(testPubTopic, 1, 1))
+
+	require.NoError(t, c.flush())
+	require.Len(t, committed, 1)
+	require.Equal(t, int32(1), committed[0].Partition)
+}
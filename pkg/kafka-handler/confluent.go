@@ -0,0 +1,144 @@
+package kafkahandler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// confluentBroker is a Kafka cluster reached through confluent-kafka-go.
+type confluentBroker struct {
+	config *kafka.ConfigMap
+	logger logger.AppLogger
+}
+
+var _ Broker = (*confluentBroker)(nil)
+
+func (b *confluentBroker) Topics() ([]string, error) {
+	admin, err := createAdmin(b.config)
+	if err != nil {
+		b.logger.Error("failed to create kafka admin client  :: stacktrace :: ", err)
+		return nil, err
+	}
+	defer admin.Close()
+
+	metadata, err := admin.GetMetadata(nil, true, int((5 * time.Second).Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata :: stacktrace :: %v", err)
+	}
+
+	topics := make([]string, 0, len(metadata.Topics))
+	for topic := range metadata.Topics {
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+func (b *confluentBroker) CreateTopic(topic string) error {
+	admin, err := createAdmin(b.config)
+	if err != nil {
+		b.logger.Error("failed to create kafka admin client :: stacktrace :: ", err)
+		return ErrFailedTopicCreation
+	}
+	defer admin.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	maxDuration, err := time.ParseDuration("60s")
+	if err != nil {
+		b.logger.Error("failed to create kafka topic :: stacktrace ::", err)
+		return ErrFailedTopicCreation
+	}
+
+	results, err := admin.CreateTopics(
+		ctx,
+		[]kafka.TopicSpecification{
+			{
+				Topic:             topic,
+				ReplicationFactor: 3,
+				NumPartitions:     1,
+			},
+		},
+		kafka.SetAdminOperationTimeout(maxDuration),
+	)
+	if err != nil {
+		b.logger.Error("failed to create kafka topic :: stacktrace ::", err)
+		return ErrFailedTopicCreation
+	}
+
+	for _, result := range results {
+		if result.Error.Code() != kafka.ErrNoError && result.Error.Code() == kafka.ErrTopicAlreadyExists {
+			return ErrTopicAlreadyExists
+		}
+	}
+	return nil
+}
+
+func (b *confluentBroker) NewProducer() (Producer, error) {
+	p, err := kafka.NewProducer(b.config)
+	if err != nil {
+		b.logger.Error("failed to create a new producer :: stacktrace ::", err)
+		return nil, ErrFailedProducerCreation
+	}
+	b.logger.Info("producer created succesfully")
+	return p, nil
+}
+
+// NewConsumer creates a consumer that joins groupID instead of the configured
+// group when groupID is set.
+func (b *confluentBroker) NewConsumer(groupID string) (Consumer, error) {
+	config := b.config
+	if groupID != "" {
+		config = &kafka.ConfigMap{}
+		for key, value := range *b.config {
+			(*config)[key] = value
+		}
+		(*config)["group.id"] = groupID
+	}
+
+	c, err := kafka.NewConsumer(config)
+	if err != nil {
+		b.logger.Error("failed to create a new consumer :: stacktrace ::", err)
+		return nil, ErrFailedConsumerCreation
+	}
+	b.logger.Info("consumer created succesfully")
+	return confluentConsumer{c}, nil
+}
+
+// confluentConsumer adapts the rebalance callback of *kafka.Consumer, which
+// is handed the consumer itself.
+type confluentConsumer struct {
+	*kafka.Consumer
+}
+
+func (c confluentConsumer) SubscribeTopics(topics []string, rebalance RebalanceFunc) error {
+	var cb kafka.RebalanceCb
+	if rebalance != nil {
+		cb = func(_ *kafka.Consumer, event kafka.Event) error {
+			return rebalance(event)
+		}
+	}
+	return c.Consumer.SubscribeTopics(topics, cb)
+}
+
+func createAdmin(config *kafka.ConfigMap) (*kafka.AdminClient, error) {
+	admin, err := kafka.NewAdminClient(config)
+	if err != nil {
+		if kErr, ok := err.(kafka.Error); ok {
+			switch kErr.Code() {
+			case kafka.ErrInvalidArg:
+				return nil, ErrInvalidAdminConfig
+
+			default:
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	return admin, nil
+}
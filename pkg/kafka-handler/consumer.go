@@ -0,0 +1,395 @@
+package kafkahandler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adetunjii/google-sheets-connector/pkg/logger"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const (
+	defaultPollTimeout  = 100 * time.Millisecond
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = 30 * time.Second
+)
+
+// MessageHandler processes a single message read from a subscribed topic.
+// The message's offset is only committed once the handler returns nil.
+type MessageHandler func(ctx context.Context, message *kafka.Message) error
+
+// BatchHandler processes several messages at once. The offsets of the whole
+// batch are only committed once the handler returns nil, and the batch is
+// retried as a whole when it doesn't.
+type BatchHandler func(ctx context.Context, messages []*kafka.Message) error
+
+// Subscriber is a long-running consumer that keeps polling its topics and
+// hands every message over to its handler until it is stopped.
+//
+// Offsets are committed manually, so the consumer config must set
+// enable.auto.commit to false for the at-least-once guarantee to hold.
+type Subscriber struct {
+	consumer        Consumer
+	groupID         string
+	topics          []string
+	handler         BatchHandler
+	pollTimeout     time.Duration
+	commitBatchSize int
+	commitInterval  time.Duration
+	committer       *offsetCommitter
+	honourDelay     bool
+	paused          map[partitionKey]pausedPartition
+	workers         int
+	queueDepth      int
+	batchSize       int
+	batchLinger     time.Duration
+	key             KeyFunc
+	metrics         DispatcherMetrics
+	logger          logger.AppLogger
+
+	// how long partitions whose message failed on the polling goroutine wait
+	// before it is read again
+	backoff map[partitionKey]time.Duration
+}
+
+type pausedPartition struct {
+	tp    kafka.TopicPartition
+	until time.Time
+}
+
+type SubscriberOption func(*Subscriber)
+
+func (k *KafkaHandler) NewSubscriber(topics []string, handler MessageHandler, opts ...SubscriberOption) (*Subscriber, error) {
+	batchHandler := func(ctx context.Context, messages []*kafka.Message) error {
+		for _, message := range messages {
+			if err := handler(ctx, message); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return k.NewBatchSubscriber(topics, batchHandler, opts...)
+}
+
+// NewBatchSubscriber creates a subscriber whose workers hand messages to
+// handler in batches, see the Batching option. Without workers every batch
+// holds a single message.
+func (k *KafkaHandler) NewBatchSubscriber(topics []string, handler BatchHandler, opts ...SubscriberOption) (*Subscriber, error) {
+	s := &Subscriber{
+		topics:          topics,
+		handler:         handler,
+		pollTimeout:     defaultPollTimeout,
+		commitBatchSize: defaultCommitBatchSize,
+		commitInterval:  defaultCommitInterval,
+		paused:          make(map[partitionKey]pausedPartition),
+		backoff:         make(map[partitionKey]time.Duration),
+		batchSize:       1,
+		metrics:         noopDispatcherMetrics{},
+		logger:          k.logger,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	consumer, err := k.newConsumer(s.groupID)
+	if err != nil {
+		return nil, err
+	}
+	s.consumer = consumer
+
+	s.committer = newOffsetCommitter(s.consumer.CommitOffsets, s.commitBatchSize, s.commitInterval)
+	return s, nil
+}
+
+// CommitBatchSize sets how many handled messages are committed together.
+func CommitBatchSize(size int) SubscriberOption {
+	return func(s *Subscriber) {
+		if size > 0 {
+			s.commitBatchSize = size
+		}
+	}
+}
+
+// CommitInterval sets the longest time a handled message waits to be committed.
+func CommitInterval(interval time.Duration) SubscriberOption {
+	return func(s *Subscriber) {
+		if interval > 0 {
+			s.commitInterval = interval
+		}
+	}
+}
+
+// GroupID makes the subscriber join a consumer group other than the one in
+// the handler's config.
+func GroupID(id string) SubscriberOption {
+	return func(s *Subscriber) {
+		s.groupID = id
+	}
+}
+
+// HonourRetryDelay holds back messages carrying a retry delay until it has
+// passed. Their partition is paused rather than blocking the poll loop, so
+// the consumer stays in its group however long the delay is.
+func HonourRetryDelay() SubscriberOption {
+	return func(s *Subscriber) {
+		s.honourDelay = true
+	}
+}
+
+// Concurrency hands messages to a pool of workers instead of handling them
+// on the polling goroutine. Messages with the same key are handled by the
+// same worker in offset order, and each worker queues up to queueDepth
+// messages before polling is held back.
+func Concurrency(workers int, queueDepth int, key KeyFunc) SubscriberOption {
+	return func(s *Subscriber) {
+		if workers > 0 && key != nil {
+			s.workers = workers
+			s.key = key
+		}
+		if queueDepth > 0 {
+			s.queueDepth = queueDepth
+		}
+	}
+}
+
+// Batching lets each worker gather up to size messages, waiting at most
+// linger after the first one, before handing them to the handler together.
+// It has no effect without Concurrency.
+func Batching(size int, linger time.Duration) SubscriberOption {
+	return func(s *Subscriber) {
+		if size > 0 {
+			s.batchSize = size
+		}
+		if linger > 0 {
+			s.batchLinger = linger
+		}
+	}
+}
+
+func RecordMetrics(metrics DispatcherMetrics) SubscriberOption {
+	return func(s *Subscriber) {
+		if metrics != nil {
+			s.metrics = metrics
+		}
+	}
+}
+
+func PollTimeout(timeout time.Duration) SubscriberOption {
+	return func(s *Subscriber) {
+		if timeout > 0 {
+			s.pollTimeout = timeout
+		}
+	}
+}
+
+// Run subscribes to the topics and polls them until ctx is cancelled or the
+// consumer hits a fatal error. Pending offsets are committed and the consumer
+// is closed before Run returns.
+func (s *Subscriber) Run(ctx context.Context) error {
+	defer s.close()
+
+	if err := s.consumer.SubscribeTopics(s.topics, s.rebalance); err != nil {
+		s.logger.Error("failed to subscribe to kafka :: stacktrace ::", err)
+		return err
+	}
+
+	s.logger.Info(fmt.Sprintf("subscribed to topics %v", s.topics))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var d *dispatcher
+	if s.workers > 0 {
+		d = newDispatcher(s.workers, s.queueDepth, s.batchSize, s.batchLinger, s.key, s.metrics)
+		d.start(ctx, s.process)
+
+		// deferred calls run in reverse: the workers are told to stop, then
+		// waited for, so the final commit in close sees every finished message
+		defer d.stop()
+		defer cancel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		switch e := s.consumer.Poll(int(s.pollTimeout.Milliseconds())).(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				s.logger.Error("failed to read message from kafka :: stacktrace ::", e.TopicPartition.Error)
+				continue
+			}
+
+			// anything still buffered for a paused partition is read again
+			// from the rewound offset once it is resumed
+			if _, ok := s.paused[keyOf(e.TopicPartition)]; ok {
+				continue
+			}
+
+			if s.honourDelay {
+				if notBefore := NotBefore(e); time.Now().Before(notBefore) {
+					s.pause(e.TopicPartition, notBefore)
+					continue
+				}
+			}
+
+			s.committer.track(e.TopicPartition)
+
+			if d != nil {
+				if err := d.dispatch(ctx, e); err != nil {
+					return nil
+				}
+			} else {
+				s.processPolled(ctx, e)
+			}
+
+		case kafka.Error:
+			s.logger.Error("kafka consumer error :: stacktrace ::", e)
+			if e.IsFatal() {
+				return e
+			}
+		}
+
+		if s.committer.due() {
+			s.commit()
+		}
+
+		s.resumeDue()
+	}
+}
+
+// pause stops fetching from tp's partition and rewinds it to tp's offset so
+// the message is read again once the partition is resumed at until.
+func (s *Subscriber) pause(tp kafka.TopicPartition, until time.Time) {
+	partition := []kafka.TopicPartition{tp}
+
+	if err := s.consumer.Pause(partition); err != nil {
+		s.logger.Error(fmt.Sprintf("failed to pause %s :: stacktrace ::", tp), err)
+	}
+
+	if err := s.consumer.Seek(tp, 0); err != nil {
+		s.logger.Error(fmt.Sprintf("failed to rewind %s :: stacktrace ::", tp), err)
+	}
+
+	s.paused[keyOf(tp)] = pausedPartition{tp: tp, until: until}
+}
+
+func (s *Subscriber) resumeDue() {
+	now := time.Now()
+	for key, p := range s.paused {
+		if now.Before(p.until) {
+			continue
+		}
+
+		if err := s.consumer.Resume([]kafka.TopicPartition{p.tp}); err != nil {
+			s.logger.Error(fmt.Sprintf("failed to resume %s :: stacktrace ::", p.tp), err)
+			continue
+		}
+		delete(s.paused, key)
+	}
+}
+
+// process handles the messages of a worker and marks them for commit once they succeeded.
+// When they don't, we are shutting down and the messages are redelivered to
+// whoever picks up their partitions next.
+func (s *Subscriber) process(ctx context.Context, messages []*kafka.Message) {
+	if err := s.handle(ctx, messages); err != nil {
+		return
+	}
+
+	for _, message := range messages {
+		s.committer.mark(message.TopicPartition)
+	}
+}
+
+// processPolled handles a message on the polling goroutine. A message that
+// fails isn't retried in place, which would keep the consumer from polling
+// for longer than the group allows; its partition is paused instead and the
+// message read again once the backoff has passed.
+func (s *Subscriber) processPolled(ctx context.Context, message *kafka.Message) {
+	tp := message.TopicPartition
+	key := keyOf(tp)
+
+	err := s.handler(ctx, []*kafka.Message{message})
+	if err == nil {
+		delete(s.backoff, key)
+		s.committer.mark(tp)
+		return
+	}
+
+	backoff, ok := s.backoff[key]
+	if !ok {
+		backoff = defaultRetryBackoff
+	}
+	s.logger.Error(fmt.Sprintf("failed to handle message at %s, retrying in %v :: stacktrace ::", tp, backoff), err)
+
+	s.backoff[key] = backoff * 2
+	if s.backoff[key] > maxRetryBackoff {
+		s.backoff[key] = maxRetryBackoff
+	}
+
+	s.committer.untrack(tp)
+	s.pause(tp, time.Now().Add(backoff))
+}
+
+// handle runs the handler until it succeeds, backing off between attempts so
+// failing messages are never skipped. It only gives up when ctx is cancelled.
+// Workers run it, away from the polling goroutine, see processPolled.
+func (s *Subscriber) handle(ctx context.Context, messages []*kafka.Message) error {
+	backoff := defaultRetryBackoff
+
+	for {
+		err := s.handler(ctx, messages)
+		if err == nil {
+			return nil
+		}
+
+		s.logger.Error(fmt.Sprintf("failed to handle %d message(s) starting at %s, retrying in %v :: stacktrace ::", len(messages), messages[0].TopicPartition, backoff), err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// rebalance commits what has been handled so far before partitions are handed
+// over to another member of the group.
+func (s *Subscriber) rebalance(event kafka.Event) error {
+	if revoked, ok := event.(kafka.RevokedPartitions); ok {
+		s.commit()
+		s.committer.forget(revoked.Partitions)
+
+		for _, tp := range revoked.Partitions {
+			delete(s.paused, keyOf(tp))
+			delete(s.backoff, keyOf(tp))
+		}
+	}
+	return nil
+}
+
+func (s *Subscriber) commit() {
+	if err := s.committer.flush(); err != nil {
+		s.logger.Error("failed to commit offsets :: stacktrace ::", err)
+	}
+}
+
+func (s *Subscriber) close() {
+	s.commit()
+
+	if err := s.consumer.Close(); err != nil {
+		s.logger.Error("failed to close consumer :: stacktrace ::", err)
+	}
+}
@@ -0,0 +1,111 @@
+package kafkahandler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// headers attached to messages that are parked on the dead-letter topic
+const (
+	HeaderErrorReason     = "x-error-reason"
+	HeaderSourceTopic     = "x-source-topic"
+	HeaderSourcePartition = "x-source-partition"
+	HeaderSourceOffset    = "x-source-offset"
+	HeaderAttempt         = "x-attempt"
+	HeaderFailedAt        = "x-failed-at"
+)
+
+// DeadLetterPublisher parks messages that can never be handled on a
+// dead-letter topic, keeping the original payload untouched so they can be
+// inspected and replayed later.
+type DeadLetterPublisher struct {
+	handler  *KafkaHandler
+	producer Producer
+	topic    string
+}
+
+func (k *KafkaHandler) NewDeadLetterPublisher(topic string) (*DeadLetterPublisher, error) {
+	producer, err := k.NewProducer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetterPublisher{
+		handler:  k,
+		producer: producer,
+		topic:    topic,
+	}, nil
+}
+
+func (d *DeadLetterPublisher) Topic() string {
+	return d.topic
+}
+
+// Publish sends message to the dead-letter topic with the reason it failed and
+// where it originally came from recorded as headers.
+func (d *DeadLetterPublisher) Publish(message *kafka.Message, reason error) error {
+	topic, partition, offset := Source(message)
+
+	headers := []kafka.Header{
+		{Key: HeaderErrorReason, Value: []byte(reason.Error())},
+		{Key: HeaderSourceTopic, Value: []byte(topic)},
+		{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(int(partition)))},
+		{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(int64(offset), 10))},
+		{Key: HeaderAttempt, Value: []byte(strconv.Itoa(Attempt(message)))},
+		{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	}
+
+	if err := d.handler.PublishWithHeaders(d.producer, d.topic, message.Value, headers); err != nil {
+		return fmt.Errorf("failed to publish message to dead-letter topic %s: %w", d.topic, err)
+	}
+
+	return nil
+}
+
+func (d *DeadLetterPublisher) Close() {
+	d.producer.Close()
+}
+
+// Attempt returns how many times message has been handled, counting the
+// current attempt. Messages that have never been retried are on attempt 1.
+func Attempt(message *kafka.Message) int {
+	value, ok := headerValue(message, HeaderAttempt)
+	if !ok {
+		return 1
+	}
+
+	attempt, err := strconv.Atoi(value)
+	if err != nil || attempt < 1 {
+		return 1
+	}
+	return attempt
+}
+
+// Source returns the topic, partition and offset message was first consumed
+// from, looking through any redelivery headers to the original position.
+func Source(message *kafka.Message) (string, int32, kafka.Offset) {
+	topic, ok := headerValue(message, HeaderSourceTopic)
+	if !ok {
+		return *message.TopicPartition.Topic, message.TopicPartition.Partition, message.TopicPartition.Offset
+	}
+
+	partition, _ := headerValue(message, HeaderSourcePartition)
+	offset, _ := headerValue(message, HeaderSourceOffset)
+
+	p, _ := strconv.Atoi(partition)
+	o, _ := strconv.ParseInt(offset, 10, 64)
+
+	return topic, int32(p), kafka.Offset(o)
+}
+
+func headerValue(message *kafka.Message, key string) (string, bool) {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,40 @@
+package kafkahandler
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttempt(t *testing.T) {
+	message := &kafka.Message{TopicPartition: topicPartition(testPubTopic, 0, 1)}
+	require.Equal(t, 1, Attempt(message))
+
+	message.Headers = []kafka.Header{{Key: HeaderAttempt, Value: []byte("3")}}
+	require.Equal(t, 3, Attempt(message))
+
+	message.Headers = []kafka.Header{{Key: HeaderAttempt, Value: []byte("nope")}}
+	require.Equal(t, 1, Attempt(message))
+}
+
+func TestSource(t *testing.T) {
+	message := &kafka.Message{TopicPartition: topicPartition(testPubTopic, 2, 42)}
+
+	topic, partition, offset := Source(message)
+	require.Equal(t, testPubTopic, topic)
+	require.Equal(t, int32(2), partition)
+	require.Equal(t, kafka.Offset(42), offset)
+
+	// redelivered messages keep pointing at where they were first consumed
+	message.Headers = []kafka.Header{
+		{Key: HeaderSourceTopic, Value: []byte("questionnaire.answers")},
+		{Key: HeaderSourcePartition, Value: []byte("5")},
+		{Key: HeaderSourceOffset, Value: []byte("1001")},
+	}
+
+	topic, partition, offset = Source(message)
+	require.Equal(t, "questionnaire.answers", topic)
+	require.Equal(t, int32(5), partition)
+	require.Equal(t, kafka.Offset(1001), offset)
+}
@@ -0,0 +1,142 @@
+package kafkahandler
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// KeyFunc returns the ordering key of a message. Messages with the same key
+// are always handled by the same worker, in the order they were consumed.
+type KeyFunc func(message *kafka.Message) string
+
+// DispatcherMetrics receives the state of a subscriber's worker pool.
+type DispatcherMetrics interface {
+	SetWorkers(count int)
+	SetQueueCapacity(capacity int)
+	SetQueueLength(worker int, length int)
+}
+
+type noopDispatcherMetrics struct{}
+
+func (noopDispatcherMetrics) SetWorkers(int)          {}
+func (noopDispatcherMetrics) SetQueueCapacity(int)    {}
+func (noopDispatcherMetrics) SetQueueLength(int, int) {}
+
+// dispatcher fans messages out to a fixed pool of workers, each with its own
+// bounded queue, picking the worker by hashing the message key. Workers take
+// messages off their queue in batches of up to batchSize, waiting at most
+// linger for a batch to fill up.
+type dispatcher struct {
+	queues    []chan *kafka.Message
+	batchSize int
+	linger    time.Duration
+	key       KeyFunc
+	metrics   DispatcherMetrics
+	wg        sync.WaitGroup
+}
+
+func newDispatcher(workers int, queueDepth int, batchSize int, linger time.Duration, key KeyFunc, metrics DispatcherMetrics) *dispatcher {
+	d := &dispatcher{
+		queues:    make([]chan *kafka.Message, workers),
+		batchSize: batchSize,
+		linger:    linger,
+		key:       key,
+		metrics:   metrics,
+	}
+
+	for i := range d.queues {
+		d.queues[i] = make(chan *kafka.Message, queueDepth)
+	}
+
+	metrics.SetWorkers(workers)
+	metrics.SetQueueCapacity(queueDepth)
+	return d
+}
+
+// start runs the workers until ctx is cancelled or stop is called. Messages
+// still queued when ctx is cancelled are dropped, they were never marked so
+// their offsets are not committed.
+func (d *dispatcher) start(ctx context.Context, process func(ctx context.Context, messages []*kafka.Message)) {
+	for i, queue := range d.queues {
+		d.wg.Add(1)
+		go func(worker int, queue chan *kafka.Message) {
+			defer d.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case message, ok := <-queue:
+					if !ok {
+						return
+					}
+
+					batch, ok := d.collect(ctx, queue, message)
+					if !ok {
+						return
+					}
+
+					d.metrics.SetQueueLength(worker, len(queue))
+					process(ctx, batch)
+				}
+			}
+		}(i, queue)
+	}
+}
+
+// collect gathers more messages from queue to go with first until the batch
+// is full or linger has passed. It returns false when ctx was cancelled.
+func (d *dispatcher) collect(ctx context.Context, queue chan *kafka.Message, first *kafka.Message) ([]*kafka.Message, bool) {
+	batch := []*kafka.Message{first}
+	if d.batchSize <= 1 {
+		return batch, true
+	}
+
+	timer := time.NewTimer(d.linger)
+	defer timer.Stop()
+
+	for len(batch) < d.batchSize {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-timer.C:
+			return batch, true
+		case message, ok := <-queue:
+			if !ok {
+				return batch, true
+			}
+			batch = append(batch, message)
+		}
+	}
+
+	return batch, true
+}
+
+// dispatch queues message on its worker, blocking while that worker's queue is full.
+func (d *dispatcher) dispatch(ctx context.Context, message *kafka.Message) error {
+	worker := d.worker(d.key(message))
+	queue := d.queues[worker]
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case queue <- message:
+		d.metrics.SetQueueLength(worker, len(queue))
+		return nil
+	}
+}
+
+func (d *dispatcher) worker(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+// stop waits for the workers to exit. The caller must have cancelled the
+// context passed to start.
+func (d *dispatcher) stop() {
+	d.wg.Wait()
+}
@@ -0,0 +1,93 @@
+package kafkahandler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcherKeepsKeyOrder(t *testing.T) {
+	key := func(message *kafka.Message) string {
+		return string(message.Key)
+	}
+
+	d := newDispatcher(4, 8, 1, 0, key, noopDispatcherMetrics{})
+
+	mu := sync.Mutex{}
+	handled := map[string][]kafka.Offset{}
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	d.start(ctx, func(ctx context.Context, messages []*kafka.Message) {
+		message := messages[0]
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		handled[string(message.Key)] = append(handled[string(message.Key)], message.TopicPartition.Offset)
+	})
+
+	keys := []string{"sheet-a", "sheet-b", "sheet-c"}
+	for offset := int64(0); offset < 30; offset++ {
+		wg.Add(1)
+		message := &kafka.Message{
+			TopicPartition: topicPartition(testPubTopic, 0, offset),
+			Key:            []byte(keys[offset%3]),
+		}
+		require.NoError(t, d.dispatch(ctx, message))
+	}
+
+	wg.Wait()
+	cancel()
+	d.stop()
+
+	for _, k := range keys {
+		offsets := handled[k]
+		require.Len(t, offsets, 10)
+		for i := 1; i < len(offsets); i++ {
+			require.Less(t, offsets[i-1], offsets[i])
+		}
+	}
+
+	require.Equal(t, d.worker("sheet-a"), d.worker("sheet-a"))
+}
+
+func TestDispatcherBatches(t *testing.T) {
+	key := func(message *kafka.Message) string {
+		return "sheet-a"
+	}
+
+	d := newDispatcher(1, 16, 5, 50*time.Millisecond, key, noopDispatcherMetrics{})
+
+	batches := make(chan []*kafka.Message, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.start(ctx, func(ctx context.Context, messages []*kafka.Message) {
+		batches <- messages
+	})
+
+	for offset := int64(0); offset < 7; offset++ {
+		message := &kafka.Message{TopicPartition: topicPartition(testPubTopic, 0, offset)}
+		require.NoError(t, d.dispatch(ctx, message))
+	}
+
+	// a full batch goes out right away, the rest once linger has passed
+	handled := 0
+	for handled < 7 {
+		select {
+		case batch := <-batches:
+			require.LessOrEqual(t, len(batch), 5)
+			for i, message := range batch {
+				require.Equal(t, kafka.Offset(handled+i), message.TopicPartition.Offset)
+			}
+			handled += len(batch)
+		case <-time.After(time.Second):
+			t.Fatal("batch was never handed over")
+		}
+	}
+
+	cancel()
+	d.stop()
+}
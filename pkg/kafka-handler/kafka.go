@@ -1,9 +1,9 @@
 package kafkahandler
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/adetunjii/google-sheets-connector/pkg/logger"
@@ -15,109 +15,100 @@ var (
 	ErrFailedTopicCreation    = errors.New("failed to create kafka topic")
 	ErrTopicAlreadyExists     = errors.New("topic already exists")
 	ErrFailedProducerCreation = errors.New("failed to create new producer")
+	ErrFailedConsumerCreation = errors.New("failed to create new consumer")
 	ErrFailedConsumerClose    = errors.New("failed to close consumer")
 )
 
 type KafkaHandler struct {
-	config *kafka.ConfigMap
+	broker Broker
+	mu     sync.RWMutex
 	topics map[string]struct{}
 	logger logger.AppLogger
 }
 
+// New returns a handler for the Kafka cluster config points at.
 func New(config *kafka.ConfigMap, logger logger.AppLogger) *KafkaHandler {
+	return NewWithBroker(&confluentBroker{config: config, logger: logger}, logger)
+}
+
+// NewWithBroker returns a handler for broker, e.g. a MemoryBroker in tests.
+func NewWithBroker(broker Broker, logger logger.AppLogger) *KafkaHandler {
 	return &KafkaHandler{
-		config: config,
+		broker: broker,
 		logger: logger,
 		topics: make(map[string]struct{}),
 	}
 }
 
 func (k *KafkaHandler) IsTopicExist(topic string) (bool, error) {
-	isExist := false
-	admin, err := createAdmin(k.config)
-	if err != nil {
-		k.logger.Error("failed to create kafka admin client  :: stacktrace :: ", err)
+	k.mu.RLock()
+	_, known := k.topics[topic]
+	k.mu.RUnlock()
+	if known {
+		return true, nil
 	}
-	defer admin.Close()
 
-	metadata, err := admin.GetMetadata(nil, true, int(5*time.Second))
+	isExist := false
+	topics, err := k.broker.Topics()
 	if err != nil {
-		return isExist, fmt.Errorf("failed to get metadata :: stacktrace :: %v", err)
+		return isExist, err
 	}
 
-	for k := range metadata.Topics {
-		if k == topic {
+	for _, t := range topics {
+		if t == topic {
 			isExist = true
 		}
 	}
 
+	if isExist {
+		k.mu.Lock()
+		k.topics[topic] = struct{}{}
+		k.mu.Unlock()
+	}
+
 	return isExist, nil
 }
 
 func (k *KafkaHandler) CreateTopic(topic string) error {
-
-	admin, err := createAdmin(k.config)
-	if err != nil {
-		k.logger.Error("failed to create kafka admin client :: stacktrace :: ", err)
+	if err := k.broker.CreateTopic(topic); err != nil {
+		return err
 	}
-	defer admin.Close()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 
-	maxDuration, err := time.ParseDuration("60s")
-	if err != nil {
-		k.logger.Error("failed to create kafka topic :: stacktrace ::", err)
-		return ErrFailedTopicCreation
-	}
+	k.mu.Lock()
+	k.topics[topic] = struct{}{}
+	k.mu.Unlock()
+	return nil
+}
 
-	results, err := admin.CreateTopics(
-		ctx,
-		[]kafka.TopicSpecification{
-			{
-				Topic:             topic,
-				ReplicationFactor: 3,
-				NumPartitions:     1,
-			},
-		},
-		kafka.SetAdminOperationTimeout(maxDuration),
-	)
-	if err != nil {
-		k.logger.Error("failed to create kafka topic :: stacktrace ::", err)
-		return ErrFailedTopicCreation
-	}
+func (k *KafkaHandler) NewProducer() (Producer, error) {
+	return k.broker.NewProducer()
+}
 
-	for _, result := range results {
-		if result.Error.Code() != kafka.ErrNoError && result.Error.Code() == kafka.ErrTopicAlreadyExists {
-			return ErrTopicAlreadyExists
-		}
-	}
+func (k *KafkaHandler) NewConsumer() (Consumer, error) {
+	return k.newConsumer("")
+}
 
-	k.topics[topic] = struct{}{}
-	return nil
+// newConsumer creates a consumer that joins groupID instead of the configured
+// group when groupID is set.
+func (k *KafkaHandler) newConsumer(groupID string) (Consumer, error) {
+	return k.broker.NewConsumer(groupID)
 }
 
-func (k *KafkaHandler) NewProducer() (*kafka.Producer, error) {
-	p, err := kafka.NewProducer(k.config)
-	if err != nil {
-		k.logger.Error("failed to create a new producer :: stacktrace ::", err)
-		return nil, ErrFailedProducerCreation
-	}
-	k.logger.Info("producer created succesfully")
-	return p, nil
+// Publish delivers message to topic and closes the producer once the message
+// was handed to it. Use PublishWithHeaders to publish more than one message
+// with a producer.
+func (k *KafkaHandler) Publish(producer Producer, topic string, message []byte) error {
+	return k.publish(producer, topic, message, nil, true)
 }
 
-func (k *KafkaHandler) NewConsumer() (*kafka.Consumer, error) {
-	c, err := kafka.NewConsumer(k.config)
-	if err != nil {
-		k.logger.Error("failed to create a new consumer :: stacktrace ::", err)
-		return nil, ErrFailedProducerCreation
-	}
-	k.logger.Info("consumer created succesfully")
-	return c, nil
+// PublishWithHeaders delivers message to topic along with the given headers and
+// waits for the broker to acknowledge it. The producer is left open so it can
+// be reused, closing it is up to the caller.
+func (k *KafkaHandler) PublishWithHeaders(producer Producer, topic string, message []byte, headers []kafka.Header) error {
+	return k.publish(producer, topic, message, headers, false)
 }
 
-func (k *KafkaHandler) Publish(producer *kafka.Producer, topic string, message []byte) error {
+func (k *KafkaHandler) publish(producer Producer, topic string, message []byte, headers []kafka.Header, closeProducer bool) error {
 
 	isExist, err := k.IsTopicExist(topic)
 	if err != nil {
@@ -126,20 +117,25 @@ func (k *KafkaHandler) Publish(producer *kafka.Producer, topic string, message [
 
 	// create new topic if it doesn't already exist
 	if !isExist {
-		if err := k.CreateTopic(topic); err != nil {
+		if err := k.CreateTopic(topic); err != nil && !errors.Is(err, ErrTopicAlreadyExists) {
 			return err
 		}
 	}
 
 	m := newMessage(topic, message)
+	m.Headers = headers
 
-	if err := producer.Produce(m, nil); err != nil {
+	// each publish gets its own delivery channel so concurrent callers sharing
+	// a producer don't read each other's delivery reports
+	deliveryChan := make(chan kafka.Event, 1)
+	if err := producer.Produce(m, deliveryChan); err != nil {
 		return err
 	}
-	defer producer.Close()
+	if closeProducer {
+		defer producer.Close()
+	}
 
-	// asynchronous message delivery
-	e := <-producer.Events()
+	e := <-deliveryChan
 
 	eventResponse := e.(*kafka.Message)
 	if eventResponse.TopicPartition.Error != nil {
@@ -152,7 +148,7 @@ func (k *KafkaHandler) Publish(producer *kafka.Producer, topic string, message [
 	return nil
 }
 
-func (k *KafkaHandler) Subscribe(consumer *kafka.Consumer, topics []string) (*kafka.Message, error) {
+func (k *KafkaHandler) Subscribe(consumer Consumer, topics []string) (*kafka.Message, error) {
 
 	var message *kafka.Message
 	var err error
@@ -175,23 +171,6 @@ func (k *KafkaHandler) Subscribe(consumer *kafka.Consumer, topics []string) (*ka
 	}
 }
 
-func createAdmin(config *kafka.ConfigMap) (*kafka.AdminClient, error) {
-	admin, err := kafka.NewAdminClient(config)
-	if err != nil {
-		if kErr, ok := err.(kafka.Error); ok {
-			switch kErr.Code() {
-			case kafka.ErrInvalidArg:
-				return nil, ErrInvalidAdminConfig
-
-			default:
-				return nil, err
-			}
-		}
-	}
-
-	return admin, nil
-}
-
 func newMessage(topic string, message []byte) *kafka.Message {
 	return &kafka.Message{
 		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
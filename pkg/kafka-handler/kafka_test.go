@@ -5,8 +5,8 @@ import (
 	"testing"
 
 	"github.com/adetunjii/google-sheets-connector/pkg/logger"
-	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 var (
@@ -18,50 +18,27 @@ var (
 	}
 )
 
-func setupKafkaHandler() (*kafka.ConfigMap, logger.AppLogger) {
-
-	zapLogger := logger.NewZapSugarLogger()
-	logger := logger.NewLogger(zapLogger)
-
-	config := &kafka.ConfigMap{
-		"bootstrap.servers":  "pkc-n00kk.us-east-1.aws.confluent.cloud:9092",
-		"security.protocol":  "SASL_SSL",
-		"group.id":           "googlesheets",
-		"sasl.mechanisms":    "PLAIN",
-		"sasl.username":      "7CSJM4D3YDLCKGB2",
-		"sasl.password":      "9IR9PwAmLyjh5iFzBaNfIxTIeVh2ogN46hSAm/CF37OCH/iSYwB74YPfMWa61iWD",
-		"session.timeout.ms": 6000,
-		"auto.offset.reset":  "earliest",
-		"request.timeout.ms": 100000,
-		"acks":               "all",
-	}
-
-	return config, logger
-}
-
-func newClient(t *testing.T) *KafkaHandler {
-	config, logger := setupKafkaHandler()
-	client := New(config, logger)
+func newClient(t *testing.T) (*KafkaHandler, *MemoryBroker) {
+	broker := NewMemoryBroker(1)
+	client := NewWithBroker(broker, logger.NewLogger(zap.NewNop().Sugar()))
 
 	require.NotNil(t, client)
-	return client
+	return client, broker
 }
 
-func newProducer(t *testing.T) *kafka.Producer {
-	kc := newClient(t)
-
+func newProducer(t *testing.T, kc *KafkaHandler) Producer {
 	producer, err := kc.NewProducer()
 
 	require.NoError(t, err)
-	require.NotEmpty(t, producer)
 	require.NotNil(t, producer)
+	t.Cleanup(producer.Close)
 
 	return producer
 }
 
 func TestPublish(t *testing.T) {
-	kc := newClient(t)
-	producer := newProducer(t)
+	kc, broker := newClient(t)
+	producer := newProducer(t, kc)
 
 	bytes, err := json.Marshal(testMessage)
 	require.NoError(t, err)
@@ -69,21 +46,35 @@ func TestPublish(t *testing.T) {
 	pErr := kc.Publish(producer, testPubTopic, bytes)
 	require.NoError(t, pErr)
 
+	// the topic is created on the first publish
+	exists, err := kc.IsTopicExist(testPubTopic)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	messages := broker.Messages(testPubTopic)
+	require.Len(t, messages, 1)
+	require.Equal(t, bytes, messages[0].Value)
+
+	// Publish closes the producer it was given
+	require.Error(t, kc.PublishWithHeaders(producer, testPubTopic, bytes, nil))
 }
 
-func newConsumer(t *testing.T) *kafka.Consumer {
-	kc := newClient(t)
+func newConsumer(t *testing.T, kc *KafkaHandler) Consumer {
 	consumer, err := kc.NewConsumer()
 	require.NoError(t, err)
-	require.NotEmpty(t, consumer)
 	require.NotNil(t, consumer)
 
 	return consumer
 }
 
 func TestSubscribe(t *testing.T) {
-	kc := newClient(t)
-	consumer := newConsumer(t)
+	kc, _ := newClient(t)
+
+	bytes, err := json.Marshal(testMessage)
+	require.NoError(t, err)
+	require.NoError(t, kc.Publish(newProducer(t, kc), testPubTopic, bytes))
+
+	consumer := newConsumer(t, kc)
 
 	message, err := kc.Subscribe(consumer, testSubTopics)
 	require.NoError(t, err)
@@ -0,0 +1,574 @@
+package kafkahandler
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var errClosed = errors.New("client is closed")
+
+// MemoryBroker is a Broker that keeps its topics in memory, for tests and
+// local runs. Messages produced without a partition go to the partition of
+// their key, or round robin when they have none. Consumer groups share the
+// partitions of their topics between their members and remember the offsets
+// they commit; a member gets a partition the group has committed nothing for
+// from its first message.
+type MemoryBroker struct {
+	mu         sync.Mutex
+	partitions int
+	topics     map[string]*memoryTopic
+	groups     map[string]*memoryGroup
+	// closed and replaced whenever there is something new to poll
+	changed chan struct{}
+}
+
+type memoryTopic struct {
+	partitions [][]*kafka.Message
+	// the partition of the next message without a key
+	next int
+}
+
+type memoryGroup struct {
+	// in the order they subscribed
+	members   []*memoryConsumer
+	committed map[partitionKey]kafka.Offset
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+// NewMemoryBroker returns a broker whose topics get the given number of
+// partitions when they are created with CreateTopic.
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	if partitions < 1 {
+		partitions = 1
+	}
+
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     make(map[string]*memoryTopic),
+		groups:     make(map[string]*memoryGroup),
+		changed:    make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) Topics() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	topics := make([]string, 0, len(b.topics))
+	for topic := range b.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+func (b *MemoryBroker) CreateTopic(topic string) error {
+	return b.CreateTopicWithPartitions(topic, b.partitions)
+}
+
+// CreateTopicWithPartitions creates a topic with its own number of partitions.
+func (b *MemoryBroker) CreateTopicWithPartitions(topic string, partitions int) error {
+	if partitions < 1 {
+		return ErrFailedTopicCreation
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.topics[topic]; ok {
+		return ErrTopicAlreadyExists
+	}
+	b.topics[topic] = &memoryTopic{partitions: make([][]*kafka.Message, partitions)}
+
+	// groups subscribed to the topic get its partitions
+	for _, group := range b.groups {
+		b.rebalance(group)
+	}
+	b.notify()
+	return nil
+}
+
+// Messages returns the messages of topic, partition by partition in offset
+// order.
+func (b *MemoryBroker) Messages(topic string) []*kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := []*kafka.Message{}
+	if t, ok := b.topics[topic]; ok {
+		for _, partition := range t.partitions {
+			for _, message := range partition {
+				messages = append(messages, copyMessage(message))
+			}
+		}
+	}
+	return messages
+}
+
+// Committed returns the offset groupID committed for a partition, or
+// kafka.OffsetInvalid when it committed none.
+func (b *MemoryBroker) Committed(groupID string, topic string, partition int32) kafka.Offset {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if group, ok := b.groups[groupID]; ok {
+		if offset, ok := group.committed[partitionKey{topic: topic, partition: partition}]; ok {
+			return offset
+		}
+	}
+	return kafka.OffsetInvalid
+}
+
+func (b *MemoryBroker) NewProducer() (Producer, error) {
+	return &memoryProducer{broker: b}, nil
+}
+
+func (b *MemoryBroker) NewConsumer(groupID string) (Consumer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	group, ok := b.groups[groupID]
+	if !ok {
+		group = &memoryGroup{committed: make(map[partitionKey]kafka.Offset)}
+		b.groups[groupID] = group
+	}
+
+	return &memoryConsumer{
+		broker:   b,
+		group:    group,
+		assigned: make(map[partitionKey]*memoryPartition),
+	}, nil
+}
+
+// notify wakes up everyone waiting in Poll. The caller holds b.mu.
+func (b *MemoryBroker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// rebalance spreads the partitions of the group's topics over its members,
+// the partitions of each topic split into contiguous ranges between the
+// members subscribed to it. Members whose partitions change are told so the
+// next time they poll, as they would be by Kafka's eager protocol. The
+// caller holds b.mu.
+func (b *MemoryBroker) rebalance(group *memoryGroup) {
+	owned := make(map[*memoryConsumer][]kafka.TopicPartition, len(group.members))
+
+	topics := map[string][]*memoryConsumer{}
+	for _, member := range group.members {
+		for _, topic := range member.topics {
+			if _, ok := b.topics[topic]; ok {
+				topics[topic] = append(topics[topic], member)
+			}
+		}
+	}
+
+	for topic, members := range topics {
+		count := len(b.topics[topic].partitions)
+		per, extra := count/len(members), count%len(members)
+
+		partition := 0
+		for i, member := range members {
+			n := per
+			if i < extra {
+				n++
+			}
+			for end := partition + n; partition < end; partition++ {
+				topic := topic
+				owned[member] = append(owned[member], kafka.TopicPartition{Topic: &topic, Partition: int32(partition)})
+			}
+		}
+	}
+
+	for _, member := range group.members {
+		partitions := owned[member]
+		sortPartitions(partitions)
+		if samePartitions(member.target, partitions) {
+			continue
+		}
+
+		if len(member.target) > 0 {
+			member.pending = append(member.pending, kafka.RevokedPartitions{Partitions: member.target})
+		}
+		if len(partitions) > 0 {
+			member.pending = append(member.pending, kafka.AssignedPartitions{Partitions: partitions})
+		}
+		member.target = partitions
+	}
+}
+
+func sortPartitions(partitions []kafka.TopicPartition) {
+	sort.Slice(partitions, func(i, j int) bool {
+		if *partitions[i].Topic != *partitions[j].Topic {
+			return *partitions[i].Topic < *partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+}
+
+func samePartitions(a []kafka.TopicPartition, b []kafka.TopicPartition) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if keyOf(a[i]) != keyOf(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyMessage returns a copy of message that shares no state with it.
+func copyMessage(message *kafka.Message) *kafka.Message {
+	copied := *message
+	if message.TopicPartition.Topic != nil {
+		topic := *message.TopicPartition.Topic
+		copied.TopicPartition.Topic = &topic
+	}
+	copied.Key = append([]byte(nil), message.Key...)
+	copied.Value = append([]byte(nil), message.Value...)
+	copied.Headers = append([]kafka.Header(nil), message.Headers...)
+	return &copied
+}
+
+type memoryProducer struct {
+	broker *MemoryBroker
+	closed bool
+}
+
+func (p *memoryProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	b := p.broker
+	b.mu.Lock()
+
+	if p.closed {
+		b.mu.Unlock()
+		return errClosed
+	}
+
+	stored := copyMessage(msg)
+	if stored.Timestamp.IsZero() {
+		stored.Timestamp = time.Now()
+		stored.TimestampType = kafka.TimestampCreateTime
+	}
+
+	if err := b.append(stored); err != nil {
+		stored.TopicPartition.Error = err
+	}
+	b.mu.Unlock()
+
+	if deliveryChan != nil {
+		deliveryChan <- copyMessage(stored)
+	}
+	return nil
+}
+
+// append adds message to the log of its partition, picking the partition
+// when it has none. The caller holds b.mu.
+func (b *MemoryBroker) append(message *kafka.Message) error {
+	tp := &message.TopicPartition
+	if tp.Topic == nil {
+		return kafka.NewError(kafka.ErrUnknownTopicOrPart, "Broker: Unknown topic or partition", false)
+	}
+
+	t, ok := b.topics[*tp.Topic]
+	if !ok {
+		return kafka.NewError(kafka.ErrUnknownTopicOrPart, "Broker: Unknown topic or partition", false)
+	}
+
+	if tp.Partition == kafka.PartitionAny {
+		if message.Key != nil {
+			h := fnv.New32a()
+			h.Write(message.Key)
+			tp.Partition = int32(h.Sum32() % uint32(len(t.partitions)))
+		} else {
+			tp.Partition = int32(t.next)
+			t.next = (t.next + 1) % len(t.partitions)
+		}
+	}
+
+	if tp.Partition < 0 || int(tp.Partition) >= len(t.partitions) {
+		return kafka.NewError(kafka.ErrUnknownPartition, "Local: Unknown partition", false)
+	}
+
+	tp.Offset = kafka.Offset(len(t.partitions[tp.Partition]))
+	t.partitions[tp.Partition] = append(t.partitions[tp.Partition], message)
+	b.notify()
+	return nil
+}
+
+func (p *memoryProducer) Close() {
+	p.broker.mu.Lock()
+	defer p.broker.mu.Unlock()
+
+	p.closed = true
+}
+
+// memoryPartition is where a consumer is in a partition assigned to it.
+type memoryPartition struct {
+	tp     kafka.TopicPartition
+	offset kafka.Offset
+	paused bool
+}
+
+// memoryConsumer is a member of a consumer group of a MemoryBroker. Like a
+// *kafka.Consumer it must only be polled from one goroutine at a time.
+type memoryConsumer struct {
+	broker    *MemoryBroker
+	group     *memoryGroup
+	topics    []string
+	rebalance RebalanceFunc
+	// the partitions the group gave the consumer, and the rebalance events
+	// telling it so that it has yet to see
+	target  []kafka.TopicPartition
+	pending []kafka.Event
+	// the partitions it has been told about, polled in order from cursor
+	assigned map[partitionKey]*memoryPartition
+	order    []partitionKey
+	cursor   int
+	closed   bool
+}
+
+func (c *memoryConsumer) SubscribeTopics(topics []string, rebalance RebalanceFunc) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c.closed {
+		return errClosed
+	}
+
+	if c.topics == nil {
+		c.group.members = append(c.group.members, c)
+	}
+	c.topics = append([]string{}, topics...)
+	c.rebalance = rebalance
+
+	b.rebalance(c.group)
+	b.notify()
+	return nil
+}
+
+func (c *memoryConsumer) Poll(timeoutMs int) kafka.Event {
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	defer timer.Stop()
+
+	b := c.broker
+	for {
+		b.mu.Lock()
+		if c.closed {
+			b.mu.Unlock()
+			return nil
+		}
+
+		if event := c.nextRebalance(); event != nil {
+			b.mu.Unlock()
+			c.handleRebalance(event)
+			continue
+		}
+
+		if message := c.fetch(); message != nil {
+			b.mu.Unlock()
+			return message
+		}
+
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// nextRebalance returns the rebalance event the consumer is to see next.
+// Partitions are only handed out once every member of the group gave up the
+// ones it lost, so that they can commit their offsets first. The caller holds
+// b.mu.
+func (c *memoryConsumer) nextRebalance() kafka.Event {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	event := c.pending[0]
+	if _, ok := event.(kafka.AssignedPartitions); ok {
+		for _, member := range c.group.members {
+			if member == c || len(member.pending) == 0 {
+				continue
+			}
+			if _, revoking := member.pending[0].(kafka.RevokedPartitions); revoking {
+				return nil
+			}
+		}
+	}
+	return event
+}
+
+// handleRebalance calls the rebalance callback with event and then applies
+// it. The event stays pending until then, holding back other members.
+func (c *memoryConsumer) handleRebalance(event kafka.Event) {
+	if c.rebalance != nil {
+		// like librdkafka, the assignment changes whatever the callback says
+		c.rebalance(event)
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// only Close gets in between, rebalances just add events
+	if c.closed {
+		return
+	}
+	c.pending = c.pending[1:]
+
+	switch e := event.(type) {
+	case kafka.RevokedPartitions:
+		for _, tp := range e.Partitions {
+			delete(c.assigned, keyOf(tp))
+		}
+	case kafka.AssignedPartitions:
+		for _, tp := range e.Partitions {
+			// from the first message when the group committed nothing
+			key := keyOf(tp)
+			c.assigned[key] = &memoryPartition{tp: tp, offset: c.group.committed[key]}
+		}
+	}
+
+	c.order = c.order[:0]
+	for key := range c.assigned {
+		c.order = append(c.order, key)
+	}
+	sort.Slice(c.order, func(i, j int) bool {
+		if c.order[i].topic != c.order[j].topic {
+			return c.order[i].topic < c.order[j].topic
+		}
+		return c.order[i].partition < c.order[j].partition
+	})
+	c.cursor = 0
+
+	// the members held back by this one may go on
+	b.notify()
+}
+
+// fetch returns the next message of the first partition after the cursor
+// that has one. The caller holds b.mu.
+func (c *memoryConsumer) fetch() *kafka.Message {
+	for i := range c.order {
+		key := c.order[(c.cursor+i)%len(c.order)]
+		p := c.assigned[key]
+		if p.paused {
+			continue
+		}
+
+		log := c.broker.topics[key.topic].partitions[key.partition]
+		if int(p.offset) >= len(log) {
+			continue
+		}
+
+		message := copyMessage(log[p.offset])
+		p.offset++
+		c.cursor = (c.cursor + i + 1) % len(c.order)
+		return message
+	}
+	return nil
+}
+
+func (c *memoryConsumer) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, kafka.NewError(kafka.ErrTimedOut, "Local: Timed out", false)
+		}
+
+		if message, ok := c.Poll(int(remaining.Milliseconds())).(*kafka.Message); ok {
+			return message, nil
+		}
+	}
+}
+
+func (c *memoryConsumer) CommitOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c.closed {
+		return nil, errClosed
+	}
+
+	for _, tp := range offsets {
+		c.group.committed[keyOf(tp)] = tp.Offset
+	}
+	return offsets, nil
+}
+
+func (c *memoryConsumer) Pause(partitions []kafka.TopicPartition) error {
+	return c.setPaused(partitions, true)
+}
+
+func (c *memoryConsumer) Resume(partitions []kafka.TopicPartition) error {
+	return c.setPaused(partitions, false)
+}
+
+func (c *memoryConsumer) setPaused(partitions []kafka.TopicPartition, paused bool) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, tp := range partitions {
+		if p, ok := c.assigned[keyOf(tp)]; ok {
+			p.paused = paused
+		}
+	}
+
+	b.notify()
+	return nil
+}
+
+func (c *memoryConsumer) Seek(partition kafka.TopicPartition, timeoutMs int) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, ok := c.assigned[keyOf(partition)]
+	if !ok {
+		return kafka.NewError(kafka.ErrState, "Local: Erroneous state", false)
+	}
+
+	p.offset = partition.Offset
+	b.notify()
+	return nil
+}
+
+// Close leaves the consumer group, whose other members get its partitions.
+// Offsets are not committed on the way, that is up to the caller.
+func (c *memoryConsumer) Close() error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c.closed {
+		return ErrFailedConsumerClose
+	}
+	c.closed = true
+
+	members := c.group.members[:0]
+	for _, member := range c.group.members {
+		if member != c {
+			members = append(members, member)
+		}
+	}
+	c.group.members = members
+
+	b.rebalance(c.group)
+	b.notify()
+	return nil
+}
@@ -0,0 +1,221 @@
+package kafkahandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/require"
+)
+
+func produce(t *testing.T, producer Producer, message *kafka.Message) *kafka.Message {
+	delivery := make(chan kafka.Event, 1)
+	require.NoError(t, producer.Produce(message, delivery))
+
+	report := (<-delivery).(*kafka.Message)
+	require.NoError(t, report.TopicPartition.Error)
+	return report
+}
+
+func read(t *testing.T, consumer Consumer) *kafka.Message {
+	message, err := consumer.ReadMessage(time.Second)
+	require.NoError(t, err)
+	return message
+}
+
+func TestMemoryBrokerPartitions(t *testing.T) {
+	broker := NewMemoryBroker(3)
+	require.NoError(t, broker.CreateTopic(testPubTopic))
+	require.ErrorIs(t, broker.CreateTopic(testPubTopic), ErrTopicAlreadyExists)
+
+	producer, err := broker.NewProducer()
+	require.NoError(t, err)
+
+	// messages with the same key stay in order on one partition
+	first := produce(t, producer, &kafka.Message{TopicPartition: newMessage(testPubTopic, nil).TopicPartition, Key: []byte("sheet"), Value: []byte("1")})
+	second := produce(t, producer, &kafka.Message{
+		TopicPartition: newMessage(testPubTopic, nil).TopicPartition,
+		Key:            []byte("sheet"),
+		Value:          []byte("2"),
+		Headers:        []kafka.Header{{Key: HeaderAttempt, Value: []byte("2")}},
+	})
+	require.Equal(t, first.TopicPartition.Partition, second.TopicPartition.Partition)
+	require.Equal(t, first.TopicPartition.Offset+1, second.TopicPartition.Offset)
+
+	consumer, err := broker.NewConsumer("group")
+	require.NoError(t, err)
+	require.NoError(t, consumer.SubscribeTopics([]string{testPubTopic}, nil))
+
+	require.Equal(t, []byte("1"), read(t, consumer).Value)
+	message := read(t, consumer)
+	require.Equal(t, []byte("2"), message.Value)
+	require.Equal(t, 2, Attempt(message))
+
+	_, err = consumer.ReadMessage(10 * time.Millisecond)
+	require.Equal(t, kafka.ErrTimedOut, err.(kafka.Error).Code())
+
+	delivery := make(chan kafka.Event, 1)
+	require.NoError(t, producer.Produce(newMessage("missing", []byte("lost")), delivery))
+	require.Error(t, (<-delivery).(*kafka.Message).TopicPartition.Error)
+}
+
+func TestMemoryBrokerConsumerGroups(t *testing.T) {
+	broker := NewMemoryBroker(2)
+	require.NoError(t, broker.CreateTopic(testPubTopic))
+
+	producer, err := broker.NewProducer()
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		produce(t, producer, newMessage(testPubTopic, []byte(fmt.Sprint(i))))
+	}
+
+	subscribe := func(group string) Consumer {
+		consumer, err := broker.NewConsumer(group)
+		require.NoError(t, err)
+		require.NoError(t, consumer.SubscribeTopics([]string{testPubTopic}, nil))
+		return consumer
+	}
+
+	// the members of a group split the partitions between them
+	a, b := subscribe("group"), subscribe("group")
+	fromA, fromB := read(t, a), read(t, b)
+	require.NotEqual(t, fromA.TopicPartition.Partition, fromB.TopicPartition.Partition)
+
+	// another group reads everything on its own
+	other := subscribe("other")
+	for i := 0; i < 4; i++ {
+		read(t, other)
+	}
+
+	// a leaving member's partitions go to the others, which go on from the
+	// committed offsets
+	for _, handled := range []struct {
+		consumer Consumer
+		message  *kafka.Message
+	}{{a, fromA}, {b, fromB}} {
+		tp := handled.message.TopicPartition
+		tp.Offset++
+		_, err = handled.consumer.CommitOffsets([]kafka.TopicPartition{tp})
+		require.NoError(t, err)
+	}
+	require.NoError(t, a.Close())
+	require.Equal(t, fromA.TopicPartition.Offset+1, broker.Committed("group", testPubTopic, fromA.TopicPartition.Partition))
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		seen[string(read(t, b).Value)] = true
+	}
+	require.False(t, seen[string(fromA.Value)])
+	require.False(t, seen[string(fromB.Value)])
+
+	_, err = b.ReadMessage(10 * time.Millisecond)
+	require.Error(t, err)
+}
+
+func TestSubscriberCommitsHandledMessages(t *testing.T) {
+	kc, broker := newClient(t)
+	producer := newProducer(t, kc)
+
+	var (
+		mu      sync.Mutex
+		handled []string
+		failed  bool
+	)
+	done := make(chan struct{})
+	handler := func(ctx context.Context, message *kafka.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// the first failure is retried rather than skipped
+		if string(message.Value) == "1" && !failed {
+			failed = true
+			return errors.New("sheet unavailable")
+		}
+
+		handled = append(handled, string(message.Value))
+		if len(handled) == 3 {
+			close(done)
+		}
+		return nil
+	}
+
+	subscriber, err := kc.NewSubscriber(testSubTopics, handler, CommitBatchSize(1), GroupID("sheets"), PollTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, kc.PublishWithHeaders(producer, testPubTopic, []byte(fmt.Sprint(i)), nil))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- subscriber.Run(ctx) }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages were not handled")
+	}
+	cancel()
+	require.NoError(t, <-stopped)
+
+	require.Equal(t, []string{"0", "1", "2"}, handled)
+	require.Equal(t, kafka.Offset(3), broker.Committed("sheets", testPubTopic, 0))
+}
+
+func TestSubscriberKeepsPollingWhileRetrying(t *testing.T) {
+	kc, broker := newClient(t)
+	producer := newProducer(t, kc)
+	require.NoError(t, broker.CreateTopicWithPartitions(testPubTopic, 2))
+
+	var (
+		mu      sync.Mutex
+		handled []string
+	)
+	done := make(chan struct{})
+	handler := func(ctx context.Context, message *kafka.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// the stuck message only goes through once the other partition got
+		// its message handled, which it couldn't were it retried in place
+		if string(message.Value) == "stuck" && len(handled) == 0 {
+			return errors.New("dead-letter topic unavailable")
+		}
+
+		handled = append(handled, string(message.Value))
+		if len(handled) == 2 {
+			close(done)
+		}
+		return nil
+	}
+
+	subscriber, err := kc.NewSubscriber(testSubTopics, handler, CommitBatchSize(1), GroupID("sheets"), PollTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	for partition, value := range []string{"stuck", "free"} {
+		produce(t, producer, &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &testPubTopic, Partition: int32(partition)},
+			Value:          []byte(value),
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- subscriber.Run(ctx) }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages were not handled")
+	}
+	cancel()
+	require.NoError(t, <-stopped)
+
+	require.Equal(t, []string{"free", "stuck"}, handled)
+	require.Equal(t, kafka.Offset(1), broker.Committed("sheets", testPubTopic, 0))
+	require.Equal(t, kafka.Offset(1), broker.Committed("sheets", testPubTopic, 1))
+}
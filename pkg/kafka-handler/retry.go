@@ -0,0 +1,147 @@
+package kafkahandler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// HeaderRetryNotBefore holds the unix time in milliseconds before which a
+// message on a retry topic must not be handled.
+const HeaderRetryNotBefore = "x-retry-not-before"
+
+var ErrRetriesExhausted = errors.New("message has exhausted all retry attempts")
+
+// RetryPolicy describes the retry topics a failed message moves through. Each
+// delay gets its own topic, named <TopicPrefix>.retry-<delay>, and a message
+// that fails again on the last tier stays on it until MaxAttempts is reached.
+type RetryPolicy struct {
+	TopicPrefix string
+	Delays      []time.Duration
+	// MaxAttempts counts every attempt, including the first one on the source topic.
+	MaxAttempts int
+}
+
+// Topics returns the retry topics in the order messages move through them.
+func (p RetryPolicy) Topics() []string {
+	topics := make([]string, len(p.Delays))
+	for i, delay := range p.Delays {
+		topics[i] = p.topic(delay)
+	}
+	return topics
+}
+
+func (p RetryPolicy) topic(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry-%s", p.TopicPrefix, formatDelay(delay))
+}
+
+// delay returns how long to wait before the attempt after the given one.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	tier := attempt - 1
+	if tier >= len(p.Delays) {
+		tier = len(p.Delays) - 1
+	}
+	return p.Delays[tier]
+}
+
+// RetryPublisher republishes messages that failed with a transient error to
+// the retry topic matching their attempt count.
+type RetryPublisher struct {
+	handler  *KafkaHandler
+	producer Producer
+	policy   RetryPolicy
+}
+
+func (k *KafkaHandler) NewRetryPublisher(policy RetryPolicy) (*RetryPublisher, error) {
+	if len(policy.Delays) == 0 {
+		return nil, errors.New("retry policy needs at least one delay")
+	}
+
+	producer, err := k.NewProducer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RetryPublisher{
+		handler:  k,
+		producer: producer,
+		policy:   policy,
+	}, nil
+}
+
+// Publish schedules message for another attempt after the delay of its
+// retry tier. It returns ErrRetriesExhausted once the message has been
+// attempted MaxAttempts times.
+func (r *RetryPublisher) Publish(message *kafka.Message, reason error) error {
+	attempt := Attempt(message)
+	if attempt >= r.policy.MaxAttempts {
+		return ErrRetriesExhausted
+	}
+
+	delay := r.policy.delay(attempt)
+	topic := r.policy.topic(delay)
+	sourceTopic, partition, offset := Source(message)
+
+	headers := []kafka.Header{
+		{Key: HeaderErrorReason, Value: []byte(reason.Error())},
+		{Key: HeaderSourceTopic, Value: []byte(sourceTopic)},
+		{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(int(partition)))},
+		{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(int64(offset), 10))},
+		{Key: HeaderAttempt, Value: []byte(strconv.Itoa(attempt + 1))},
+		{Key: HeaderRetryNotBefore, Value: []byte(strconv.FormatInt(time.Now().Add(delay).UnixMilli(), 10))},
+	}
+
+	if err := r.handler.PublishWithHeaders(r.producer, topic, message.Value, headers); err != nil {
+		return fmt.Errorf("failed to publish message to retry topic %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+func (r *RetryPublisher) Close() {
+	r.producer.Close()
+}
+
+// NotBefore returns the earliest time message may be handled, or the zero
+// time when it carries no retry delay.
+func NotBefore(message *kafka.Message) time.Time {
+	value, ok := headerValue(message, HeaderRetryNotBefore)
+	if !ok {
+		return time.Time{}
+	}
+
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// ParseRetryDelays turns a list such as ["30s", "5m", "1h"] into durations.
+func ParseRetryDelays(values []string) ([]time.Duration, error) {
+	delays := make([]time.Duration, 0, len(values))
+	for _, value := range values {
+		delay, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry delay %q: %w", value, err)
+		}
+		delays = append(delays, delay)
+	}
+	return delays, nil
+}
+
+// formatDelay renders a delay the way people write it, 5m rather than 5m0s.
+func formatDelay(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,35 @@
+package kafkahandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	delays, err := ParseRetryDelays([]string{"30s", " 5m", "1h"})
+	require.NoError(t, err)
+
+	policy := RetryPolicy{TopicPrefix: "googlesheets", Delays: delays, MaxAttempts: 5}
+
+	require.Equal(t, []string{"googlesheets.retry-30s", "googlesheets.retry-5m", "googlesheets.retry-1h"}, policy.Topics())
+
+	require.Equal(t, 30*time.Second, policy.delay(1))
+	require.Equal(t, 5*time.Minute, policy.delay(2))
+	require.Equal(t, time.Hour, policy.delay(3))
+	// attempts past the last tier keep using it
+	require.Equal(t, time.Hour, policy.delay(4))
+
+	_, err = ParseRetryDelays([]string{"soon"})
+	require.Error(t, err)
+}
+
+func TestNotBefore(t *testing.T) {
+	message := &kafka.Message{TopicPartition: topicPartition(testPubTopic, 0, 1)}
+	require.True(t, NotBefore(message).IsZero())
+
+	message.Headers = []kafka.Header{{Key: HeaderRetryNotBefore, Value: []byte("1700000000000")}}
+	require.Equal(t, time.UnixMilli(1700000000000), NotBefore(message))
+}
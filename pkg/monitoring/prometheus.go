@@ -27,6 +27,11 @@ type metricsDefinition struct {
 	responseStatusCounter *prometheus.CounterVec
 	timeCounterSummary    *prometheus.SummaryVec
 	timeCounterHistogram  *prometheus.HistogramVec
+	workersGauge          *prometheus.GaugeVec
+	queueCapacityGauge    *prometheus.GaugeVec
+	queueLengthGauge      *prometheus.GaugeVec
+	sheetsThrottled       *prometheus.CounterVec
+	sheetsRetries         *prometheus.CounterVec
 }
 
 var metrics metricsDefinition
@@ -72,13 +77,66 @@ func (m *MetricsHandler) registerMetrics(metricsLabelPrefix string) {
 		)
 	}
 
+	if metrics.workersGauge == nil {
+		metrics.workersGauge = initGaugeVec(
+			"dispatcher_workers",
+			"Number of workers handling broker messages",
+			metricsLabelPrefix,
+		)
+	}
+
+	if metrics.queueCapacityGauge == nil {
+		metrics.queueCapacityGauge = initGaugeVec(
+			"dispatcher_queue_capacity",
+			"Number of messages each dispatcher worker can queue",
+			metricsLabelPrefix,
+		)
+	}
+
+	if metrics.queueLengthGauge == nil {
+		metrics.queueLengthGauge = initGaugeVec(
+			"dispatcher_queue_length",
+			"Number of messages queued by worker",
+			metricsLabelPrefix,
+			"worker",
+		)
+	}
+
+	if metrics.sheetsThrottled == nil {
+		metrics.sheetsThrottled = initCounterVec(
+			"sheets_throttled_total",
+			"Google Sheets API calls held back by a rate limit, by scope",
+			metricsLabelPrefix,
+			"scope",
+		)
+	}
+
+	if metrics.sheetsRetries == nil {
+		metrics.sheetsRetries = initCounterVec(
+			"sheets_retries_total",
+			"Google Sheets API calls retried, by response status",
+			metricsLabelPrefix,
+			"response_status",
+		)
+	}
+
 	// register each of the collectors and check for errors
-	for _, c := range []prometheus.Collector{metrics.opsCounter, metrics.responseStatusCounter, metrics.timeCounterHistogram, metrics.timeCounterSummary} {
+	for _, c := range []prometheus.Collector{
+		metrics.opsCounter,
+		metrics.responseStatusCounter,
+		metrics.timeCounterHistogram,
+		metrics.timeCounterSummary,
+		metrics.workersGauge,
+		metrics.queueCapacityGauge,
+		metrics.queueLengthGauge,
+		metrics.sheetsThrottled,
+		metrics.sheetsRetries,
+	} {
 		if err := prometheus.DefaultRegisterer.Register(c); err != nil {
 			promErr := prometheus.AlreadyRegisteredError{}
 			if errors.As(err, &promErr) {
 				//TODO: use app logger
-				log.Printf("prometheus collector already registered", err)
+				log.Printf("prometheus collector already registered: %v", err)
 			}
 		}
 	}
@@ -109,6 +167,26 @@ func (m *MetricsHandler) MetricsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+func (m *MetricsHandler) SetWorkers(count int) {
+	metrics.workersGauge.WithLabelValues(m.options.ID, m.options.Name, m.options.Version).Set(float64(count))
+}
+
+func (m *MetricsHandler) SetQueueCapacity(capacity int) {
+	metrics.queueCapacityGauge.WithLabelValues(m.options.ID, m.options.Name, m.options.Version).Set(float64(capacity))
+}
+
+func (m *MetricsHandler) SetQueueLength(worker int, length int) {
+	metrics.queueLengthGauge.WithLabelValues(m.options.ID, m.options.Name, m.options.Version, strconv.Itoa(worker)).Set(float64(length))
+}
+
+func (m *MetricsHandler) SheetsThrottled(scope string) {
+	metrics.sheetsThrottled.WithLabelValues(m.options.ID, m.options.Name, m.options.Version, scope).Inc()
+}
+
+func (m *MetricsHandler) SheetsRetried(statusCode int) {
+	metrics.sheetsRetries.WithLabelValues(m.options.ID, m.options.Name, m.options.Version, strconv.Itoa(statusCode)).Inc()
+}
+
 func initMetrics(opts []Option) *MetricsHandler {
 	options := Options{}
 	for _, opt := range opts {
@@ -214,3 +292,43 @@ func initHistogramVec(name, help, metricsLabelPrefix string) *prometheus.Histogr
 		},
 	)
 }
+
+func initGaugeVec(name, help, metricsLabelPrefix string, labels ...string) *prometheus.GaugeVec {
+	labelNames := []string{
+		fmt.Sprintf("%s_%s", metricsLabelPrefix, "id"),
+		fmt.Sprintf("%s_%s", metricsLabelPrefix, "name"),
+		fmt.Sprintf("%s_%s", metricsLabelPrefix, "version"),
+	}
+
+	for _, label := range labels {
+		labelNames = append(labelNames, fmt.Sprintf("%s_%s", metricsLabelPrefix, label))
+	}
+
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		labelNames,
+	)
+}
+
+func initCounterVec(name, help, metricsLabelPrefix string, labels ...string) *prometheus.CounterVec {
+	labelNames := []string{
+		fmt.Sprintf("%s_%s", metricsLabelPrefix, "id"),
+		fmt.Sprintf("%s_%s", metricsLabelPrefix, "name"),
+		fmt.Sprintf("%s_%s", metricsLabelPrefix, "version"),
+	}
+
+	for _, label := range labels {
+		labelNames = append(labelNames, fmt.Sprintf("%s_%s", metricsLabelPrefix, label))
+	}
+
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: name,
+			Help: help,
+		},
+		labelNames,
+	)
+}
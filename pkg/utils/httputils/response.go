@@ -24,6 +24,13 @@ func (rw *responseWriter) WriteJSON(message []byte) {
 	rw.Write(message)
 }
 
+// WriteJSONWithStatus is WriteJSON with a status code other than 200.
+func (rw *responseWriter) WriteJSONWithStatus(message []byte, statusCode int) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	rw.Write(message)
+}
+
 func (rw *responseWriter) Error(error error, code int) {
 	rw.WriteHeader(code)
 	fmt.Fprintln(rw, error.Error())